@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/boar-d-white-foundation/drone/config"
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/dbq"
+	"github.com/boar-d-white-foundation/drone/tg"
+	tele "gopkg.in/telebot.v3"
+)
+
+// chunkLen is the max length of a telegram message
+const chunkLen = 4096
+
+// Service handles commands sent to the admin chat
+type Service struct {
+	database    db.DB
+	adminChatID int64
+}
+
+func NewService(database db.DB, adminChatID int64) *Service {
+	return &Service{
+		database:    database,
+		adminChatID: adminChatID,
+	}
+}
+
+func NewServiceFromConfig(cfg config.Config, database db.DB) *Service {
+	return NewService(database, cfg.Tg.AdminChatID)
+}
+
+// RegisterCommands registers commands of the admin chat
+func (s *Service) RegisterCommands(ctx context.Context, router *tg.Router) error {
+	return router.Handle(tg.Command{
+		Name:        "dbq",
+		Args:        "<command> [args]",
+		Description: "inspect and manage dbq queues, send without args for the list of commands",
+		ChatID:      s.adminChatID,
+		Handler: func(c tele.Context, args tg.Args) error {
+			return s.OnDBQCommand(ctx, c, args)
+		},
+	})
+}
+
+// OnDBQCommand runs /dbq <command> [args] and replies with its output, see dbq.CommandUsage
+func (s *Service) OnDBQCommand(ctx context.Context, c tele.Context, args tg.Args) error {
+	var out bytes.Buffer
+	if err := dbq.RunCommand(ctx, s.database, &out, args.Strings()); err != nil {
+		out.Reset()
+		fmt.Fprintf(&out, "error: %s", err)
+	}
+	if out.Len() == 0 {
+		out.WriteString("no output")
+	}
+
+	return sendMonospace(c, out.String())
+}
+
+func sendMonospace(c tele.Context, text string) error {
+	for i := 0; i < len(text); i += chunkLen {
+		chunk := text[i:min(i+chunkLen, len(text))]
+		err := c.Send(chunk, &tele.SendOptions{
+			Entities: []tele.MessageEntity{
+				{
+					Type:   tele.EntityCode,
+					Offset: 0, // TODO: this technically should be in utf-16 code points
+					Length: len(chunk),
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("send chunk %q: %w", chunk, err)
+		}
+	}
+
+	return nil
+}
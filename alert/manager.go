@@ -23,6 +23,10 @@ func NewManager(
 
 func (m *Manager) send(msg string) {
 	slog.Error("sending alert", slog.String("msg", msg))
+	m.sendRaw(msg)
+}
+
+func (m *Manager) sendRaw(msg string) {
 	if err := m.sender.SendAlert(msg); err != nil {
 		slog.Error("err sending alert", slog.String("msg", msg), slog.Any("err", err))
 	}
@@ -38,3 +42,10 @@ func (m *Manager) Errorxf(err error, msg string, args ...any) {
 	errMsg = fmt.Sprintf("Error:\n%s\n\n%s", errMsg, err.Error())
 	m.send(errMsg)
 }
+
+// Infof reports a notable event which is not an error, e.g. a successful backup
+func (m *Manager) Infof(msg string, args ...any) {
+	infoMsg := fmt.Sprintf(msg, args...)
+	slog.Info("sending info", slog.String("msg", infoMsg))
+	m.sendRaw(infoMsg)
+}
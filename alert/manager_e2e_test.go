@@ -6,6 +6,7 @@ import (
 	"errors"
 	"testing"
 
+	"github.com/boar-d-white-foundation/drone/alert"
 	"github.com/boar-d-white-foundation/drone/config"
 	"github.com/boar-d-white-foundation/drone/tg"
 	"github.com/stretchr/testify/require"
@@ -15,10 +16,10 @@ func TestAlert(t *testing.T) {
 	cfg, err := config.Load(config.Path())
 	require.NoError(t, err)
 
-	adminTGClient, err := tg.NewAdminClientFromConfig(cfg)
+	tgService, err := tg.NewServiceFromConfig(cfg)
 	require.NoError(t, err)
 
-	alerts := alert.NewManager(adminTGClient)
+	alerts := alert.NewManager(tgService)
 
 	err = errors.New("some err")
 	alerts.Errorxf(err, "test err %s", "test arg")
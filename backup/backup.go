@@ -0,0 +1,193 @@
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/config"
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+)
+
+const (
+	filePrefix = "drone_"
+	fileSuffix = ".json.gz"
+	// sortable and safe to use in file names
+	fileTimeLayout = "20060102T150405Z"
+)
+
+// Service dumps the running db into a folder, so backups don't require stopping the bot
+type Service struct {
+	database db.DB
+	alerts   *alert.Manager
+	folder   string
+	keep     int
+	now      func() time.Time
+}
+
+func NewService(database db.DB, alerts *alert.Manager, folder string, keep int) *Service {
+	return &Service{
+		database: database,
+		alerts:   alerts,
+		folder:   folder,
+		keep:     keep,
+		now:      time.Now,
+	}
+}
+
+func NewServiceFromConfig(cfg config.Config, database db.DB, alerts *alert.Manager) *Service {
+	return NewService(database, alerts, cfg.Backup.Folder, cfg.Backup.Keep)
+}
+
+// Backup writes a gzip dump of the db, verifies it by restoring into an in-memory db
+// and removes old dumps leaving only the newest ones
+func (s *Service) Backup(ctx context.Context) error {
+	if err := os.MkdirAll(s.folder, 0700); err != nil {
+		return fmt.Errorf("create backup folder: %w", err)
+	}
+
+	path := filepath.Join(s.folder, filePrefix+s.now().UTC().Format(fileTimeLayout)+fileSuffix)
+	// the dump is renamed only after it's verified, so unfinished dumps are never rotated in
+	tmpPath := path + ".tmp"
+	header, err := s.dump(ctx, tmpPath)
+	if err != nil {
+		return s.removeTmp(tmpPath, fmt.Errorf("dump: %w", err))
+	}
+	if err := verify(ctx, tmpPath, header); err != nil {
+		return s.removeTmp(tmpPath, fmt.Errorf("verify %s: %w", tmpPath, err))
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return s.removeTmp(tmpPath, fmt.Errorf("rename dump: %w", err))
+	}
+
+	removed, err := s.rotate()
+	if err != nil {
+		return fmt.Errorf("rotate backups: %w", err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat dump: %w", err)
+	}
+
+	slog.Info("backup OK", slog.String("path", path), slog.Int("key_count", header.KeyCount))
+	s.alerts.Infof(
+		"backup OK: %s\nkeys: %d, size: %d bytes, removed old: %d",
+		path, header.KeyCount, stat.Size(), removed,
+	)
+	return nil
+}
+
+func (s *Service) dump(ctx context.Context, path string) (db.DumpHeader, error) {
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return db.DumpHeader{}, fmt.Errorf("open dump file: %w", err)
+	}
+	defer func() {
+		if err := fd.Close(); err != nil {
+			slog.Error("failed to close dump file", slog.Any("err", err))
+		}
+	}()
+
+	writer, err := gzip.NewWriterLevel(fd, gzip.BestCompression)
+	if err != nil {
+		return db.DumpHeader{}, fmt.Errorf("create gzip writer: %w", err)
+	}
+	writer.Name = filepath.Base(path)
+	writer.ModTime = s.now()
+
+	header, err := db.DumpJson(ctx, s.database, writer, db.DumpOpts{
+		AppliedMigrationsKey: db.AppliedMigrationsKey,
+	})
+	if err != nil {
+		return db.DumpHeader{}, err
+	}
+	if err := writer.Close(); err != nil {
+		return db.DumpHeader{}, fmt.Errorf("close gzip writer: %w", err)
+	}
+	if err := fd.Sync(); err != nil {
+		return db.DumpHeader{}, fmt.Errorf("sync dump file: %w", err)
+	}
+
+	return header, nil
+}
+
+func verify(ctx context.Context, path string, header db.DumpHeader) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open dump file: %w", err)
+	}
+	defer func() {
+		if err := fd.Close(); err != nil {
+			slog.Error("failed to close dump file", slog.Any("err", err))
+		}
+	}()
+
+	reader, err := gzip.NewReader(fd)
+	if err != nil {
+		return fmt.Errorf("create gzip reader: %w", err)
+	}
+
+	database := memdb.New()
+	if err := database.Start(ctx); err != nil {
+		return fmt.Errorf("start memdb: %w", err)
+	}
+	defer database.Stop()
+
+	result, err := db.RestoreJson(ctx, database, reader, db.RestoreOpts{})
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	if result.Restored != header.KeyCount {
+		return fmt.Errorf("restored %d keys, dumped %d", result.Restored, header.KeyCount)
+	}
+
+	return nil
+}
+
+// rotate removes all dumps except the newest s.keep ones, it returns the number of removed dumps
+func (s *Service) rotate() (int, error) {
+	entries, err := os.ReadDir(s.folder)
+	if err != nil {
+		return 0, fmt.Errorf("read backup folder: %w", err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			names = append(names, name)
+		}
+	}
+	if len(names) <= s.keep {
+		return 0, nil
+	}
+
+	// names contain the dump time, so the oldest go first
+	slices.Sort(names)
+	old := names[:len(names)-s.keep]
+	for _, name := range old {
+		if err := os.Remove(filepath.Join(s.folder, name)); err != nil {
+			return 0, fmt.Errorf("remove %s: %w", name, err)
+		}
+		slog.Info("removed old backup", slog.String("name", name))
+	}
+
+	return len(old), nil
+}
+
+func (s *Service) removeTmp(path string, err error) error {
+	if rmErr := os.Remove(path); rmErr != nil && !os.IsNotExist(rmErr) {
+		slog.Error("failed to remove unfinished dump", slog.String("path", path), slog.Any("err", rmErr))
+	}
+
+	return err
+}
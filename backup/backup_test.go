@@ -0,0 +1,69 @@
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/boar-d-white-foundation/drone/tg/tgtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackup(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	database := memdb.New()
+	require.NoError(t, database.Start(ctx))
+	defer database.Stop()
+
+	err := database.Do(ctx, func(tx db.Tx) error {
+		for i := range 10 {
+			require.NoError(t, db.SetJson(tx, fmt.Sprintf("key:%d", i), i))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	sender := tgtest.NewClient(42)
+	folder := filepath.Join(t.TempDir(), "backups")
+	service := NewService(database, alert.NewManager(sender), folder, 2)
+	now := time.Date(2024, 5, 1, 3, 30, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+
+	for range 3 {
+		require.NoError(t, service.Backup(ctx))
+		now = now.Add(24 * time.Hour)
+	}
+
+	entries, err := os.ReadDir(folder)
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.Equal(t, []string{"drone_20240502T033000Z.json.gz", "drone_20240503T033000Z.json.gz"}, names)
+	alerts := sender.Alerts()
+	require.Len(t, alerts, 3)
+	require.Contains(t, alerts[2], "removed old: 1")
+
+	fd, err := os.Open(filepath.Join(folder, names[1]))
+	require.NoError(t, err)
+	defer fd.Close()
+	reader, err := gzip.NewReader(fd)
+	require.NoError(t, err)
+
+	restoreDB := memdb.New()
+	require.NoError(t, restoreDB.Start(ctx))
+	defer restoreDB.Stop()
+	result, err := db.RestoreJson(ctx, restoreDB, reader, db.RestoreOpts{})
+	require.NoError(t, err)
+	require.Equal(t, 10, result.Restored)
+}
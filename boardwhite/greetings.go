@@ -17,7 +17,7 @@ func (s *Service) OnGreetJoinedUser(ctx context.Context, c tele.Context) error {
 	}
 
 	return s.database.Do(ctx, func(tx db.Tx) error {
-		greetedUsers, err := db.GetJsonDefault(tx, keyOnJoinGreetedUsers, make(map[int64]struct{}))
+		greetedUsers, err := s.keys.onJoinGreetedUsers.GetDefault(tx, make(map[int64]struct{}))
 		if err != nil {
 			return fmt.Errorf("get greetedUsers: %w", err)
 		}
@@ -36,14 +36,14 @@ func (s *Service) OnGreetJoinedUser(ctx context.Context, c tele.Context) error {
 
 		username := tg.BuildMentionMarkdownV2(*msg.UserJoined)
 		greetMessage := fmt.Sprintf(template, username)
-		_, err = s.telegram.SendMarkdownV2(s.cfg.FloodThreadID, greetMessage)
+		_, err = s.telegram.SendMarkdownV2(s.target(s.cfg.FloodThreadID), greetMessage)
 		if err != nil {
 			return fmt.Errorf("greet user: %w", err)
 		}
 
 		greetedUsers[msg.UserJoined.ID] = struct{}{}
-		if err := db.SetJson(tx, keyOnJoinGreetedUsers, greetedUsers); err != nil {
-			return fmt.Errorf("set keyOnJoinGreetedUsers: %w", err)
+		if err := s.keys.onJoinGreetedUsers.Set(tx, greetedUsers); err != nil {
+			return fmt.Errorf("set s.keys.onJoinGreetedUsers: %w", err)
 		}
 
 		return nil
@@ -9,39 +9,58 @@ import (
 )
 
 func (s *Service) RegisterHandlers(ctx context.Context, registry tg.HandlerRegistry) {
-	lcStatsHandler := s.makeStatsHandler(
-		keyLCPinnedMessages,
-		keyLCPinnedToStatsDayInfo,
-		keyLCStats,
-		ratingOpts{},
-	)
-	lcChickensStatsHandler := s.makeStatsHandler(
-		keyLCChickensPinnedMessages,
-		keyLCChickensPinnedToStatsDayInfo,
-		keyLCChickensStats,
-		ratingOpts{noComplexityEstimations: true},
-	)
-	ncStatsHandler := s.makeStatsHandler(
-		keyNCPinnedMessages,
-		keyNCPinnedToStatsDayInfo,
-		keyNCStats,
-		ratingOpts{},
-	)
+	lcStatsHandler := s.makeStatsHandler(s.keys.lcStats, ratingOpts{})
+	lcChickensStatsHandler := s.makeStatsHandler(s.keys.lcChickensStats, ratingOpts{noComplexityEstimations: true})
+	ncStatsHandler := s.makeStatsHandler(s.keys.ncStats, ratingOpts{})
+	// every community registers the same handlers, names tell them apart in alerts
+	register := func(endpoint, name string, f func(context.Context, tele.Context) error) {
+		registry.RegisterHandler(endpoint, s.cfg.Name+":"+name, withContext(ctx, f))
+	}
+
+	register(tele.OnText, "OnLeetCodeUpdateText", lcStatsHandler)
+	register(tele.OnPhoto, "OnLeetCodeUpdatePhoto", lcStatsHandler)
+	register(tele.OnText, "OnLeetCodeChickensUpdateText", lcChickensStatsHandler)
+	register(tele.OnPhoto, "OnLeetCodeChickensUpdatePhoto", lcChickensStatsHandler)
+	register(tele.OnText, "OnNeetCodeUpdateText", ncStatsHandler)
+	register(tele.OnPhoto, "OnNeetCodeUpdatePhoto", ncStatsHandler)
+	register(tele.OnText, "OnMock", s.OnMock)
+	register(tele.OnPinned, "OnBotPinned", s.OnBotPinned)
+	register(tele.OnUserJoined, "OnGreetJoinedUser", s.OnGreetJoinedUser)
+	register(tele.OnText, "OnPostTwitterEmbed", s.OnPostTwitterEmbed)
+	register(tele.OnText, "OnOborona", s.OnOborona)
+	register(tele.OnText, "OnUpdateOkr", s.OnUpdateOkr)
+}
+
+// RegisterCommands registers commands of the community chat
+func (s *Service) RegisterCommands(ctx context.Context, router *tg.Router) error {
+	commands := []tg.Command{
+		{
+			Name:        "pdf",
+			Args:        "[link]",
+			Description: "dump a vc.ru article to pdf, the link may be in the replied message",
+			Handler:     withContextArgs(ctx, s.OnGenerateVCPdf),
+		},
+		{
+			Name:        okrRemoveCommand,
+			Args:        "[tags]",
+			Description: "remove okr tags of the replied message, all of them if no tags are given",
+			Handler:     withContextArgs(ctx, s.OnRemoveOkr),
+		},
+	}
+	for _, cmd := range commands {
+		cmd.ChatID = s.cfg.ChatID
+		if err := router.Handle(cmd); err != nil {
+			return fmt.Errorf("handle %s: %w", cmd.Name, err)
+		}
+	}
 
-	registry.RegisterHandler(tele.OnText, "OnLeetCodeUpdateText", withContext(ctx, lcStatsHandler))
-	registry.RegisterHandler(tele.OnPhoto, "OnLeetCodeUpdatePhoto", withContext(ctx, lcStatsHandler))
-	registry.RegisterHandler(tele.OnText, "OnLeetCodeChickensUpdateText", withContext(ctx, lcChickensStatsHandler))
-	registry.RegisterHandler(tele.OnPhoto, "OnLeetCodeChickensUpdatePhoto", withContext(ctx, lcChickensStatsHandler))
-	registry.RegisterHandler(tele.OnText, "OnNeetCodeUpdateText", withContext(ctx, ncStatsHandler))
-	registry.RegisterHandler(tele.OnPhoto, "OnNeetCodeUpdatePhoto", withContext(ctx, ncStatsHandler))
-	registry.RegisterHandler(tele.OnText, "OnMock", withContext(ctx, s.OnMock))
-	registry.RegisterHandler(tele.OnPinned, "OnBotPinned", withContext(ctx, s.OnBotPinned))
-	registry.RegisterHandler(tele.OnUserJoined, "OnGreetJoinedUser", withContext(ctx, s.OnGreetJoinedUser))
-	registry.RegisterHandler(tele.OnText, "OnGenerateVCPdf", withContext(ctx, s.OnGenerateVCPdf))
-	registry.RegisterHandler(tele.OnText, "OnPostTwitterEmbed", withContext(ctx, s.OnPostTwitterEmbed))
-	registry.RegisterHandler(tele.OnText, "OnOborona", withContext(ctx, s.OnOborona))
-	registry.RegisterHandler(tele.OnText, "OnUpdateOkr", withContext(ctx, s.OnUpdateOkr))
-	registry.RegisterHandler(tele.OnText, "OnRemoveOkr", withContext(ctx, s.OnRemoveOkr))
+	return nil
+}
+
+func withContextArgs(ctx context.Context, f func(context.Context, tele.Context, tg.Args) error) tg.CommandHandler {
+	return func(c tele.Context, args tg.Args) error {
+		return f(ctx, c, args)
+	}
 }
 
 func withContext(ctx context.Context, f func(context.Context, tele.Context) error) tele.HandlerFunc {
@@ -51,12 +70,15 @@ func withContext(ctx context.Context, f func(context.Context, tele.Context) erro
 }
 
 func (s *Service) OnBotPinned(ctx context.Context, c tele.Context) error {
-	msg := c.Message()
-	if msg == nil || msg.PinnedMessage == nil || msg.Sender == nil || msg.Sender.ID != s.telegram.BotID() {
+	msg, chat := c.Message(), c.Chat()
+	if msg == nil || chat == nil || chat.ID != s.cfg.ChatID {
+		return nil
+	}
+	if msg.PinnedMessage == nil || msg.Sender == nil || msg.Sender.ID != s.telegram.BotID() {
 		return nil
 	}
 
-	err := s.telegram.Delete(msg.ID)
+	err := s.telegram.Delete(s.cfg.ChatID, msg.ID)
 	if err != nil {
 		return fmt.Errorf("delete pinned message notification: %w", err)
 	}
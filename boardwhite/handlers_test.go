@@ -0,0 +1,196 @@
+package boardwhite
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/boar-d-white-foundation/drone/tg"
+	"github.com/boar-d-white-foundation/drone/tg/tgtest"
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	testChatID             = -100
+	testLCThreadID         = 10
+	testFloodThreadID      = 11
+	testInterviewsThreadID = 12
+)
+
+func newTestService(t *testing.T) (*Service, *tgtest.Client) {
+	t.Helper()
+
+	database := memdb.New()
+	require.NoError(t, database.Start(context.Background()))
+	t.Cleanup(database.Stop)
+
+	client := tgtest.NewClient(42)
+	return newCommunityService(t, DefaultName, testChatID, database, client), client
+}
+
+// newCommunityService returns a service of the community with test thread IDs
+func newCommunityService(t *testing.T, name string, chatID int64, database db.DB, client *tgtest.Client) *Service {
+	t.Helper()
+
+	cfg := Config{
+		Name:                       name,
+		ChatID:                     chatID,
+		LeetcodeThreadID:           testLCThreadID,
+		DailyStickersIDs:           []string{"sticker"},
+		DpStickerID:                "dp_sticker",
+		FloodThreadID:              testFloodThreadID,
+		GreetingsNewUsersTemplates: []string{"new %s"},
+		GreetingsOldUsersTemplates: []string{"old %s"},
+		InterviewsThreadID:         testInterviewsThreadID,
+	}
+	vcLinkRe := regexp.MustCompile(`(?P<link>https://vc\.ru/[^/]+/\d+)`)
+	service, err := NewService(cfg, client, database, alert.NewManager(client), nil, nil, vcLinkRe)
+	require.NoError(t, err)
+	return service
+}
+
+func testMessage(id int, sender tele.User, text string) tele.Message {
+	return tele.Message{
+		ID:       id,
+		Sender:   &sender,
+		Chat:     &tele.Chat{ID: testChatID, Type: tele.ChatSuperGroup},
+		ThreadID: testLCThreadID,
+		Text:     text,
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, client := newTestService(t)
+	handler := service.makeStatsHandler(service.keys.ncStats, ratingOpts{})
+	user := tele.User{ID: 7, Username: "boar"}
+	solve := func(id int, replyTo int, spoiler bool) {
+		msg := testMessage(id, user, "")
+		msg.ReplyTo = &tele.Message{ID: replyTo, Sender: client.Bot()}
+		msg.Photo = &tele.Photo{File: tele.File{FileID: "solution"}}
+		msg.HasMediaSpoiler = spoiler
+		require.NoError(t, handler(ctx, client.NewMessageContext(msg)))
+	}
+
+	require.NoError(t, service.PublishNCDaily(ctx))
+	daily := client.Messages()[0]
+	require.Equal(t, tgtest.KindSpoilerLink, daily.Kind)
+	require.Equal(t, testLCThreadID, daily.ThreadID)
+	client.RequireLastMessage(t, tgtest.KindSticker)
+	client.RequirePinned(t, testChatID, daily.ID)
+
+	solve(1001, daily.ID, true)
+	client.RequireReaction(t, testChatID, 1001, tg.ReactionOk)
+
+	solve(1002, daily.ID, false)
+	client.RequireReaction(t, testChatID, 1002, tg.ReactionClown)
+
+	solve(1003, daily.ID+100, true)
+	client.RequireNoReaction(t, testChatID, 1003)
+
+	require.NoError(t, service.PublishNCDaily(ctx))
+	next := client.Messages()[2]
+	client.RequirePinned(t, testChatID, next.ID)
+	solve(1004, daily.ID, true)
+	client.RequireReaction(t, testChatID, 1004, tg.ReactionMoai)
+
+	require.NoError(t, service.PublishNCRating(ctx))
+	rating := client.RequireLastMessage(t, tgtest.KindMarkdownV2)
+	require.Contains(t, rating.Text, "@boar")
+	client.RequireNoAlerts(t)
+}
+
+func TestOnUpdateOkr(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, client := newTestService(t)
+	user := tele.User{ID: 7, Username: "boar"}
+
+	msg := testMessage(1001, user, "finally "+okrTagFaangOffer.String()+" "+okrTagFaangOffer.String())
+	require.NoError(t, service.OnUpdateOkr(ctx, client.NewMessageContext(msg)))
+	client.RequireReaction(t, testChatID, 1001, tg.ReactionWriting)
+	progress := client.RequireLastMessage(t, tgtest.KindText)
+	require.Equal(t, testInterviewsThreadID, progress.ThreadID)
+	require.Contains(t, progress.Text, "2/3 в FAANG")
+	client.RequirePinned(t, testChatID, progress.ID)
+
+	msg = testMessage(1002, user, okrTagStaffPromo.String())
+	require.NoError(t, service.OnUpdateOkr(ctx, client.NewMessageContext(msg)))
+	client.RequireReaction(t, testChatID, 1002, tg.ReactionWriting)
+	client.RequireMessagesCount(t, 1)
+	require.Contains(t, client.RequireLastEdit(t, testChatID, progress.ID), "1/1 на стаффа (#staff_promo2025) ✅")
+
+	msg = testMessage(1003, user, "no tags")
+	require.NoError(t, service.OnUpdateOkr(ctx, client.NewMessageContext(msg)))
+	msg = testMessage(1004, user, "/"+okrRemoveCommand+" "+okrTagStaffPromo.String())
+	require.NoError(t, service.OnUpdateOkr(ctx, client.NewMessageContext(msg)))
+	client.RequireNoReaction(t, testChatID, 1003)
+	client.RequireNoReaction(t, testChatID, 1004)
+	require.Len(t, client.Edits(), 1)
+	client.RequireNoAlerts(t)
+}
+
+func TestOnGreetJoinedUser(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, client := newTestService(t)
+	join := func(user tele.User) {
+		msg := testMessage(0, user, "")
+		msg.UserJoined = &user
+		require.NoError(t, service.OnGreetJoinedUser(ctx, client.NewMessageContext(msg)))
+	}
+
+	join(tele.User{ID: 7, Username: "boar"})
+	greeting := client.RequireLastMessage(t, tgtest.KindMarkdownV2)
+	require.Equal(t, testFloodThreadID, greeting.ThreadID)
+	require.Equal(t, "new @boar", greeting.Text)
+
+	join(tele.User{ID: 7, Username: "boar"})
+	require.Equal(t, "old @boar", client.RequireLastMessage(t, tgtest.KindMarkdownV2).Text)
+
+	join(tele.User{ID: 8, IsBot: true, Username: "other_bot"})
+	client.RequireMessagesCount(t, 2)
+	client.RequireNoAlerts(t)
+}
+
+func TestCommunities(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	database := memdb.New()
+	require.NoError(t, database.Start(ctx))
+	t.Cleanup(database.Stop)
+
+	const otherChatID = -200
+	client := tgtest.NewClient(42)
+	bw := newCommunityService(t, DefaultName, testChatID, database, client)
+	other := newCommunityService(t, "other", otherChatID, database, client)
+	user := tele.User{ID: 7, Username: "boar"}
+
+	msg := testMessage(1001, user, okrTagFaangOffer.String())
+	msg.Chat.ID = otherChatID
+	require.NoError(t, bw.OnUpdateOkr(ctx, client.NewMessageContext(msg)))
+	client.RequireMessagesCount(t, 0)
+	require.NoError(t, other.OnUpdateOkr(ctx, client.NewMessageContext(msg)))
+	client.RequireReaction(t, otherChatID, 1001, tg.ReactionWriting)
+	progress := client.RequireLastMessage(t, tgtest.KindText)
+	require.Equal(t, int64(otherChatID), progress.ChatID)
+	client.RequirePinned(t, otherChatID, progress.ID)
+	client.RequirePinned(t, testChatID)
+
+	msg = testMessage(1002, user, okrTagFaangOffer.String())
+	require.NoError(t, bw.OnUpdateOkr(ctx, client.NewMessageContext(msg)))
+	progress = client.RequireLastMessage(t, tgtest.KindText)
+	require.Equal(t, int64(testChatID), progress.ChatID)
+	require.Contains(t, progress.Text, "1/3 в FAANG")
+	client.RequirePinned(t, testChatID, progress.ID)
+	client.RequireNoAlerts(t)
+}
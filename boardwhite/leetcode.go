@@ -28,19 +28,18 @@ func (s *Service) PublishLCDaily(ctx context.Context) error {
 	}
 
 	return s.database.Do(ctx, func(tx db.Tx) error {
-		lastDayInfo, err := s.getLastPublishedQuestionDayInfo(tx, keyLCPinnedToStatsDayInfo)
+		lastDayInfo, err := s.getLastPublishedQuestionDayInfo(tx, s.keys.lcStats)
 		if err != nil {
 			return fmt.Errorf("get last published lc question: %w", err)
 		}
 
 		_, err = s.publishDaily(tx, publishDailyReq{
-			dayIdx:          lastDayInfo.DayIdx + 1,
-			threadID:        s.cfg.LeetcodeThreadID,
-			header:          defaultDailyHeader,
-			text:            dailyInfo.Link,
-			stickerID:       stickerID,
-			pinnedMsgsKey:   keyLCPinnedMessages,
-			msgToDayInfoKey: keyLCPinnedToStatsDayInfo,
+			dayIdx:    lastDayInfo.DayIdx + 1,
+			threadID:  s.cfg.LeetcodeThreadID,
+			header:    defaultDailyHeader,
+			text:      dailyInfo.Link,
+			stickerID: stickerID,
+			statsKeys: s.keys.lcStats,
 		})
 		if err != nil {
 			return fmt.Errorf("publish lc daily: %w", err)
@@ -57,8 +56,7 @@ func (s *Service) PublishLCRating(ctx context.Context) error {
 		ratingOpts{},
 		"Leetcode leaderboard (last 35 questions):",
 		s.cfg.LeetcodeThreadID,
-		keyLCPinnedToStatsDayInfo,
-		keyLCStats,
+		s.keys.lcStats,
 	)
 }
 
@@ -98,16 +96,16 @@ func newLCChickenQuestions() (lcChickenQuestions, error) {
 	return result, nil
 }
 
-func (cq *lcChickenQuestions) getNextQuestion(tx db.Tx) (leetcode.Question, error) {
-	idx, err := db.GetJsonDefault[int](tx, keyLCChickensFallbackQuestionIdx, 0)
+func (cq *lcChickenQuestions) getNextQuestion(tx db.Tx, idxKey db.Key[int]) (leetcode.Question, error) {
+	idx, err := idxKey.GetDefault(tx, 0)
 	if err != nil {
 		return leetcode.Question{}, fmt.Errorf("get idx: %w", err)
 	}
 
 	question := cq.questions[cq.shuffledPosition[idx%len(cq.questions)]]
 	idx++
-	if err := db.SetJson(tx, keyLCChickensFallbackQuestionIdx, idx); err != nil {
-		return leetcode.Question{}, fmt.Errorf("set keyLCChickensFallbackQuestionIdx: %w", err)
+	if err := idxKey.Set(tx, idx); err != nil {
+		return leetcode.Question{}, fmt.Errorf("set s.keys.lcChickensFallbackQuestionIdx: %w", err)
 	}
 
 	return question, nil
@@ -125,7 +123,7 @@ func (s *Service) PublishLCChickensDaily(ctx context.Context) error {
 	}
 
 	return s.database.Do(ctx, func(tx db.Tx) error {
-		lastDayInfo, err := s.getLastPublishedQuestionDayInfo(tx, keyLCChickensPinnedToStatsDayInfo)
+		lastDayInfo, err := s.getLastPublishedQuestionDayInfo(tx, s.keys.lcChickensStats)
 		if err != nil {
 			return fmt.Errorf("get last published lc question: %w", err)
 		}
@@ -136,13 +134,12 @@ func (s *Service) PublishLCChickensDaily(ctx context.Context) error {
 		}
 
 		_, err = s.publishDaily(tx, publishDailyReq{
-			dayIdx:          lastDayInfo.DayIdx + 1,
-			threadID:        s.cfg.LeetcodeChickensThreadID,
-			header:          defaultDailyChickenHeader,
-			text:            link,
-			stickerID:       stickerID,
-			pinnedMsgsKey:   keyLCChickensPinnedMessages,
-			msgToDayInfoKey: keyLCChickensPinnedToStatsDayInfo,
+			dayIdx:    lastDayInfo.DayIdx + 1,
+			threadID:  s.cfg.LeetcodeChickensThreadID,
+			header:    defaultDailyChickenHeader,
+			text:      link,
+			stickerID: stickerID,
+			statsKeys: s.keys.lcChickensStats,
 		})
 		if err != nil {
 			return fmt.Errorf("publish lc checkens daily: %w", err)
@@ -162,7 +159,7 @@ func (s *Service) selectLCChickensDailyLink(
 	}
 
 	slog.Info("daily is not easy, selecting fallback")
-	question, err := s.lcChickenQuestions.getNextQuestion(tx)
+	question, err := s.lcChickenQuestions.getNextQuestion(tx, s.keys.lcChickensFallbackQuestionIdx)
 	if err != nil {
 		return "", fmt.Errorf("get fallback question: %w", err)
 	}
@@ -177,7 +174,6 @@ func (s *Service) PublishLCChickensRating(ctx context.Context) error {
 		ratingOpts{noComplexityEstimations: true},
 		"Leetcode easy leaderboard (last 35 questions):",
 		s.cfg.LeetcodeChickensThreadID,
-		keyLCChickensPinnedToStatsDayInfo,
-		keyLCChickensStats,
+		s.keys.lcChickensStats,
 	)
 }
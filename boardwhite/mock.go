@@ -13,8 +13,8 @@ import (
 	tele "gopkg.in/telebot.v3"
 )
 
-func mockKey(username string) string {
-	return fmt.Sprintf("boardwhite:mock:%s:next", username)
+func (k keys) mock(username string) db.Key[time.Time] {
+	return k.mocks.Keyf("%s:next", username)
 }
 
 func (s *Service) OnMock(ctx context.Context, c tele.Context) error {
@@ -29,9 +29,9 @@ func (s *Service) OnMock(ctx context.Context, c tele.Context) error {
 		return nil
 	}
 
-	key := mockKey(username)
+	key := s.keys.mock(username)
 	return s.database.Do(ctx, func(tx db.Tx) error {
-		mockAt, err := db.GetJson[time.Time](tx, key)
+		mockAt, err := key.Get(tx)
 		switch {
 		case err == nil:
 			if mockAt.After(time.Now()) {
@@ -49,7 +49,7 @@ func (s *Service) OnMock(ctx context.Context, c tele.Context) error {
 			return fmt.Errorf("pick random sticker: %w", err)
 		}
 
-		_, err = s.telegram.ReplyWithSticker(msg.ID, stickerID)
+		_, err = s.telegram.ReplyWithSticker(s.cfg.ChatID, msg.ID, stickerID)
 		if err != nil {
 			return fmt.Errorf("reply with sticker: %w", err)
 		}
@@ -63,7 +63,7 @@ func (s *Service) OnMock(ctx context.Context, c tele.Context) error {
 		next := time.Duration(int64(from)+offset.Int64()) * time.Second
 		nextMock := time.Now().Add(next)
 
-		if err := db.SetJson(tx, key, nextMock); err != nil {
+		if err := key.Set(tx, nextMock); err != nil {
 			return fmt.Errorf("set %q: %w", key, err)
 		}
 
@@ -12,7 +12,7 @@ import (
 
 func (s *Service) PublishNCDaily(ctx context.Context) error {
 	return s.database.Do(ctx, func(tx db.Tx) error {
-		lastDayInfo, err := s.getLastPublishedQuestionDayInfo(tx, keyNCPinnedToStatsDayInfo)
+		lastDayInfo, err := s.getLastPublishedQuestionDayInfo(tx, s.keys.ncStats)
 		if err != nil {
 			return fmt.Errorf("get last published nc question: %w", err)
 		}
@@ -55,13 +55,12 @@ func (s *Service) PublishNCDaily(ctx context.Context) error {
 		}
 
 		_, err = s.publishDaily(tx, publishDailyReq{
-			dayIdx:          lastDayInfo.DayIdx + 1,
-			threadID:        s.cfg.LeetcodeThreadID,
-			header:          header,
-			text:            link.String(),
-			stickerID:       stickerID,
-			pinnedMsgsKey:   keyNCPinnedMessages,
-			msgToDayInfoKey: keyNCPinnedToStatsDayInfo,
+			dayIdx:    lastDayInfo.DayIdx + 1,
+			threadID:  s.cfg.LeetcodeThreadID,
+			header:    header,
+			text:      link.String(),
+			stickerID: stickerID,
+			statsKeys: s.keys.ncStats,
 		})
 		if err != nil {
 			return fmt.Errorf("publish nc daily: %w", err)
@@ -78,7 +77,6 @@ func (s *Service) PublishNCRating(ctx context.Context) error {
 		ratingOpts{},
 		"Neetcode leaderboard (last 35 questions):",
 		s.cfg.LeetcodeThreadID,
-		keyNCPinnedToStatsDayInfo,
-		keyNCStats,
+		s.keys.ncStats,
 	)
 }
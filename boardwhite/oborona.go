@@ -31,7 +31,7 @@ func (s *Service) OnOborona(ctx context.Context, c tele.Context) error {
 	}
 
 	return s.database.Do(ctx, func(tx db.Tx) error {
-		generatedAt, err := db.GetJsonDefault[time.Time](tx, keyOboronaLastGeneratedAt, time.Time{})
+		generatedAt, err := s.keys.oboronaLastGeneratedAt.GetDefault(tx, time.Time{})
 		if err != nil {
 			return fmt.Errorf("get oborona generated at: %w", err)
 		}
@@ -45,12 +45,12 @@ func (s *Service) OnOborona(ctx context.Context, c tele.Context) error {
 			return fmt.Errorf("generate oborona: %w", err)
 		}
 
-		_, err = s.telegram.ReplyWithText(msg.ID, oborona)
+		_, err = s.telegram.ReplyWithText(s.cfg.ChatID, msg.ID, oborona)
 		if err != nil {
 			return fmt.Errorf("reply with oborona: %w", err)
 		}
 
-		if err := db.SetJson(tx, keyOboronaLastGeneratedAt, time.Now()); err != nil {
+		if err := s.keys.oboronaLastGeneratedAt.Set(tx, time.Now()); err != nil {
 			return fmt.Errorf("set oborona generated at: %w", err)
 		}
 
@@ -32,7 +32,7 @@ var (
 	okrTagUnfortunately    okrTag = "#unfortunately2025"
 )
 
-const okrRemoveCommand = "/remove_okr"
+const okrRemoveCommand = "remove_okr"
 
 type okrGoal struct {
 	Goal int
@@ -138,7 +138,7 @@ func (s *Service) OnUpdateOkr(ctx context.Context, c tele.Context) error {
 	if msg == nil || chat == nil || chat.ID != s.cfg.ChatID || msg.IsForwarded() {
 		return nil
 	}
-	if strings.HasPrefix(msg.Text, okrRemoveCommand) {
+	if name, _, _, ok := tg.ParseCommand(msg.Text); ok && name == okrRemoveCommand {
 		return nil
 	}
 
@@ -147,9 +147,9 @@ func (s *Service) OnUpdateOkr(ctx context.Context, c tele.Context) error {
 		return nil
 	}
 
-	set := tg.SetReactionFor(s.telegram, msg.ID)
+	set := tg.SetReactionFor(s.telegram, s.cfg.ChatID, msg.ID)
 	return s.database.Do(ctx, func(tx db.Tx) error {
-		okrs, err := db.GetJsonDefault(tx, keyOkrValues, okrs{})
+		okrs, err := s.keys.okrValues.GetDefault(tx, okrs{})
 		if err != nil {
 			return fmt.Errorf("get okr values: %w", err)
 		}
@@ -171,24 +171,17 @@ func (s *Service) OnUpdateOkr(ctx context.Context, c tele.Context) error {
 	})
 }
 
-func (s *Service) OnRemoveOkr(ctx context.Context, c tele.Context) error {
-	msg, chat := c.Message(), c.Chat()
-	if msg == nil || chat == nil || chat.ID != s.cfg.ChatID {
-		return nil
-	}
-	if !strings.HasPrefix(msg.Text, okrRemoveCommand) {
-		return nil
-	}
-
-	set := tg.SetReactionFor(s.telegram, msg.ID)
+func (s *Service) OnRemoveOkr(ctx context.Context, c tele.Context, args tg.Args) error {
+	msg := c.Message()
+	set := tg.SetReactionFor(s.telegram, s.cfg.ChatID, msg.ID)
 	if msg.ReplyTo == nil {
 		return set(tg.ReactionClown)
 	}
 
-	countsToRemove := extractOkrTagsCounts(msg.Text)
-	removeAll := msg.Text == okrRemoveCommand
+	countsToRemove := extractOkrTagsCounts(args.Raw())
+	removeAll := args.Len() == 0
 	return s.database.Do(ctx, func(tx db.Tx) error {
-		okrs, err := db.GetJsonDefault(tx, keyOkrValues, okrs{})
+		okrs, err := s.keys.okrValues.GetDefault(tx, okrs{})
 		if err != nil {
 			return fmt.Errorf("get okr values: %w", err)
 		}
@@ -232,7 +225,7 @@ func (s *Service) OnRemoveOkr(ctx context.Context, c tele.Context) error {
 }
 
 func (s *Service) saveOkrsAndUpsertTgMsg(tx db.Tx, okrs okrs) error {
-	if err := db.SetJson(tx, keyOkrValues, okrs); err != nil {
+	if err := s.keys.okrValues.Set(tx, okrs); err != nil {
 		return fmt.Errorf("save okrs: %w", err)
 	}
 
@@ -260,7 +253,7 @@ func extractOkrTagsCounts(msgText string) map[okrTag]int {
 }
 
 func (s *Service) upsertPinnedOkrMsg(tx db.Tx, progressMessage string) error {
-	pinnedMsgID, err := db.GetJson[int](tx, keyOkrPinnedMessage)
+	pinnedMsgID, err := s.keys.okrPinnedMessage.Get(tx)
 	if errors.Is(err, db.ErrKeyNotFound) {
 		if err := s.postNewOkrMessage(tx, progressMessage); err != nil {
 			return fmt.Errorf("post initial okr message: %w", err)
@@ -271,7 +264,7 @@ func (s *Service) upsertPinnedOkrMsg(tx db.Tx, progressMessage string) error {
 		return fmt.Errorf("get pinned message id: %w", err)
 	}
 
-	if err := s.telegram.EditMessageText(pinnedMsgID, progressMessage); err != nil {
+	if err := s.telegram.EditMessageText(s.cfg.ChatID, pinnedMsgID, progressMessage); err != nil {
 		return fmt.Errorf("edit pinned okr message: %w", err)
 	}
 
@@ -279,16 +272,16 @@ func (s *Service) upsertPinnedOkrMsg(tx db.Tx, progressMessage string) error {
 }
 
 func (s *Service) postNewOkrMessage(tx db.Tx, progressMessage string) error {
-	messageID, err := s.telegram.SendText(s.cfg.InterviewsThreadID, progressMessage)
+	messageID, err := s.telegram.SendText(s.target(s.cfg.InterviewsThreadID), progressMessage)
 	if err != nil {
 		return fmt.Errorf("send okr message: %w", err)
 	}
 
-	if err := s.telegram.Pin(messageID); err != nil {
+	if err := s.telegram.Pin(s.cfg.ChatID, messageID); err != nil {
 		return fmt.Errorf("pin okr message: %w", err)
 	}
 
-	if err := db.SetJson(tx, keyOkrPinnedMessage, messageID); err != nil {
+	if err := s.keys.okrPinnedMessage.Set(tx, messageID); err != nil {
 		return fmt.Errorf("save new pinned okr message id: %w", err)
 	}
 
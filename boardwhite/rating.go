@@ -5,13 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
-	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/dbq"
 	"github.com/boar-d-white-foundation/drone/leetcode"
 	"github.com/boar-d-white-foundation/drone/retry"
 	"github.com/boar-d-white-foundation/drone/tg"
@@ -54,25 +54,95 @@ type solution struct {
 
 type statsDayInfo struct {
 	DayIdx      int64     `json:"day_idx"`
+	MessageID   int       `json:"message_id"`
 	PublishedAt time.Time `json:"published_at"`
 }
 
 type stats struct {
 	Solutions map[solutionKey]solution `json:"solutions"`
-	DaysInfo  map[int64]statsDayInfo   `json:"days_info"`
 }
 
-func (s *Service) getLastPublishedQuestionDayInfo(tx db.Tx, msgToDayInfoKey string) (statsDayInfo, error) {
+// statsKeys is a namespace like "boardwhite:leetcode" holding a record per key:
+// <ns>:day_info:<day_idx> -> statsDayInfo
+// <ns>:pinned_message:<message_id> -> day_idx
+// <ns>:solution:<day_idx>:<user_id> -> solution
+// day_idx is zero padded to keep days ordered in range scans
+type statsKeys struct {
+	ns             string
+	dayInfos       db.KeyFamily[statsDayInfo]
+	pinnedMessages db.KeyFamily[int64]
+	solutions      db.KeyFamily[solution]
+}
+
+func declareStatsKeys(ns string) statsKeys {
+	return statsKeys{
+		ns:             ns,
+		dayInfos:       db.DeclareKeyFamily[statsDayInfo](keysOwner, ns+":day_info:"),
+		pinnedMessages: db.DeclareKeyFamily[int64](keysOwner, ns+":pinned_message:"),
+		solutions:      db.DeclareKeyFamily[solution](keysOwner, ns+":solution:"),
+	}
+}
+
+func (k statsKeys) dayInfo(dayIdx int64) db.Key[statsDayInfo] {
+	return k.dayInfos.Keyf("%010d", dayIdx)
+}
+
+func (k statsKeys) pinnedMessage(messageID int) db.Key[int64] {
+	return k.pinnedMessages.Keyf("%d", messageID)
+}
+
+func (k statsKeys) solutionDayPrefix(dayIdx int64) string {
+	return fmt.Sprintf("%s%010d:", k.solutions.Prefix(), dayIdx)
+}
+
+func (k statsKeys) solution(key solutionKey) db.Key[solution] {
+	return k.solutions.Keyf("%010d:%d", key.DayIdx, key.UserID)
+}
+
+// parseSolution parses a key suffix of the solutions family
+func (k statsKeys) parseSolution(suffix string) (solutionKey, error) {
+	var result solutionKey
+	if err := result.UnmarshalText([]byte(strings.Replace(suffix, ":", "|", 1))); err != nil {
+		return solutionKey{}, fmt.Errorf("parse solution key %q: %w", suffix, err)
+	}
+
+	return result, nil
+}
+
+func (s *Service) getLastPublishedQuestionDayInfo(tx db.ReadTx, keys statsKeys) (statsDayInfo, error) {
 	result := statsDayInfo{DayIdx: -1}
-	msgToDayInfo, err := db.GetJsonDefault(tx, msgToDayInfoKey, make(map[int]statsDayInfo))
+	opts := db.IterOpts{Reverse: true}
+	err := keys.dayInfos.Iterate(tx, opts, func(_ string, dayInfo statsDayInfo) error {
+		result = dayInfo
+		return db.ErrStopIteration
+	})
 	if err != nil {
-		return statsDayInfo{}, fmt.Errorf("get msgToDayInfo: %w", err)
+		return statsDayInfo{}, fmt.Errorf("get last day info: %w", err)
 	}
 
-	for _, dayInfo := range msgToDayInfo {
-		if dayInfo.DayIdx > result.DayIdx {
-			result = dayInfo
+	return result, nil
+}
+
+// loadStats reads solutions for days in [dayIdxFrom, dayIdxTo] range
+func loadStats(tx db.ReadTx, keys statsKeys, dayIdxFrom, dayIdxTo int64) (stats, error) {
+	result := stats{
+		Solutions: make(map[solutionKey]solution),
+	}
+	opts := db.IterOpts{
+		From: []byte(keys.solutionDayPrefix(max(dayIdxFrom, 0))),
+		To:   []byte(keys.solutionDayPrefix(dayIdxTo + 1)),
+	}
+	err := keys.solutions.Iterate(tx, opts, func(suffix string, sol solution) error {
+		solKey, err := keys.parseSolution(suffix)
+		if err != nil {
+			return err
 		}
+
+		result.Solutions[solKey] = sol
+		return nil
+	})
+	if err != nil {
+		return stats{}, fmt.Errorf("iterate solutions: %w", err)
 	}
 
 	return result, nil
@@ -86,28 +156,27 @@ func okReaction(hasComplexityEstimate bool) tg.Reaction {
 }
 
 func (s *Service) makeStatsHandler(
-	pinnedMessagesKey string,
-	msgToDayInfoKey string,
-	statsKey string,
+	keys statsKeys,
 	ratingOpts ratingOpts,
 ) func(context.Context, tele.Context) error {
 	return func(ctx context.Context, c tele.Context) error {
-		update, msg, sender := c.Update(), c.Message(), c.Sender()
-		if msg == nil || sender == nil {
+		update, msg, sender, chat := c.Update(), c.Message(), c.Sender(), c.Chat()
+		if msg == nil || sender == nil || chat == nil || chat.ID != s.cfg.ChatID {
 			return nil
 		}
 		if msg.ReplyTo == nil || msg.ReplyTo.Sender.ID != c.Bot().Me.ID {
 			return nil
 		}
 
-		set := tg.SetReactionFor(s.telegram, msg.ID)
+		set := tg.SetReactionFor(s.telegram, s.cfg.ChatID, msg.ID)
 		return s.database.Do(ctx, func(tx db.Tx) error {
-			pinnedIDs, err := db.GetJsonDefault[[]int](tx, pinnedMessagesKey, nil)
-			if err != nil {
-				return fmt.Errorf("get pinnedIDs: %w", err)
-			}
-			if !slices.Contains(pinnedIDs, msg.ReplyTo.ID) {
+			dayIdx, err := keys.pinnedMessage(msg.ReplyTo.ID).Get(tx)
+			switch {
+			case err == nil:
+			case errors.Is(err, db.ErrKeyNotFound):
 				return nil
+			default:
+				return fmt.Errorf("get pinned message day: %w", err)
 			}
 
 			switch {
@@ -142,12 +211,18 @@ func (s *Service) makeStatsHandler(
 				}
 
 				if s.mediaGenerator != nil {
-					err := s.tasks.postCodeSnippet.Schedule(tx, 1, postCodeSnippetArgs{
+					// a reposted submission already has its snippet
+					idempotencyKey := fmt.Sprintf("%s:%d", submission.ID, msg.ThreadID)
+					task := s.tasks.postCodeSnippet.WithIdempotencyKey(idempotencyKey)
+					err := task.Schedule(tx, 1, postCodeSnippetArgs{
 						MessageID:  msg.ID,
 						ThreadID:   msg.ThreadID,
 						Submission: submission,
 					})
-					if err != nil {
+					switch {
+					case errors.Is(err, dbq.ErrDuplicateTask):
+						slog.Info("skip posting duplicate code snippet", slog.Any("err", err))
+					case err != nil:
 						s.alerts.Errorxf(err, "err schedule post code snippet: %v", msg.Text)
 					}
 				}
@@ -159,37 +234,24 @@ func (s *Service) makeStatsHandler(
 				return set(tg.ReactionClown)
 			}
 
-			if msg.ReplyTo.ID != pinnedIDs[len(pinnedIDs)-1] {
-				return set(tg.ReactionMoai) // deadline miss
-			}
-
-			stats, err := db.GetJsonDefault[stats](tx, statsKey, stats{})
-			if err != nil {
-				return fmt.Errorf("get nc stats: %w", err)
-			}
-			if stats.Solutions == nil {
-				stats.Solutions = make(map[solutionKey]solution)
-			}
-			if stats.DaysInfo == nil {
-				stats.DaysInfo = make(map[int64]statsDayInfo)
-			}
-
-			msgToDayIdx, err := db.GetJsonDefault(tx, msgToDayInfoKey, make(map[int]statsDayInfo))
+			lastDayInfo, err := s.getLastPublishedQuestionDayInfo(tx, keys)
 			if err != nil {
-				return fmt.Errorf("get msgToDayIdx: %w", err)
+				return fmt.Errorf("get last published question: %w", err)
 			}
-
-			dayInfo, ok := msgToDayIdx[msg.ReplyTo.ID]
-			if !ok {
-				return nil
+			if dayIdx != lastDayInfo.DayIdx {
+				return set(tg.ReactionMoai) // deadline miss
 			}
 
-			key := solutionKey{
-				DayIdx: dayInfo.DayIdx,
+			solKey := keys.solution(solutionKey{
+				DayIdx: dayIdx,
 				UserID: sender.ID,
+			})
+			oldSol, err := solKey.Get(tx)
+			ok := err == nil
+			if err != nil && !errors.Is(err, db.ErrKeyNotFound) {
+				return fmt.Errorf("get solution: %w", err)
 			}
 
-			oldSol, ok := stats.Solutions[key]
 			hasComplexityEstimate := !ratingOpts.noComplexityEstimations && extractEstimatedComplexity(*msg).isFull()
 			oldSolHasComplexityEstimate := !ratingOpts.noComplexityEstimations && oldSol.Update.Message != nil &&
 				extractEstimatedComplexity(*oldSol.Update.Message).isFull()
@@ -198,10 +260,8 @@ func (s *Service) makeStatsHandler(
 				return set(okReaction(hasComplexityEstimate)) // keep only first solution to not ruin solve time stats
 			}
 
-			stats.Solutions[key] = solution{Update: update}
-			stats.DaysInfo[dayInfo.DayIdx] = dayInfo
-			if err := db.SetJson(tx, statsKey, stats); err != nil {
-				return fmt.Errorf("set stats: %w", err)
+			if err := solKey.Set(tx, solution{Update: update}); err != nil {
+				return fmt.Errorf("set solution: %w", err)
 			}
 
 			return set(okReaction(hasComplexityEstimate))
@@ -215,31 +275,27 @@ func (s *Service) publishRating(
 	opts ratingOpts,
 	header string,
 	threadID int,
-	msgToDayInfoKey string,
-	statsKey string,
+	keys statsKeys,
 ) error {
-	return s.database.Do(ctx, func(tx db.Tx) error {
-		lastDayInfo, err := s.getLastPublishedQuestionDayInfo(tx, msgToDayInfoKey)
+	return s.database.View(ctx, func(tx db.ReadTx) error {
+		lastDayInfo, err := s.getLastPublishedQuestionDayInfo(tx, keys)
 		if err != nil {
 			return fmt.Errorf("get last published question: %w", err)
 		}
 
-		stats, err := db.GetJson[stats](tx, statsKey)
-		switch {
-		case err == nil:
-		case errors.Is(err, db.ErrKeyNotFound):
-			return nil
-		default:
-			return fmt.Errorf("get stats: %w", err)
+		dayIdxFrom, dayIdxTo := lastDayInfo.DayIdx-int64(questionsToInclude)+1, lastDayInfo.DayIdx
+		stats, err := loadStats(tx, keys, dayIdxFrom, dayIdxTo)
+		if err != nil {
+			return fmt.Errorf("load stats: %w", err)
 		}
 
-		rating := buildRating(stats, lastDayInfo.DayIdx-int64(questionsToInclude)+1, lastDayInfo.DayIdx, opts)
+		rating := buildRating(stats, dayIdxFrom, dayIdxTo, opts)
 		if len(rating.rows) == 0 {
 			slog.Info("rating is empty skipping posting", slog.String("header", header))
 			return nil
 		}
 
-		_, err = s.telegram.SendMarkdownV2(threadID, rating.toMarkdownV2(header))
+		_, err = s.telegram.SendMarkdownV2(s.target(threadID), rating.toMarkdownV2(header))
 		if err != nil {
 			return fmt.Errorf("send rating: %w", err)
 		}
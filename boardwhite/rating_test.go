@@ -1,10 +1,13 @@
 package boardwhite
 
 import (
+	"context"
 	_ "embed"
 	"encoding/json"
 	"testing"
 
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
 	"github.com/stretchr/testify/require"
 )
 
@@ -30,3 +33,40 @@ func TestBuildRating(t *testing.T) {
 
 	require.NotEmpty(t, rating.toMarkdownV2("header"))
 }
+
+func TestLoadStats(t *testing.T) {
+	t.Parallel()
+
+	var stats stats
+	err := json.Unmarshal(rawNCStats, &stats)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	database := memdb.New()
+	err = database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	ncStats := keysFor(DefaultName).ncStats
+	err = database.Do(ctx, func(tx db.Tx) error {
+		for key, sol := range stats.Solutions {
+			err := ncStats.solution(key).Set(tx, sol)
+			require.NoError(t, err)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = database.View(ctx, func(tx db.ReadTx) error {
+		loaded, err := loadStats(tx, ncStats, 6, 8)
+		require.NoError(t, err)
+		require.NotEmpty(t, loaded.Solutions)
+		for key := range loaded.Solutions {
+			require.GreaterOrEqual(t, key.DayIdx, int64(6))
+			require.LessOrEqual(t, key.DayIdx, int64(8))
+		}
+		require.Equal(t, buildRating(stats, 6, 8, ratingOpts{}), buildRating(loaded, 6, 8, ratingOpts{}))
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,85 @@
+package boardwhite
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/dbq"
+)
+
+// RegisterSchema declares keys of the community and registers invariants between them and args of its tasks,
+// it must be called before db.Schema.AddKeys
+func RegisterSchema(s *db.Schema, name string) {
+	keys := keysFor(name)
+	for _, statsKeys := range []statsKeys{keys.lcStats, keys.lcChickensStats, keys.ncStats} {
+		s.AddInvariant(keysOwner, statsKeys.ns+" pinned messages have day info", pinnedMessagesHaveDayInfo(statsKeys))
+	}
+	s.AddInvariant(keysOwner, name+" okr total count covers updates", okrTotalCountCoversUpdates(keys.okrValues))
+
+	dbq.RegisterTaskSchema[postCodeSnippetArgs](s, keysOwner, taskPostCodeSnippet(name))
+}
+
+func pinnedMessagesHaveDayInfo(keys statsKeys) db.Invariant {
+	return func(tx db.ReadTx) ([]string, error) {
+		violations := make([]string, 0)
+		err := keys.pinnedMessages.Iterate(tx, db.IterOpts{}, func(suffix string, dayIdx int64) error {
+			messageID, err := strconv.Atoi(suffix)
+			if err != nil {
+				violations = append(violations, fmt.Sprintf("%s: invalid message id: %s", suffix, err))
+				return nil
+			}
+
+			dayInfo, err := keys.dayInfo(dayIdx).Get(tx)
+			switch {
+			case errors.Is(err, db.ErrKeyNotFound):
+				violations = append(violations, fmt.Sprintf("message %d is pinned for day %d without day info", messageID, dayIdx))
+			case err != nil:
+				return fmt.Errorf("get day info %d: %w", dayIdx, err)
+			case dayInfo.DayIdx != dayIdx:
+				violations = append(violations, fmt.Sprintf(
+					"message %d is pinned for day %d, day info has day %d", messageID, dayIdx, dayInfo.DayIdx,
+				))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return violations, nil
+	}
+}
+
+// okrTotalCountCoversUpdates checks TotalCount isn't less than the sum of update counts,
+// it can be greater since old data has no saved updates
+func okrTotalCountCoversUpdates(key db.Key[okrs]) db.Invariant {
+	return func(tx db.ReadTx) ([]string, error) {
+		values, err := key.GetDefault(tx, okrs{})
+		if err != nil {
+			return nil, fmt.Errorf("get okr values: %w", err)
+		}
+		values.init()
+
+		sums := make(map[okrTag]int)
+		for _, update := range values.Updates {
+			for tag, count := range update.Counts {
+				sums[tag] += count
+			}
+		}
+
+		violations := make([]string, 0)
+		for tag, sum := range sums {
+			if total := values.TotalCount[tag]; total < sum {
+				violations = append(violations, fmt.Sprintf(
+					"%s total count %d is less than sum of updates %d", tag, total, sum,
+				))
+			}
+		}
+
+		slices.Sort(violations)
+		return violations, nil
+	}
+}
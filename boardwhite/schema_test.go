@@ -0,0 +1,54 @@
+package boardwhite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	keys := keysFor(DefaultName)
+	err = database.Do(ctx, func(tx db.Tx) error {
+		require.NoError(t, keys.lcStats.dayInfo(1).Set(tx, statsDayInfo{DayIdx: 1, MessageID: 10}))
+		require.NoError(t, keys.lcStats.pinnedMessage(10).Set(tx, int64(1)))
+		require.NoError(t, keys.lcStats.pinnedMessage(20).Set(tx, int64(2)))
+		require.NoError(t, keys.lcStats.solution(solutionKey{DayIdx: 1, UserID: 5}).Set(tx, solution{}))
+		require.NoError(t, keys.okrValues.Set(tx, okrs{
+			TotalCount: map[okrTag]int{okrTagFaangOffer: 1, okrTagStaffPromo: 3},
+			Updates: []okrUpdate{
+				{Counts: map[okrTag]int{okrTagFaangOffer: 2}},
+				{Counts: map[okrTag]int{okrTagStaffPromo: 1}},
+			},
+		}))
+		require.NoError(t, db.SetJson(tx, keys.mock("user").String(), "not a time"))
+		return nil
+	})
+	require.NoError(t, err)
+
+	schema := db.NewSchema()
+	RegisterSchema(schema, DefaultName)
+	schema.AddKeys(db.DefaultKeyRegistry)
+	report, err := schema.Check(ctx, database)
+	require.NoError(t, err)
+	require.Zero(t, report.UnknownCount)
+
+	msgs := make([]string, 0, len(report.Problems))
+	for _, p := range report.Problems {
+		msgs = append(msgs, p.Key+p.Msg)
+	}
+	require.Len(t, msgs, 3, report.String())
+	require.Contains(t, msgs[0], keys.mock("user").String())
+	require.Equal(t, "message 20 is pinned for day 2 without day info", msgs[1])
+	require.Equal(t, "#faang_offer2025 total count 1 is less than sum of updates 2", msgs[2])
+}
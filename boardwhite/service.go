@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/boar-d-white-foundation/drone/alert"
@@ -16,28 +17,68 @@ import (
 	"github.com/boar-d-white-foundation/drone/tg"
 )
 
-const (
-	keyLCPinnedMessages       = "boardwhite:leetcode:pinned_messages"
-	keyLCPinnedToStatsDayInfo = "boardwhite:leetcode:pinned_to_stats_day_info"
-	keyLCStats                = "boardwhite:leetcode:stats"
+const keysOwner = "boardwhite"
 
-	keyLCChickensPinnedMessages       = "boardwhite:leetcode_chickens:pinned_messages"
-	keyLCChickensPinnedToStatsDayInfo = "boardwhite:leetcode_chickens:pinned_to_stats_day_info"
-	keyLCChickensStats                = "boardwhite:leetcode_chickens:stats"
-	keyLCChickensFallbackQuestionIdx  = "boardwhite:leetcode_chickens:fallback_question_idx"
+// keys of a community are prefixed by its name, e.g. boardwhite:okr:values
+type keys struct {
+	lcStats statsKeys
 
-	keyNCPinnedMessages       = "boardwhite:neetcode:pinned_messages"
-	keyNCPinnedToStatsDayInfo = "boardwhite:neetcode:pinned_to_stats_day_info"
-	keyNCStats                = "boardwhite:neetcode:stats"
+	lcChickensStats               statsKeys
+	lcChickensFallbackQuestionIdx db.Key[int]
 
-	keyOnJoinGreetedUsers = "boardwhite:on_join_greeted_users"
+	ncStats statsKeys
 
-	keyOboronaLastGeneratedAt = "boardwhite:oborona:last_generated_at"
+	onJoinGreetedUsers db.Key[map[int64]struct{}]
 
-	keyOkrValues        = "boardwhite:okr:values"
-	keyOkrPinnedMessage = "boardwhite:okr:pinned_message"
+	oboronaLastGeneratedAt db.Key[time.Time]
+
+	okrValues        db.Key[okrs]
+	okrPinnedMessage db.Key[int]
+
+	mocks db.KeyFamily[time.Time]
+}
+
+func declareKeys(name string) keys {
+	return keys{
+		lcStats: declareStatsKeys(name + ":leetcode"),
+
+		lcChickensStats: declareStatsKeys(name + ":leetcode_chickens"),
+		lcChickensFallbackQuestionIdx: db.DeclareKey[int](
+			keysOwner, name+":leetcode_chickens:fallback_question_idx",
+		),
+
+		ncStats: declareStatsKeys(name + ":neetcode"),
+
+		onJoinGreetedUsers: db.DeclareKey[map[int64]struct{}](keysOwner, name+":on_join_greeted_users"),
+
+		oboronaLastGeneratedAt: db.DeclareKey[time.Time](keysOwner, name+":oborona:last_generated_at"),
+
+		okrValues:        db.DeclareKey[okrs](keysOwner, name+":okr:values"),
+		okrPinnedMessage: db.DeclareKey[int](keysOwner, name+":okr:pinned_message"),
+
+		mocks: db.DeclareKeyFamily[time.Time](keysOwner, name+":mock:"),
+	}
+}
+
+var (
+	declaredKeysMu sync.Mutex
+	// declaredKeys are keys of communities by name, boardwhite keys are declared on import like keys of other packages
+	declaredKeys = map[string]keys{DefaultName: declareKeys(DefaultName)}
 )
 
+// keysFor declares keys of the community once
+func keysFor(name string) keys {
+	declaredKeysMu.Lock()
+	defer declaredKeysMu.Unlock()
+
+	result, ok := declaredKeys[name]
+	if !ok {
+		result = declareKeys(name)
+		declaredKeys[name] = result
+	}
+	return result
+}
+
 var (
 	lcSubmissionRe = regexp.MustCompile(`https://leetcode\.com.*/submissions/(?:detail/)?(?P<submissionID>\d+)`)
 )
@@ -47,7 +88,12 @@ type MockConfig struct {
 	StickerIDs []string
 }
 
+// DefaultName is the name of the boardwhite community itself
+const DefaultName = "boardwhite"
+
 type Config struct {
+	// Name of the community, it prefixes keys and tasks
+	Name                       string
 	ChatID                     int64
 	LeetcodeThreadID           int
 	LeetcodeChickensThreadID   int
@@ -68,8 +114,10 @@ type tasks struct {
 	postCodeSnippet dbq.Task[postCodeSnippetArgs]
 }
 
+// Service serves a single community, the bot runs a service per configured community
 type Service struct {
 	cfg                Config
+	keys               keys
 	tasks              tasks
 	database           db.DB
 	telegram           tg.Client
@@ -96,6 +144,7 @@ func NewService(
 
 	return &Service{
 		cfg:                cfg,
+		keys:               keysFor(cfg.Name),
 		database:           database,
 		telegram:           telegram,
 		lcChickenQuestions: questions,
@@ -106,8 +155,10 @@ func NewService(
 	}, nil
 }
 
+// NewServiceFromConfig returns a service of the community, shared settings like stickers are taken from cfg
 func NewServiceFromConfig(
 	cfg config.Config,
+	community config.Community,
 	telegram tg.Client,
 	database db.DB,
 	alerts *alert.Manager,
@@ -131,78 +182,81 @@ func NewServiceFromConfig(
 		strings.Replace(cfg.VC.Domain, ".", `\.`, -1),
 	))
 	serviceCfg := Config{
-		ChatID:                     cfg.Boardwhite.ChatID,
-		LeetcodeThreadID:           cfg.Boardwhite.LeetCodeThreadID,
-		LeetcodeChickensThreadID:   cfg.Boardwhite.LeetcodeChickensThreadID,
+		Name:                       community.Name,
+		ChatID:                     community.ChatID,
+		LeetcodeThreadID:           community.LeetCodeThreadID,
+		LeetcodeChickensThreadID:   community.LeetcodeChickensThreadID,
 		DailyStickersIDs:           cfg.DailyStickerIDs,
 		DailyChickensStickerIDs:    cfg.DailyChickensStickerIDs,
 		DpStickerID:                cfg.DPStickerID,
 		Mocks:                      mocks,
-		FloodThreadID:              cfg.Boardwhite.FloodThreadID,
+		FloodThreadID:              community.FloodThreadID,
 		GreetingsNewUsersTemplates: cfg.GreetingsNewUsersTemplates,
 		GreetingsOldUsersTemplates: cfg.GreetingsOldUsersTemplates,
 		OboronaPeriod:              cfg.Oborona.Period,
 		OboronaTemplate:            cfg.Oborona.Template,
 		OboronaWords:               cfg.Oborona.Words,
-		InterviewsThreadID:         cfg.Boardwhite.InterviewsThreadID,
+		InterviewsThreadID:         community.InterviewsThreadID,
 	}
 	return NewService(serviceCfg, telegram, database, alerts, mediaGenerator, lcClient, vcLinkRe)
 }
 
+// Name returns the name of the community
+func (s *Service) Name() string {
+	return s.cfg.Name
+}
+
+func (s *Service) target(threadID int) tg.Target {
+	return tg.Target{ChatID: s.cfg.ChatID, ThreadID: threadID}
+}
+
 type publishDailyReq struct {
-	dayIdx          int64
-	threadID        int
-	header          string
-	text            string
-	stickerID       string
-	pinnedMsgsKey   string
-	msgToDayInfoKey string
+	dayIdx    int64
+	threadID  int
+	header    string
+	text      string
+	stickerID string
+	statsKeys statsKeys
 }
 
 func (s *Service) publishDaily(tx db.Tx, req publishDailyReq) (int, error) {
-	pinnedIDs, err := db.GetJsonDefault[[]int](tx, req.pinnedMsgsKey, nil)
+	lastDayInfo, err := s.getLastPublishedQuestionDayInfo(tx, req.statsKeys)
 	if err != nil {
-		return 0, fmt.Errorf("get key %s: %w", req.pinnedMsgsKey, err)
+		return 0, fmt.Errorf("get last published question: %w", err)
 	}
-	if len(pinnedIDs) > 0 {
+	if lastDayInfo.MessageID != 0 {
 		// last is considered active
-		err = s.telegram.Unpin(pinnedIDs[len(pinnedIDs)-1])
+		err = s.telegram.Unpin(s.cfg.ChatID, lastDayInfo.MessageID)
 		if err != nil {
 			slog.Error("err unpin", slog.Any("err", err))
 		}
 	}
 
-	messageID, err := s.telegram.SendSpoilerLink(req.threadID, req.header, req.text)
+	messageID, err := s.telegram.SendSpoilerLink(s.target(req.threadID), req.header, req.text)
 	if err != nil {
 		return 0, fmt.Errorf("send daily: %w", err)
 	}
 
-	_, err = s.telegram.SendSticker(req.threadID, req.stickerID)
+	_, err = s.telegram.SendSticker(s.target(req.threadID), req.stickerID)
 	if err != nil {
 		return 0, fmt.Errorf("send sticker: %w", err)
 	}
 
-	err = s.telegram.Pin(messageID)
+	err = s.telegram.Pin(s.cfg.ChatID, messageID)
 	if err != nil {
 		return 0, fmt.Errorf("pin: %w", err)
 	}
 
-	pinnedIDs = append(pinnedIDs, messageID)
-	if err := db.SetJson(tx, req.pinnedMsgsKey, pinnedIDs); err != nil {
-		return 0, fmt.Errorf("set key %s: %w", req.pinnedMsgsKey, err)
-	}
-
-	msgToDayInfo, err := db.GetJsonDefault(tx, req.msgToDayInfoKey, make(map[int]statsDayInfo))
-	if err != nil {
-		return 0, fmt.Errorf("get msgToDayInfo: %w", err)
-	}
-
-	msgToDayInfo[messageID] = statsDayInfo{
+	dayInfo := statsDayInfo{
 		DayIdx:      req.dayIdx,
+		MessageID:   messageID,
 		PublishedAt: time.Now(),
 	}
-	if err := db.SetJson(tx, req.msgToDayInfoKey, msgToDayInfo); err != nil {
-		return 0, fmt.Errorf("set msgToDayInfo: %w", err)
+	if err := req.statsKeys.dayInfo(req.dayIdx).Set(tx, dayInfo); err != nil {
+		return 0, err
+	}
+	if err := req.statsKeys.pinnedMessage(messageID).Set(tx, req.dayIdx); err != nil {
+		return 0, err
 	}
 
 	return messageID, nil
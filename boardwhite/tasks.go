@@ -4,14 +4,28 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"time"
 
-	"github.com/boar-d-white-foundation/drone/db"
 	"github.com/boar-d-white-foundation/drone/dbq"
 	"github.com/boar-d-white-foundation/drone/leetcode"
+	"github.com/boar-d-white-foundation/drone/retry"
 )
 
+// taskPostCodeSnippet returns the task name of the community, e.g. boardwhite:post_code_snippet
+func taskPostCodeSnippet(name string) string {
+	return name + ":post_code_snippet"
+}
+
 func (s *Service) RegisterTasks(registry *dbq.Registry) error {
-	postCodeSnippetTask, err := dbq.RegisterHandler(registry, "boardwhite:post_code_snippet", s.postCodeSnippet)
+	taskName := taskPostCodeSnippet(s.cfg.Name)
+	postCodeSnippetTask, err := dbq.RegisterHandler(registry, taskName, s.postCodeSnippet, dbq.HandlerOpts{
+		// javahighlight and telegram failures are usually short
+		Backoff: retry.ExponentialBackoff{
+			Initial:     30 * time.Second,
+			Max:         10 * time.Minute,
+			MaxAttempts: 5,
+		},
+	})
 	if err != nil {
 		return fmt.Errorf("register post code snippet taskl: %w", err)
 	}
@@ -26,11 +40,10 @@ type postCodeSnippetArgs struct {
 	Submission leetcode.Submission `json:"submission"`
 }
 
-func (s *Service) postCodeSnippet(ctx context.Context, tx db.Tx, args postCodeSnippetArgs) error {
+func (s *Service) postCodeSnippet(ctx context.Context, args postCodeSnippetArgs) error {
 	sub := args.Submission
 	snippet, err := s.mediaGenerator.GenerateCodeSnippet(ctx, sub.ID, sub.Lang, sub.Code)
 	if err != nil {
-		s.alerts.Errorxf(err, "err generate snippet: %+v", args)
 		return fmt.Errorf("generate snippet: %w", err)
 	}
 
@@ -43,6 +56,7 @@ func (s *Service) postCodeSnippet(ctx context.Context, tx db.Tx, args postCodeSn
 	}
 	imgName := fmt.Sprintf("submission_%s.png", sub.ID)
 	_, err = s.telegram.ReplyWithSpoilerPhoto(
+		s.cfg.ChatID,
 		args.MessageID,
 		caption,
 		imgName,
@@ -50,7 +64,6 @@ func (s *Service) postCodeSnippet(ctx context.Context, tx db.Tx, args postCodeSn
 		bytes.NewReader(snippet),
 	)
 	if err != nil {
-		s.alerts.Errorxf(err, "err reply with snippet: %+v", args)
 		return fmt.Errorf("reply with snippet: %w", err)
 	}
 
@@ -26,7 +26,7 @@ func (s *Service) OnPostTwitterEmbed(ctx context.Context, c tele.Context) error
 	embedTwitterLink := strings.Replace(firstTwitterLink, "x.com/", "i.fixupx.com/", 1)
 	embedTwitterLink = strings.Replace(embedTwitterLink, "twitter.com/", "i.fixupx.com/", 1)
 
-	_, err := s.telegram.ReplyWithText(msg.ID, embedTwitterLink)
+	_, err := s.telegram.ReplyWithText(s.cfg.ChatID, msg.ID, embedTwitterLink)
 	if err != nil {
 		return fmt.Errorf("reply with twitter embed: %w", err)
 	}
@@ -4,24 +4,14 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"strings"
 
 	"github.com/boar-d-white-foundation/drone/tg"
 	tele "gopkg.in/telebot.v3"
 )
 
-func (s *Service) OnGenerateVCPdf(ctx context.Context, c tele.Context) error {
-	msg, chat := c.Message(), c.Chat()
-	if msg == nil || chat == nil || chat.ID != s.cfg.ChatID {
-		return nil
-	}
-
-	cmdPrefix := "/pdf"
-	if !(strings.HasPrefix(msg.Text, cmdPrefix) || strings.HasPrefix(msg.Caption, cmdPrefix)) {
-		return nil
-	}
-
-	set := tg.SetReactionFor(s.telegram, msg.ID)
+func (s *Service) OnGenerateVCPdf(ctx context.Context, c tele.Context, _ tg.Args) error {
+	msg := c.Message()
+	set := tg.SetReactionFor(s.telegram, s.cfg.ChatID, msg.ID)
 	link := s.getVcLink(msg)
 	if link == "" {
 		return set(tg.ReactionClown)
@@ -44,7 +34,7 @@ func (s *Service) OnGenerateVCPdf(ctx context.Context, c tele.Context) error {
 		return fmt.Errorf("generate vc pdf: %w", err)
 	}
 
-	_, err = s.telegram.ReplyWithDocument(msg.ID, "dump.pdf", "application/pdf", bytes.NewReader(buf))
+	_, err = s.telegram.ReplyWithDocument(s.cfg.ChatID, msg.ID, "dump.pdf", "application/pdf", bytes.NewReader(buf))
 	if err != nil {
 		return fmt.Errorf("reply with vc dump: %w", err)
 	}
@@ -13,6 +13,13 @@ import (
 )
 
 func Run(name string, cmd func(context.Context, config.Config, *alert.Manager) error) {
+	RunWithTelegram(name, func(ctx context.Context, cfg config.Config, alerts *alert.Manager, _ *tg.Service) error {
+		return cmd(ctx, cfg, alerts)
+	})
+}
+
+// RunWithTelegram runs cmd with the bot instance sending alerts, so the bot isn't created twice
+func RunWithTelegram(name string, cmd func(context.Context, config.Config, *alert.Manager, *tg.Service) error) {
 	flag.Parse()
 
 	cfg, err := config.Load(config.Path())
@@ -22,17 +29,17 @@ func Run(name string, cmd func(context.Context, config.Config, *alert.Manager) e
 	}
 	slog.Info("loaded config", slog.Any("config", cfg))
 
-	adminTGClient, err := tg.NewAdminClientFromConfig(cfg)
+	tgService, err := tg.NewServiceFromConfig(cfg)
 	if err != nil {
-		slog.Error("failed to create admin tg client", slog.Any("err", err))
+		slog.Error("failed to create tg service", slog.Any("err", err))
 		os.Exit(1)
 	}
 
-	alerts := alert.NewManager(adminTGClient)
+	alerts := alert.NewManager(tgService)
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
-	if err := cmd(ctx, cfg, alerts); err != nil {
+	if err := cmd(ctx, cfg, alerts, tgService); err != nil {
 		alerts.Errorxf(err, "failed to run cmd: %s", name)
 		stop()
 		os.Exit(1)
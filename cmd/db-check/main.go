@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/cli"
+	"github.com/boar-d-white-foundation/drone/config"
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/schema"
+)
+
+var (
+	driver = flag.String("driver", "", "storage driver to check, storage.driver from config if empty")
+	strict = flag.Bool("strict", false, "fail on keys not registered in the schema")
+)
+
+func checkDB(ctx context.Context, cfg config.Config, alerts *alert.Manager) error {
+	if *driver != "" {
+		cfg.Storage.Driver = *driver
+	}
+	database, err := db.NewDBFromConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
+	}
+	if err := database.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start database: %w", err)
+	}
+	defer database.Stop()
+
+	report, err := schema.New(cfg).Check(ctx, database)
+	if err != nil {
+		return fmt.Errorf("failed to check database: %w", err)
+	}
+
+	if !report.OK() {
+		return errors.New(report.String())
+	}
+	fmt.Fprint(os.Stdout, report.String())
+	if *strict && report.UnknownCount > 0 {
+		return fmt.Errorf("found %d unknown keys", report.UnknownCount)
+	}
+
+	return nil
+}
+
+func main() {
+	cli.Run("db-check", checkDB)
+}
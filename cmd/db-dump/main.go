@@ -5,6 +5,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"time"
@@ -15,15 +16,43 @@ import (
 	"github.com/boar-d-white-foundation/drone/db"
 )
 
-var dumpPath = flag.String("p", "db_dump.json.gz", "path to the dump file")
+var (
+	dumpPath = flag.String("p", "db_dump.json.gz", "path to the dump file")
+	driver   = flag.String("driver", "", "storage driver to dump from, storage.driver from config if empty")
+	prefix   = flag.String("prefix", "", "dump only keys with the prefix")
+	dryRun   = flag.Bool("dry-run", false, "read the database and report key count and checksum without writing the dump")
+)
 
 func dumpDB(ctx context.Context, cfg config.Config, alerts *alert.Manager) error {
-	database := db.NewBadgerDBFromConfig(cfg)
+	if *driver != "" {
+		cfg.Storage.Driver = *driver
+	}
+	database, err := db.NewDBFromConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
+	}
 	if err := database.Start(ctx); err != nil {
 		return fmt.Errorf("failed to start database: %w", err)
 	}
 	defer database.Stop()
 
+	if *dryRun {
+		header, err := db.DumpJson(ctx, database, io.Discard, db.DumpOpts{
+			Prefix:               *prefix,
+			AppliedMigrationsKey: db.AppliedMigrationsKey,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to dump database: %w", err)
+		}
+
+		slog.Info(
+			"dry run OK",
+			slog.Int("key_count", header.KeyCount),
+			slog.String("checksum", header.Checksum),
+		)
+		return nil
+	}
+
 	fd, err := os.OpenFile(*dumpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to open dump file: %w", err)
@@ -46,11 +75,20 @@ func dumpDB(ctx context.Context, cfg config.Config, alerts *alert.Manager) error
 		}
 	}()
 
-	if err := db.DumpJson(ctx, database, writer); err != nil {
+	header, err := db.DumpJson(ctx, database, writer, db.DumpOpts{
+		Prefix:               *prefix,
+		AppliedMigrationsKey: db.AppliedMigrationsKey,
+	})
+	if err != nil {
 		return fmt.Errorf("failed to dump database: %w", err)
 	}
 
-	slog.Info("backup OK", slog.String("path", *dumpPath))
+	slog.Info(
+		"backup OK",
+		slog.String("path", *dumpPath),
+		slog.Int("key_count", header.KeyCount),
+		slog.String("checksum", header.Checksum),
+	)
 	return nil
 }
 
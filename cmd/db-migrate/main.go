@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/cli"
+	"github.com/boar-d-white-foundation/drone/config"
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/boar-d-white-foundation/drone/migrations"
+)
+
+var (
+	driver = flag.String("driver", "", "storage driver to migrate, storage.driver from config if empty")
+)
+
+const usage = `usage: db-migrate [-driver driver] <command> [flags]
+
+commands:
+  status                      print applied and pending migrations
+  up [--to id] [--dry-run]    apply pending migrations up to and including id, all by default
+  down --to id [--dry-run]    revert applied migrations after id, --to "" reverts all
+
+--dry-run runs migrations against an in-memory copy of the database and prints changed keys`
+
+func migrateDB(ctx context.Context, cfg config.Config, alerts *alert.Manager) error {
+	args := flag.Args()
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	cmd := args[0]
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	to := fs.String("to", "", "target migration id")
+	dryRun := fs.Bool("dry-run", false, "run against an in-memory copy of the database and print the diff")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	toSet := false
+	fs.Visit(func(f *flag.Flag) {
+		toSet = toSet || f.Name == "to"
+	})
+
+	if *driver != "" {
+		cfg.Storage.Driver = *driver
+	}
+	database, err := db.NewDBFromConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
+	}
+	if err := database.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start database: %w", err)
+	}
+	defer database.Stop()
+
+	var run func(context.Context, db.DB) error
+	switch cmd {
+	case "status":
+		return printStatus(ctx, database)
+	case "up":
+		run = func(ctx context.Context, database db.DB) error {
+			return db.MigrateUp(ctx, database, db.AppliedMigrationsKey, migrations.All(), *to)
+		}
+	case "down":
+		if !toSet {
+			return errors.New("down requires --to, use --to \"\" to revert all migrations")
+		}
+		run = func(ctx context.Context, database db.DB) error {
+			return db.MigrateDown(ctx, database, db.AppliedMigrationsKey, migrations.All(), *to)
+		}
+	default:
+		return fmt.Errorf("unknown command %q\n%s", cmd, usage)
+	}
+
+	if !*dryRun {
+		if err := run(ctx, database); err != nil {
+			return fmt.Errorf("failed to %s: %w", cmd, err)
+		}
+		return printStatus(ctx, database)
+	}
+
+	copied, err := copyToMemory(ctx, database)
+	if err != nil {
+		return fmt.Errorf("failed to copy database: %w", err)
+	}
+	defer copied.Stop()
+
+	before, err := snapshot(ctx, copied)
+	if err != nil {
+		return err
+	}
+	if err := run(ctx, copied); err != nil {
+		return fmt.Errorf("failed to %s: %w", cmd, err)
+	}
+	after, err := snapshot(ctx, copied)
+	if err != nil {
+		return err
+	}
+
+	printDiff(before, after)
+	return printStatus(ctx, copied)
+}
+
+func printStatus(ctx context.Context, database db.DB) error {
+	statuses, err := db.MigrationsStatus(ctx, database, db.AppliedMigrationsKey, migrations.All())
+	if err != nil {
+		return fmt.Errorf("failed to get status: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTATUS\tAPPLIED AT\tREVERSIBLE")
+	for _, st := range statuses {
+		status, appliedAt := "pending", ""
+		if st.Applied {
+			status, appliedAt = "applied", st.AppliedAt.Format(time.RFC3339)
+		}
+		if st.Unknown {
+			status = "unknown"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\n", st.ID, st.Name, status, appliedAt, st.Reversible)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to print status: %w", err)
+	}
+
+	for _, st := range statuses {
+		if st.Unknown {
+			return fmt.Errorf("%w: %s", db.ErrUnknownMigration, st.ID)
+		}
+	}
+	return nil
+}
+
+func copyToMemory(ctx context.Context, database db.DB) (*memdb.DB, error) {
+	result := memdb.New()
+	if err := result.Start(ctx); err != nil {
+		return nil, err
+	}
+
+	err := database.View(ctx, func(src db.ReadTx) error {
+		return result.Do(ctx, func(dst db.Tx) error {
+			return src.Iterate(db.IterOpts{}, func(key, val []byte) error {
+				return dst.Set(key, val)
+			})
+		})
+	})
+	if err != nil {
+		result.Stop()
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func snapshot(ctx context.Context, database db.DB) (map[string][]byte, error) {
+	result := make(map[string][]byte)
+	err := database.View(ctx, func(tx db.ReadTx) error {
+		return tx.Iterate(db.IterOpts{}, func(key, val []byte) error {
+			result[string(key)] = bytes.Clone(val)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read database: %w", err)
+	}
+
+	return result, nil
+}
+
+func printDiff(before, after map[string][]byte) {
+	keys := make([]string, 0, len(before)+len(after))
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	changed := 0
+	for _, key := range keys {
+		oldVal, hadOld := before[key]
+		newVal, hasNew := after[key]
+		switch {
+		case !hadOld:
+			fmt.Printf("+ %s %s\n", key, newVal)
+		case !hasNew:
+			fmt.Printf("- %s %s\n", key, oldVal)
+		case !bytes.Equal(oldVal, newVal):
+			fmt.Printf("~ %s %s -> %s\n", key, oldVal, newVal)
+		default:
+			continue
+		}
+		changed++
+	}
+	fmt.Printf("dry run: %d of %d keys changed, nothing is written\n", changed, len(keys))
+}
+
+func main() {
+	cli.Run("db-migrate", migrateDB)
+}
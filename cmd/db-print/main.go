@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/cli"
+	"github.com/boar-d-white-foundation/drone/config"
+	"github.com/boar-d-white-foundation/drone/db"
+	_ "github.com/boar-d-white-foundation/drone/schema" // declares all drone keys
+)
+
+var (
+	dumpPath = flag.String("p", "db_dump.json.gz", "path to the dump file")
+	prefix   = flag.String("prefix", "", "print only keys with the prefix")
+)
+
+func printDump(ctx context.Context, cfg config.Config, alerts *alert.Manager) error {
+	fd, err := os.Open(*dumpPath)
+	if err != nil {
+		return fmt.Errorf("failed to open dump file: %w", err)
+	}
+	defer func() {
+		if err := fd.Close(); err != nil {
+			slog.Error("failed to close dump file", slog.Any("err", err))
+		}
+	}()
+
+	reader, err := gzip.NewReader(fd)
+	if err != nil {
+		return fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			slog.Error("failed to close gzip reader", slog.Any("err", err))
+		}
+	}()
+
+	out := bufio.NewWriter(os.Stdout)
+	header, err := db.ScanDump(reader, func(key string, val []byte) error {
+		if !strings.HasPrefix(key, *prefix) {
+			return nil
+		}
+
+		return printKey(out, key, val)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read dump: %w", err)
+	}
+	fmt.Fprintf(
+		out, "# version %d, %d keys, applied migrations %v\n",
+		header.Version, header.KeyCount, header.AppliedMigrations,
+	)
+
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+func printKey(out *bufio.Writer, key string, val json.RawMessage) error {
+	info, ok := db.DefaultKeyRegistry.Lookup(key)
+	if !ok {
+		fmt.Fprintf(out, "# %s (undeclared)\n", key)
+		return printRaw(out, val)
+	}
+
+	fmt.Fprintf(out, "# %s (%s, %s)\n", key, info.Owner, info.Type)
+	pretty, err := info.Pretty(val)
+	if err != nil {
+		fmt.Fprintf(out, "# doesn't decode into %s: %s\n", info.Type, err)
+		return printRaw(out, val)
+	}
+
+	_, err = fmt.Fprintf(out, "%s\n", pretty)
+	return err
+}
+
+func printRaw(out *bufio.Writer, val json.RawMessage) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, val, "", "  "); err != nil {
+		_, err = fmt.Fprintf(out, "%s\n", val)
+		return err
+	}
+
+	_, err := fmt.Fprintf(out, "%s\n", buf.Bytes())
+	return err
+}
+
+func main() {
+	cli.Run("db-print", printDump)
+}
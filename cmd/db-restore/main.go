@@ -14,10 +14,22 @@ import (
 	"github.com/boar-d-white-foundation/drone/db"
 )
 
-var dumpPath = flag.String("p", "db_dump.json.gz", "path to the dump file")
+var (
+	dumpPath = flag.String("p", "db_dump.json.gz", "path to the dump file")
+	driver   = flag.String("driver", "", "storage driver to restore to, storage.driver from config if empty")
+	prefix   = flag.String("prefix", "", "restore only keys with the prefix")
+	dryRun   = flag.Bool("dry-run", false, "read and verify the dump without writing to the database")
+	chunk    = flag.Int("chunk", 1000, "number of keys restored in one transaction")
+)
 
 func restoreDB(ctx context.Context, cfg config.Config, alerts *alert.Manager) error {
-	database := db.NewBadgerDBFromConfig(cfg)
+	if *driver != "" {
+		cfg.Storage.Driver = *driver
+	}
+	database, err := db.NewDBFromConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
+	}
 	if err := database.Start(ctx); err != nil {
 		return fmt.Errorf("failed to start database: %w", err)
 	}
@@ -43,11 +55,23 @@ func restoreDB(ctx context.Context, cfg config.Config, alerts *alert.Manager) er
 		}
 	}()
 
-	if err := db.RestoreJson(ctx, database, reader); err != nil {
+	result, err := db.RestoreJson(ctx, database, reader, db.RestoreOpts{
+		Prefix:    *prefix,
+		DryRun:    *dryRun,
+		ChunkSize: *chunk,
+	})
+	if err != nil {
 		return fmt.Errorf("failed to restore database: %w", err)
 	}
 
-	slog.Info("restore OK", slog.String("path", *dumpPath))
+	slog.Info(
+		"restore OK",
+		slog.String("path", *dumpPath),
+		slog.Int("version", result.Header.Version),
+		slog.Any("applied_migrations", result.Header.AppliedMigrations),
+		slog.Int("restored", result.Restored),
+		slog.Bool("dry_run", *dryRun),
+	)
 	return nil
 }
 
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/cli"
+	"github.com/boar-d-white-foundation/drone/config"
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/dbq"
+)
+
+var driver = flag.String("driver", "", "storage driver to use, storage.driver from config if empty")
+
+func runCommand(ctx context.Context, cfg config.Config, alerts *alert.Manager) error {
+	if *driver != "" {
+		cfg.Storage.Driver = *driver
+	}
+	database, err := db.NewDBFromConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
+	}
+	if err := database.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start database: %w", err)
+	}
+	defer database.Stop()
+
+	return dbq.RunCommand(ctx, database, os.Stdout, flag.Args())
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: dbq [flags] <command> [args]\n%s\nflags:\n", dbq.CommandUsage)
+		flag.PrintDefaults()
+	}
+	cli.Run("dbq", runCommand)
+}
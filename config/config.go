@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"regexp"
 	"slices"
 	"strings"
 	"time"
@@ -26,17 +28,58 @@ func Path() string {
 //go:embed default_config.yaml
 var defaultConfigBytes []byte
 
+const (
+	StorageDriverBadger = "badger"
+	StorageDriverSQLite = "sqlite"
+)
+
+const (
+	TgUpdatesLongPoller = "long_poller"
+	TgUpdatesWebhook    = "webhook"
+)
+
 type Config struct {
 	BadgerPath string `yaml:"badger_path"`
 
+	Storage struct {
+		Driver     string `yaml:"driver"`
+		SQLitePath string `yaml:"sqlite_path"`
+
+		BadgerGCInterval         time.Duration `yaml:"badger_gc_interval"`
+		BadgerGCDiscardRatio     float64       `yaml:"badger_gc_discard_ratio"`
+		BadgerSizeAlertThreshold int64         `yaml:"badger_size_alert_threshold"`
+	} `yaml:"storage"`
+
+	Backup struct {
+		Enabled bool   `yaml:"enabled"`
+		Cron    string `yaml:"cron"`
+		Folder  string `yaml:"folder"`
+		Keep    int    `yaml:"keep"`
+	} `yaml:"backup"`
+
+	DBQ struct {
+		Workers             int           `yaml:"workers"`
+		ShutdownGracePeriod time.Duration `yaml:"shutdown_grace_period"`
+	} `yaml:"dbq"`
+
 	Features struct {
 		RodEnabled bool `yaml:"rod_enabled"`
 	} `yaml:"features"`
 
 	Tg struct {
-		Key               string        `yaml:"api_key" json:"-"` // intentionally hidden from logs
+		Key string `yaml:"api_key" json:"-"` // intentionally hidden from logs
+		// APIURL is the Bot API server, tests point it to a local fake
+		APIURL string `yaml:"api_url"`
+		// Updates selects how updates are received, long_poller or webhook
+		Updates           string        `yaml:"updates"`
 		LongPollerTimeout time.Duration `yaml:"long_poller_timeout"`
-		AdminChatID       int64         `yaml:"admin_chat_id"`
+		Webhook           struct {
+			// PublicURL is the https URL telegram sends updates to, e.g. of a reverse proxy in front of Listen
+			PublicURL   string `yaml:"public_url"`
+			Listen      string `yaml:"listen"`
+			SecretToken string `yaml:"secret_token" json:"-"` // intentionally hidden from logs
+		} `yaml:"webhook"`
+		AdminChatID int64 `yaml:"admin_chat_id"`
 	} `yaml:"tg"`
 
 	Rod struct {
@@ -55,13 +98,9 @@ type Config struct {
 		Token  string `yaml:"token" json:"-"` // intentionally hidden from logs
 	} `yaml:"vc"`
 
-	Boardwhite struct {
-		ChatID                   int64 `yaml:"chat_id"`
-		LeetCodeThreadID         int   `yaml:"leetcode_thread_id"`
-		LeetcodeChickensThreadID int   `yaml:"leetcode_chickens_thread_id"`
-		FloodThreadID            int   `yaml:"flood_thread_id"`
-		InterviewsThreadID       int   `yaml:"interviews_thread_id"`
-	} `yaml:"boardwhite"`
+	Boardwhite Community `yaml:"boardwhite"`
+	// Communities are served by the same bot in addition to boardwhite
+	Communities []Community `yaml:"communities"`
 
 	Leetcode struct {
 		Session string `yaml:"session" json:"-"` // intentionally hidden from logs
@@ -98,6 +137,28 @@ type Config struct {
 	} `yaml:"oborona"`
 }
 
+// Community is a chat served by its own boardwhite service
+type Community struct {
+	// Name namespaces db keys and dbq tasks of the community
+	Name                     string `yaml:"name"`
+	ChatID                   int64  `yaml:"chat_id"`
+	LeetCodeThreadID         int    `yaml:"leetcode_thread_id"`
+	LeetcodeChickensThreadID int    `yaml:"leetcode_chickens_thread_id"`
+	FloodThreadID            int    `yaml:"flood_thread_id"`
+	InterviewsThreadID       int    `yaml:"interviews_thread_id"`
+}
+
+var (
+	communityNameRe = regexp.MustCompile(`^[a-z0-9_]+$`)
+	// webhookSecretTokenRe are tokens allowed by telegram
+	webhookSecretTokenRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+)
+
+// AllCommunities returns boardwhite followed by other communities
+func (cfg Config) AllCommunities() []Community {
+	return append([]Community{cfg.Boardwhite}, cfg.Communities...)
+}
+
 func (cfg Config) String() string {
 	b, _ := json.Marshal(&cfg) //nolint:errchkjson // intentionally omitting the error
 	return string(b)
@@ -139,6 +200,72 @@ func Load(filename string) (Config, error) {
 }
 
 func (cfg Config) validate() error {
+	if !slices.Contains([]string{StorageDriverBadger, StorageDriverSQLite}, cfg.Storage.Driver) {
+		return fmt.Errorf("unknown storage.driver %q", cfg.Storage.Driver)
+	}
+	if cfg.Storage.BadgerGCInterval <= 0 {
+		return errors.New("storage.badger_gc_interval must be positive")
+	}
+	if cfg.Storage.BadgerGCDiscardRatio <= 0 || cfg.Storage.BadgerGCDiscardRatio >= 1 {
+		return errors.New("storage.badger_gc_discard_ratio must be in (0, 1)")
+	}
+	if cfg.Storage.BadgerSizeAlertThreshold < 0 {
+		return errors.New("storage.badger_size_alert_threshold must not be negative")
+	}
+
+	if cfg.Backup.Enabled {
+		if cfg.Backup.Folder == "" {
+			return errors.New("backup.folder must not be empty")
+		}
+		if cfg.Backup.Keep < 1 {
+			return errors.New("backup.keep must be positive")
+		}
+	}
+
+	if cfg.DBQ.Workers < 1 {
+		return errors.New("dbq.workers must be positive")
+	}
+	if cfg.DBQ.ShutdownGracePeriod <= 0 {
+		return errors.New("dbq.shutdown_grace_period must be positive")
+	}
+
+	if cfg.Tg.APIURL == "" {
+		return errors.New("tg.api_url must not be empty")
+	}
+	switch cfg.Tg.Updates {
+	case TgUpdatesLongPoller:
+	case TgUpdatesWebhook:
+		publicURL, err := url.Parse(cfg.Tg.Webhook.PublicURL)
+		if err != nil || publicURL.Scheme != "https" || publicURL.Host == "" {
+			return fmt.Errorf("tg.webhook.public_url %q must be an https URL", cfg.Tg.Webhook.PublicURL)
+		}
+		if cfg.Tg.Webhook.Listen == "" {
+			return errors.New("tg.webhook.listen must not be empty")
+		}
+		if !webhookSecretTokenRe.MatchString(cfg.Tg.Webhook.SecretToken) {
+			return fmt.Errorf("tg.webhook.secret_token must match %s", webhookSecretTokenRe)
+		}
+	default:
+		return fmt.Errorf("unknown tg.updates %q", cfg.Tg.Updates)
+	}
+
+	names, chatIDs := make(map[string]struct{}), make(map[int64]struct{})
+	for _, community := range cfg.AllCommunities() {
+		if !communityNameRe.MatchString(community.Name) {
+			return fmt.Errorf("community name %q must match %s", community.Name, communityNameRe)
+		}
+		if _, ok := names[community.Name]; ok {
+			return fmt.Errorf("community name %q is not unique", community.Name)
+		}
+		if _, ok := chatIDs[community.ChatID]; ok {
+			return fmt.Errorf("chat_id %d of community %q is not unique", community.ChatID, community.Name)
+		}
+		if community.ChatID == 0 || community.ChatID == cfg.Tg.AdminChatID {
+			return fmt.Errorf("chat_id of community %q must be set and differ from tg.admin_chat_id", community.Name)
+		}
+		names[community.Name], chatIDs[community.ChatID] = struct{}{}, struct{}{}
+	}
+
 	if !slices.Equal(cfg.DailyStickerIDs, iterx.Uniq(cfg.DailyStickerIDs)) {
 		return errors.New("all daily_sticker_ids must be unique")
 	}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -45,3 +46,100 @@ func TestConfigOverride(t *testing.T) {
 	assert.NotEqual(t, lcSession, cfg.Leetcode.Session)
 	assert.NotEqual(t, lcCSRF, cfg.Leetcode.CSRF)
 }
+
+func TestConfigStorageDriver(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Default()
+	require.NoError(t, err)
+	require.Equal(t, StorageDriverBadger, cfg.Storage.Driver)
+
+	cfg.Storage.Driver = StorageDriverSQLite
+	require.NoError(t, cfg.validate())
+
+	cfg.Storage.Driver = "bolt"
+	require.Error(t, cfg.validate())
+}
+
+func TestConfigBackup(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Default()
+	require.NoError(t, err)
+	require.True(t, cfg.Backup.Enabled)
+
+	cfg.Backup.Keep = 0
+	require.Error(t, cfg.validate())
+
+	cfg.Backup.Enabled = false
+	require.NoError(t, cfg.validate())
+}
+
+func TestConfigBadgerMaintenance(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Default()
+	require.NoError(t, err)
+	require.Positive(t, cfg.Storage.BadgerGCInterval)
+
+	cfg.Storage.BadgerGCDiscardRatio = 1
+	require.Error(t, cfg.validate())
+}
+
+func TestConfigDBQ(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Default()
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, cfg.DBQ.ShutdownGracePeriod)
+
+	cfg.DBQ.ShutdownGracePeriod = 0
+	require.Error(t, cfg.validate())
+}
+
+func TestConfigCommunities(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Default()
+	require.NoError(t, err)
+	require.Equal(t, []Community{cfg.Boardwhite}, cfg.AllCommunities())
+	require.Equal(t, "boardwhite", cfg.Boardwhite.Name)
+
+	cfg.Communities = []Community{{Name: "other", ChatID: -1}}
+	require.NoError(t, cfg.validate())
+	require.Len(t, cfg.AllCommunities(), 2)
+
+	cfg.Communities[0].Name = "Other:"
+	require.Error(t, cfg.validate())
+
+	cfg.Communities[0].Name = cfg.Boardwhite.Name
+	require.Error(t, cfg.validate())
+
+	cfg.Communities[0] = Community{Name: "other", ChatID: cfg.Boardwhite.ChatID}
+	require.Error(t, cfg.validate())
+}
+
+func TestConfigTgUpdates(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Default()
+	require.NoError(t, err)
+	require.Equal(t, TgUpdatesLongPoller, cfg.Tg.Updates)
+
+	cfg.Tg.Updates = TgUpdatesWebhook
+	require.Error(t, cfg.validate())
+
+	cfg.Tg.Webhook.PublicURL = "https://drone.example.com/tg"
+	cfg.Tg.Webhook.SecretToken = "not a token"
+	require.Error(t, cfg.validate())
+
+	cfg.Tg.Webhook.SecretToken = "s3cret_token-1"
+	require.NoError(t, cfg.validate())
+	require.NotContains(t, cfg.String(), cfg.Tg.Webhook.SecretToken)
+
+	cfg.Tg.Webhook.PublicURL = "http://drone.example.com/tg"
+	require.Error(t, cfg.validate())
+
+	cfg.Tg.Updates = "push"
+	require.Error(t, cfg.validate())
+}
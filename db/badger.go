@@ -16,6 +16,11 @@ type BadgerDB struct {
 	mu         sync.Mutex
 	badgerOpts badger.Options
 	bdb        *badger.DB
+
+	statsMu         sync.Mutex
+	stats           BadgerStats
+	maintenanceStop chan struct{}
+	maintenanceWg   sync.WaitGroup
 }
 
 type BadgerTx struct {
@@ -24,6 +29,7 @@ type BadgerTx struct {
 
 var _ DB = (*BadgerDB)(nil)
 var _ Tx = (*BadgerTx)(nil)
+var _ ReadTx = (*BadgerTx)(nil)
 
 func NewBadgerDB(path string) *BadgerDB {
 	badgerOpts := buildDBOpts(path)
@@ -83,6 +89,11 @@ func (b *BadgerDB) Start(ctx context.Context) error {
 }
 
 func (b *BadgerDB) Stop() {
+	if b.maintenanceStop != nil {
+		close(b.maintenanceStop)
+		b.maintenanceWg.Wait()
+	}
+
 	if err := b.bdb.Close(); err != nil {
 		slog.Error("failed to close badger", slog.Any("err", err))
 	}
@@ -96,6 +107,9 @@ func (b *BadgerDB) Do(ctx context.Context, f func(Tx) error) error {
 		tx := BadgerTx{btx: btx}
 		return f(&tx)
 	})
+	if errors.Is(err, badger.ErrConflict) {
+		return fmt.Errorf("badger tx: %w: %w", ErrConflict, err)
+	}
 	if err != nil {
 		return fmt.Errorf("badger tx: %w", err)
 	}
@@ -103,37 +117,17 @@ func (b *BadgerDB) Do(ctx context.Context, f func(Tx) error) error {
 	return nil
 }
 
-func (b *BadgerDB) Dump(ctx context.Context) ([]KV, error) {
-	// badger already has a file level lock
-	b.mu.Lock()
-	defer b.mu.Unlock()
-
-	var dump []KV
+func (b *BadgerDB) View(ctx context.Context, f func(ReadTx) error) error {
+	// badger provides snapshot isolation for readers, so there is no need to wait for the lock
 	err := b.bdb.View(func(btx *badger.Txn) error {
-		it := btx.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
-			key := item.KeyCopy(nil)
-			val, err := item.ValueCopy(nil)
-			if err != nil {
-				return fmt.Errorf("get value: %w", err)
-			}
-
-			dump = append(dump, KV{
-				Key: key,
-				Val: val,
-			})
-			slog.Info("dump key", slog.String("key", string(key)))
-		}
-
-		return nil
+		tx := BadgerTx{btx: btx}
+		return f(&tx)
 	})
 	if err != nil {
-		return nil, fmt.Errorf("badger tx: %w", err)
+		return fmt.Errorf("badger read tx: %w", err)
 	}
 
-	return dump, nil
+	return nil
 }
 
 func (tx *BadgerTx) Get(key []byte) ([]byte, error) {
@@ -161,3 +155,46 @@ func (tx *BadgerTx) Set(key []byte, val []byte) error {
 
 	return nil
 }
+
+func (tx *BadgerTx) Delete(key []byte) error {
+	err := tx.btx.Delete(key)
+	if err != nil {
+		return fmt.Errorf("delete badger key %q: %w", key, err)
+	}
+
+	return nil
+}
+
+func (tx *BadgerTx) Iterate(opts IterOpts, f func(key, val []byte) error) error {
+	itOpts := badger.DefaultIteratorOptions
+	itOpts.Prefix = opts.Prefix
+	itOpts.Reverse = opts.Reverse
+	it := tx.btx.NewIterator(itOpts)
+	defer it.Close()
+
+	for it.Seek(opts.seekKey()); it.ValidForPrefix(opts.Prefix); it.Next() {
+		item := it.Item()
+		key := item.Key()
+		if opts.passed(key) {
+			break
+		}
+		if !opts.Contains(key) {
+			continue
+		}
+
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return fmt.Errorf("get value %q: %w", key, err)
+		}
+
+		err = f(key, val)
+		if errors.Is(err, ErrStopIteration) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/config"
+	"github.com/dgraph-io/badger/v4"
+)
+
+type BadgerMaintenanceOpts struct {
+	GCInterval time.Duration
+	// GCDiscardRatio is a share of stale data a value log file must have to be rewritten
+	GCDiscardRatio float64
+	// SizeAlertThreshold is a total size of lsm and value log in bytes to alert at, 0 disables the alert
+	SizeAlertThreshold int64
+}
+
+func NewBadgerMaintenanceOptsFromConfig(cfg config.Config) BadgerMaintenanceOpts {
+	return BadgerMaintenanceOpts{
+		GCInterval:         cfg.Storage.BadgerGCInterval,
+		GCDiscardRatio:     cfg.Storage.BadgerGCDiscardRatio,
+		SizeAlertThreshold: cfg.Storage.BadgerSizeAlertThreshold,
+	}
+}
+
+type BadgerStats struct {
+	LSMSize  int64
+	VlogSize int64
+	// GCRuns counts maintenance runs, GCRewrites counts value log files rewritten by them
+	GCRuns     int
+	GCRewrites int
+	LastGCAt   time.Time
+}
+
+func (s BadgerStats) Size() int64 {
+	return s.LSMSize + s.VlogSize
+}
+
+// Stats returns sizes recorded by the last maintenance run and GC counters
+func (b *BadgerDB) Stats() BadgerStats {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	return b.stats
+}
+
+// StartMaintenance runs value log GC and records sizes every opts.GCInterval until Stop,
+// badger never reclaims the value log by itself, so without it the db grows with every rewrite of a key
+func (b *BadgerDB) StartMaintenance(alerts *alert.Manager, opts BadgerMaintenanceOpts) {
+	if b.badgerOpts.InMemory {
+		slog.Info("badger is in memory, skipping maintenance")
+		return
+	}
+
+	b.maintenanceStop = make(chan struct{})
+	b.maintenanceWg.Add(1)
+	go func() {
+		defer b.maintenanceWg.Done()
+
+		ticker := time.NewTicker(opts.GCInterval)
+		defer ticker.Stop()
+
+		alerted := false
+		for {
+			select {
+			case <-b.maintenanceStop:
+				return
+			case <-ticker.C:
+			}
+
+			stats, err := b.maintain(opts.GCDiscardRatio)
+			if err != nil {
+				alerts.Errorxf(err, "badger maintenance failed")
+				continue
+			}
+
+			switch {
+			case opts.SizeAlertThreshold <= 0:
+			case stats.Size() >= opts.SizeAlertThreshold && !alerted:
+				alerts.Errorf(
+					"badger size is %d bytes (lsm %d, vlog %d), threshold is %d bytes",
+					stats.Size(), stats.LSMSize, stats.VlogSize, opts.SizeAlertThreshold,
+				)
+				alerted = true
+			case stats.Size() < opts.SizeAlertThreshold && alerted:
+				slog.Info("badger size is back below threshold", slog.Int64("size", stats.Size()))
+				alerted = false
+			}
+		}
+	}()
+	slog.Info(
+		"started badger maintenance",
+		slog.Duration("interval", opts.GCInterval),
+		slog.Float64("discard_ratio", opts.GCDiscardRatio),
+	)
+}
+
+func (b *BadgerDB) maintain(discardRatio float64) (BadgerStats, error) {
+	rewrites := 0
+	for {
+		// every successful run rewrites one file, so run until there is nothing to rewrite
+		err := b.bdb.RunValueLogGC(discardRatio)
+		if errors.Is(err, badger.ErrNoRewrite) || errors.Is(err, badger.ErrRejected) {
+			break
+		}
+		if err != nil {
+			return BadgerStats{}, fmt.Errorf("run value log gc: %w", err)
+		}
+		rewrites++
+	}
+
+	lsm, vlog, err := b.sizes()
+	if err != nil {
+		return BadgerStats{}, fmt.Errorf("get sizes: %w", err)
+	}
+
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+	b.stats.LSMSize = lsm
+	b.stats.VlogSize = vlog
+	b.stats.GCRuns++
+	b.stats.GCRewrites += rewrites
+	b.stats.LastGCAt = time.Now()
+
+	slog.Info(
+		"badger maintenance finished",
+		slog.Int("rewrites", rewrites),
+		slog.Int64("lsm_size", lsm),
+		slog.Int64("vlog_size", vlog),
+	)
+	return b.stats, nil
+}
+
+// sizes walks db folders as badger.DB.Size does, the latter is refreshed only once a minute
+func (b *BadgerDB) sizes() (lsm, vlog int64, err error) {
+	dirs := []string{b.badgerOpts.Dir}
+	if b.badgerOpts.ValueDir != b.badgerOpts.Dir {
+		dirs = append(dirs, b.badgerOpts.ValueDir)
+	}
+
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			switch filepath.Ext(path) {
+			case ".sst":
+				lsm += info.Size()
+			case ".vlog":
+				vlog += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, 0, fmt.Errorf("walk %s: %w", dir, err)
+		}
+	}
+
+	return lsm, vlog, nil
+}
@@ -0,0 +1,49 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/tg/tgtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBadgerMaintenance(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	database := db.NewBadgerDB(t.TempDir())
+	require.NoError(t, database.Start(ctx))
+	defer database.Stop()
+
+	for i := range 10 {
+		err := database.Do(ctx, func(tx db.Tx) error {
+			return db.SetJson(tx, "key", strings.Repeat(fmt.Sprint(i), 1024))
+		})
+		require.NoError(t, err)
+	}
+
+	sender := tgtest.NewClient(42)
+	database.StartMaintenance(alert.NewManager(sender), db.BadgerMaintenanceOpts{
+		GCInterval:         10 * time.Millisecond,
+		GCDiscardRatio:     0.5,
+		SizeAlertThreshold: 1,
+	})
+
+	require.Eventually(t, func() bool {
+		return database.Stats().GCRuns >= 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stats := database.Stats()
+	require.Positive(t, stats.Size())
+	require.False(t, stats.LastGCAt.IsZero())
+	// the alert is sent once until the size is back below the threshold
+	msgs := sender.Alerts()
+	require.Len(t, msgs, 1)
+	require.Contains(t, msgs[0], "badger size is")
+}
@@ -1,17 +1,18 @@
 package db
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log/slog"
-	"time"
 )
 
 var (
 	ErrKeyNotFound = errors.New("db: not found")
+	ErrConflict    = errors.New("db: transaction conflict")
+	// ErrStopIteration can be returned from an Iterate callback to stop iterating without an error
+	ErrStopIteration = errors.New("db: stop iteration")
 )
 
 type KV struct {
@@ -22,67 +23,78 @@ type KV struct {
 type DB interface {
 	Start(context.Context) error
 	Stop()
+	// Do runs f in a read-write transaction, transactions are serialized
 	Do(context.Context, func(Tx) error) error
-	Dump(context.Context) ([]KV, error)
+	// View runs f in a read-only transaction, it doesn't wait for concurrent Do calls
+	View(context.Context, func(ReadTx) error) error
 }
 
-type Tx interface {
+type ReadTx interface {
 	Get(key []byte) ([]byte, error)
+	// Iterate calls f for every key matching opts in key order,
+	// key and val are only valid until f returns
+	Iterate(opts IterOpts, f func(key, val []byte) error) error
+}
+
+type Tx interface {
+	ReadTx
+
 	Set(key []byte, val []byte) error
+	Delete(key []byte) error
 }
 
-func DumpJson(ctx context.Context, db DB, writer io.Writer) error {
-	dump, err := db.Dump(ctx)
-	if err != nil {
-		return fmt.Errorf("dump db: %w", err)
-	}
+// IterOpts selects keys having Prefix and lying in [From, To) range,
+// empty fields aren't used for filtering
+type IterOpts struct {
+	Prefix  []byte
+	From    []byte
+	To      []byte
+	Reverse bool
+}
 
-	out := make(map[string]json.RawMessage, len(dump))
-	for _, kv := range dump {
-		out[string(kv.Key)] = kv.Val
+func (o IterOpts) Contains(key []byte) bool {
+	if !bytes.HasPrefix(key, o.Prefix) {
+		return false
 	}
-
-	data, err := json.Marshal(out)
-	if err != nil {
-		return fmt.Errorf("marshall dump: %w", err)
+	if len(o.From) > 0 && bytes.Compare(key, o.From) < 0 {
+		return false
 	}
-
-	_, err = writer.Write(data)
-	if err != nil {
-		return fmt.Errorf("write dump: %w", err)
+	if len(o.To) > 0 && bytes.Compare(key, o.To) >= 0 {
+		return false
 	}
-
-	return nil
+	return true
 }
 
-func RestoreJson(ctx context.Context, db DB, reader io.Reader) error {
-	data, err := io.ReadAll(reader)
-	if err != nil {
-		return fmt.Errorf("read dump: %w", err)
+// seekKey returns the key to start iteration from in the direction of iteration,
+// nil means the first key in the direction
+func (o IterOpts) seekKey() []byte {
+	if !o.Reverse {
+		if bytes.Compare(o.From, o.Prefix) > 0 {
+			return o.From
+		}
+		return o.Prefix
 	}
 
-	var raw map[string]json.RawMessage
-	if err := json.Unmarshal(data, &raw); err != nil {
-		return fmt.Errorf("unmarshall dump: %w", err)
+	var end []byte
+	if len(o.Prefix) > 0 {
+		// keys are compared lexicographically, so it's greater than any key with the prefix
+		end = append(bytes.Clone(o.Prefix), 0xff, 0xff, 0xff, 0xff)
 	}
-
-	dump := make([]KV, 0, len(raw))
-	for k, v := range raw {
-		dump = append(dump, KV{Key: []byte(k), Val: v})
+	if len(o.To) > 0 && (end == nil || bytes.Compare(o.To, end) < 0) {
+		end = o.To
 	}
-	return db.Do(ctx, func(tx Tx) error {
-		for _, kv := range dump {
-			if err := tx.Set(kv.Key, kv.Val); err != nil {
-				return err
-			}
-			slog.Info("set key", slog.String("key", string(kv.Key)))
-		}
+	return end
+}
 
-		return nil
-	})
+// passed reports whether iteration in the opts direction can't meet matching keys after the key
+func (o IterOpts) passed(key []byte) bool {
+	if !o.Reverse {
+		return len(o.To) > 0 && bytes.Compare(key, o.To) >= 0
+	}
+	return len(o.From) > 0 && bytes.Compare(key, o.From) < 0
 }
 
-func GetJson[T any](tx Tx, key string) (T, error) {
+func GetJson[T any](tx ReadTx, key string) (T, error) {
 	data, err := tx.Get([]byte(key))
 	if err != nil {
 		return *new(T), fmt.Errorf("get key: %w", err)
@@ -97,7 +109,7 @@ func GetJson[T any](tx Tx, key string) (T, error) {
 	return result, nil
 }
 
-func GetJsonDefault[T any](tx Tx, key string, defaultVal T) (T, error) {
+func GetJsonDefault[T any](tx ReadTx, key string, defaultVal T) (T, error) {
 	result, err := GetJson[T](tx, key)
 	if errors.Is(err, ErrKeyNotFound) {
 		return defaultVal, nil
@@ -120,64 +132,17 @@ func SetJson[T any](tx Tx, key string, val T) error {
 	return nil
 }
 
-type appliedMigration struct {
-	ID        string    `json:"id"`
-	AppliedAt time.Time `json:"applied_at"`
-}
-
-type appliedMigrations struct {
-	Applied []appliedMigration `json:"applied"`
-}
-
-type Migration struct {
-	ID   string
-	Name string
-	Fn   func(tx Tx) error
-}
-
-func MigrateJson(ctx context.Context, db DB, appliedKey string, migrations []Migration) error {
-	slog.Info("applying migrations")
-	err := db.Do(ctx, func(tx Tx) error {
-		applied, err := GetJsonDefault(tx, appliedKey, appliedMigrations{})
-		if err != nil {
-			return fmt.Errorf("get %q: %w", appliedKey, err)
-		}
-
-		appliedIDs := make(map[string]struct{}, len(applied.Applied))
-		for _, mgr := range applied.Applied {
-			appliedIDs[mgr.ID] = struct{}{}
-		}
-
-		for _, mgr := range migrations {
-			if _, ok := appliedIDs[mgr.ID]; ok {
-				slog.Info(
-					"migration already applied, skipping",
-					slog.String("id", mgr.ID), slog.String("name", mgr.Name),
-				)
-				continue
-			}
-
-			slog.Info("applying migration", slog.String("id", mgr.ID), slog.String("name", mgr.Name))
-			if err := mgr.Fn(tx); err != nil {
-				return fmt.Errorf("apply migration %s: %w", mgr.Name, err)
-			}
-
-			applied.Applied = append(applied.Applied, appliedMigration{
-				ID:        mgr.ID,
-				AppliedAt: time.Now(),
-			})
-		}
-
-		if err := SetJson(tx, appliedKey, applied); err != nil {
-			return fmt.Errorf("set %q: %w", appliedKey, err)
+func IterateJson[T any](tx ReadTx, opts IterOpts, f func(key string, val T) error) error {
+	return tx.Iterate(opts, func(key, val []byte) error {
+		result := *new(T)
+		if err := json.Unmarshal(val, &result); err != nil {
+			return fmt.Errorf("unmarshall key %q val: %w", key, err)
 		}
 
-		return nil
+		return f(string(key), result)
 	})
-	if err != nil {
-		return err
-	}
+}
 
-	slog.Info("migrations applied")
-	return nil
+func PrefixOpts(prefix string) IterOpts {
+	return IterOpts{Prefix: []byte(prefix)}
 }
@@ -0,0 +1,435 @@
+package dbtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/lang"
+	"github.com/stretchr/testify/require"
+)
+
+// Run checks that a db.DB implementation conforms to the interface contract,
+// newDB must return a new empty not started database on every call
+func Run(t *testing.T, newDB func() db.DB) {
+	t.Helper()
+
+	database, restoreDB := newDB(), newDB()
+	ctx := context.Background()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+	err = restoreDB.Start(ctx)
+	require.NoError(t, err)
+	defer restoreDB.Stop()
+
+	t.Run("get set", func(t *testing.T) {
+		key := "key1"
+		err := database.Do(ctx, func(tx db.Tx) error {
+			_, err := db.GetJson[int](tx, key)
+			require.ErrorIs(t, err, db.ErrKeyNotFound)
+
+			err = db.SetJson(tx, key, 55)
+			require.NoError(t, err)
+
+			val, err := db.GetJson[int](tx, key)
+			require.NoError(t, err)
+			require.Equal(t, 55, val)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	type S1 struct {
+		A string `json:"a,omitempty"`
+		B int    `json:"b,omitempty"`
+	}
+
+	type S2 struct {
+		Struct S1                  `json:"struct,omitempty"`
+		Slice  []int               `json:"slice,omitempty"`
+		String string              `json:"string"`
+		Int    int                 `json:"int"`
+		Float  float64             `json:"float"`
+		True   bool                `json:"true"`
+		False  bool                `json:"false"`
+		Null   *int                `json:"null"`
+		Map    map[string]int      `json:"map"`
+		Set    map[string]struct{} `json:"set"`
+	}
+
+	testSet := S2{
+		Struct: S1{
+			A: "s1_a",
+			B: 33,
+		},
+		Slice:  []int{444, 13, 44, -1, 0, 44},
+		String: "string sg",
+		Int:    535533535,
+		Float:  -666.44,
+		True:   true,
+		False:  false,
+		Null:   nil,
+		Map:    map[string]int{"k1": 1, "k2": 2, "k3": 3},
+		Set:    map[string]struct{}{"k1": {}, "k2": {}, "k3": {}},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		key := "key2"
+		err := database.Do(ctx, func(tx db.Tx) error {
+			err := db.SetJson[*int](tx, key, nil)
+			require.NoError(t, err)
+			intPtrRes, err := db.GetJson[*int](tx, key)
+			require.NoError(t, err)
+			require.Equal(t, (*int)(nil), intPtrRes)
+
+			err = db.SetJson(tx, key, lang.NewPtr(2))
+			require.NoError(t, err)
+			intPtrRes, err = db.GetJson[*int](tx, key)
+			require.NoError(t, err)
+			require.Equal(t, lang.NewPtr(2), intPtrRes)
+
+			err = db.SetJson(tx, key, 1)
+			require.NoError(t, err)
+			intRes, err := db.GetJson[int](tx, key)
+			require.NoError(t, err)
+			require.Equal(t, 1, intRes)
+
+			err = db.SetJson(tx, key, 1.0)
+			require.NoError(t, err)
+			floatRes, err := db.GetJson[float64](tx, key)
+			require.NoError(t, err)
+			require.InEpsilon(t, 1.0, floatRes, 1e-7)
+
+			err = db.SetJson(tx, key, "string")
+			require.NoError(t, err)
+			strRes, err := db.GetJson[string](tx, key)
+			require.NoError(t, err)
+			require.Equal(t, "string", strRes)
+
+			err = db.SetJson(tx, key, true)
+			require.NoError(t, err)
+			trueRes, err := db.GetJson[bool](tx, key)
+			require.NoError(t, err)
+			require.True(t, trueRes)
+
+			err = db.SetJson(tx, key, false)
+			require.NoError(t, err)
+			falseRes, err := db.GetJson[bool](tx, key)
+			require.NoError(t, err)
+			require.False(t, falseRes)
+
+			err = db.SetJson(tx, key, []int{4, 8, 9, 33})
+			require.NoError(t, err)
+			sliceRes, err := db.GetJson[[]int](tx, key)
+			require.NoError(t, err)
+			require.Equal(t, []int{4, 8, 9, 33}, sliceRes)
+
+			tm := time.Now()
+			err = db.SetJson(tx, key, tm)
+			require.NoError(t, err)
+			timeRes, err := db.GetJson[time.Time](tx, key)
+			require.NoError(t, err)
+			require.True(t, tm.Equal(timeRes))
+
+			err = db.SetJson(tx, key, testSet)
+			require.NoError(t, err)
+			structRes, err := db.GetJson[S2](tx, key)
+			require.NoError(t, err)
+			require.Equal(t, testSet, structRes)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("json backup", func(t *testing.T) {
+		keys := []string{"key3.0", "key3.1", "key3.2"}
+		err := database.Do(ctx, func(tx db.Tx) error {
+			for _, key := range keys {
+				err = db.SetJson(tx, key, testSet)
+				require.NoError(t, err)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.Empty(t, buf)
+		header, err := db.DumpJson(ctx, database, &buf, db.DumpOpts{Prefix: "key3."})
+		require.NoError(t, err)
+		require.NotEmpty(t, buf)
+		require.Equal(t, len(keys), header.KeyCount)
+
+		result, err := db.RestoreJson(ctx, restoreDB, &buf, db.RestoreOpts{ChunkSize: 2})
+		require.NoError(t, err)
+		require.Equal(t, len(keys), result.Restored)
+		err = restoreDB.Do(ctx, func(tx db.Tx) error {
+			for _, key := range keys {
+				restored, err := db.GetJson[S2](tx, key)
+				require.NoError(t, err)
+				require.Equal(t, testSet, restored)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		key := "key4"
+		err := database.Do(ctx, func(tx db.Tx) error {
+			err = db.SetJson(tx, key, 80)
+			require.NoError(t, err)
+			return nil
+		})
+		require.NoError(t, err)
+
+		err = database.Do(ctx, func(tx db.Tx) error {
+			err = db.SetJson(tx, key, 90)
+			require.NoError(t, err)
+			return errors.New("err after set")
+		})
+		require.Error(t, err)
+
+		err = database.Do(ctx, func(tx db.Tx) error {
+			val, err := db.GetJson[int](tx, key)
+			require.NoError(t, err)
+			require.Equal(t, 80, val)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		key := "key6"
+		err := database.Do(ctx, func(tx db.Tx) error {
+			err := tx.Delete([]byte(key))
+			require.NoError(t, err)
+
+			err = db.SetJson(tx, key, 1)
+			require.NoError(t, err)
+
+			err = tx.Delete([]byte(key))
+			require.NoError(t, err)
+
+			_, err = db.GetJson[int](tx, key)
+			require.ErrorIs(t, err, db.ErrKeyNotFound)
+			return nil
+		})
+		require.NoError(t, err)
+
+		err = database.View(ctx, func(tx db.ReadTx) error {
+			_, err := db.GetJson[int](tx, key)
+			require.ErrorIs(t, err, db.ErrKeyNotFound)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("iterate", func(t *testing.T) {
+		err := database.Do(ctx, func(tx db.Tx) error {
+			for _, key := range []string{"iter:a:1", "iter:a:2", "iter:a:3", "iter:b:1", "iter:c"} {
+				err := db.SetJson(tx, key, key)
+				require.NoError(t, err)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		collect := func(opts db.IterOpts) []string {
+			var keys []string
+			err := database.View(ctx, func(tx db.ReadTx) error {
+				return db.IterateJson(tx, opts, func(key string, val string) error {
+					require.Equal(t, key, val)
+					keys = append(keys, key)
+					return nil
+				})
+			})
+			require.NoError(t, err)
+			return keys
+		}
+
+		require.Equal(t, []string{"iter:a:1", "iter:a:2", "iter:a:3"}, collect(db.PrefixOpts("iter:a:")))
+		require.Equal(t, []string{"iter:a:3", "iter:a:2", "iter:a:1"}, collect(db.IterOpts{
+			Prefix:  []byte("iter:a:"),
+			Reverse: true,
+		}))
+		require.Equal(t, []string{"iter:a:2", "iter:a:3", "iter:b:1"}, collect(db.IterOpts{
+			Prefix: []byte("iter:"),
+			From:   []byte("iter:a:2"),
+			To:     []byte("iter:c"),
+		}))
+		require.Equal(t, []string{"iter:b:1", "iter:a:3", "iter:a:2"}, collect(db.IterOpts{
+			Prefix:  []byte("iter:"),
+			From:    []byte("iter:a:2"),
+			To:      []byte("iter:c"),
+			Reverse: true,
+		}))
+		require.Equal(t, []string{"iter:c", "iter:b:1"}, collect(db.IterOpts{
+			From:    []byte("iter:b"),
+			To:      []byte("iter:d"),
+			Reverse: true,
+		}))
+		require.Empty(t, collect(db.PrefixOpts("iter:d")))
+
+		var first []string
+		err = database.View(ctx, func(tx db.ReadTx) error {
+			return tx.Iterate(db.PrefixOpts("iter:"), func(key, val []byte) error {
+				first = append(first, string(key))
+				return db.ErrStopIteration
+			})
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"iter:a:1"}, first)
+
+		err = database.Do(ctx, func(tx db.Tx) error {
+			return tx.Iterate(db.PrefixOpts("iter:a:"), func(key, val []byte) error {
+				return tx.Delete(key)
+			})
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"iter:b:1", "iter:c"}, collect(db.PrefixOpts("iter:")))
+	})
+
+	t.Run("iterate many", func(t *testing.T) {
+		const count = 1000
+		err := database.Do(ctx, func(tx db.Tx) error {
+			for i := 0; i < count; i++ {
+				err := db.SetJson(tx, fmt.Sprintf("many:%04d", i), i)
+				require.NoError(t, err)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		for _, reverse := range []bool{false, true} {
+			var vals []int
+			err = database.View(ctx, func(tx db.ReadTx) error {
+				opts := db.IterOpts{Prefix: []byte("many:"), Reverse: reverse}
+				return db.IterateJson(tx, opts, func(key string, val int) error {
+					require.Equal(t, fmt.Sprintf("many:%04d", val), key)
+					vals = append(vals, val)
+					return nil
+				})
+			})
+			require.NoError(t, err)
+			require.Len(t, vals, count)
+			require.True(t, slices.IsSorted(vals) != reverse)
+		}
+	})
+
+	t.Run("view", func(t *testing.T) {
+		key := "key7"
+		err := database.Do(ctx, func(tx db.Tx) error {
+			return db.SetJson(tx, key, 7)
+		})
+		require.NoError(t, err)
+
+		err = database.View(ctx, func(tx db.ReadTx) error {
+			val, err := db.GetJson[int](tx, key)
+			require.NoError(t, err)
+			require.Equal(t, 7, val)
+			return nil
+		})
+		require.NoError(t, err)
+
+		viewErr := errors.New("view err")
+		err = database.View(ctx, func(tx db.ReadTx) error {
+			return viewErr
+		})
+		require.ErrorIs(t, err, viewErr)
+	})
+
+	t.Run("transaction delete", func(t *testing.T) {
+		key := "key8"
+		err := database.Do(ctx, func(tx db.Tx) error {
+			return db.SetJson(tx, key, 8)
+		})
+		require.NoError(t, err)
+
+		err = database.Do(ctx, func(tx db.Tx) error {
+			err := tx.Delete([]byte(key))
+			require.NoError(t, err)
+			return errors.New("err after delete")
+		})
+		require.Error(t, err)
+
+		err = database.View(ctx, func(tx db.ReadTx) error {
+			val, err := db.GetJson[int](tx, key)
+			require.NoError(t, err)
+			require.Equal(t, 8, val)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("view snapshot", func(t *testing.T) {
+		key := "key9"
+		err := database.Do(ctx, func(tx db.Tx) error {
+			return db.SetJson(tx, key, 1)
+		})
+		require.NoError(t, err)
+
+		err = database.View(ctx, func(tx db.ReadTx) error {
+			val, err := db.GetJson[int](tx, key)
+			require.NoError(t, err)
+			require.Equal(t, 1, val)
+
+			// view must not block writers
+			err = database.Do(ctx, func(tx db.Tx) error {
+				return db.SetJson(tx, key, 2)
+			})
+			require.NoError(t, err)
+
+			val, err = db.GetJson[int](tx, key)
+			require.NoError(t, err)
+			require.Equal(t, 1, val)
+			return nil
+		})
+		require.NoError(t, err)
+
+		err = database.View(ctx, func(tx db.ReadTx) error {
+			val, err := db.GetJson[int](tx, key)
+			require.NoError(t, err)
+			require.Equal(t, 2, val)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("concurrent transactions", func(t *testing.T) {
+		key := "key5"
+
+		var wg sync.WaitGroup
+		wg.Add(10000)
+		for i := 0; i < 10000; i++ {
+			//nolint:testifylint
+			go func() {
+				err := database.Do(ctx, func(tx db.Tx) error {
+					val, err := db.GetJsonDefault[int](tx, key, 0)
+					require.NoError(t, err)
+
+					err = db.SetJson(tx, key, val+1)
+					require.NoError(t, err)
+					return nil
+				})
+				require.NoError(t, err)
+				wg.Done()
+			}()
+		}
+		wg.Wait()
+
+		err = database.Do(ctx, func(tx db.Tx) error {
+			val, err := db.GetJson[int](tx, key)
+			require.NoError(t, err)
+			require.Equal(t, 10000, val)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,366 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Dump is a stream of json lines:
+// the first one is a DumpHeader, every other one is a dumpRecord ordered by key.
+// Values which aren't valid json are written base64 encoded, so any stored bytes can be dumped.
+// Legacy dumps (version 1) are a single json object mapping keys to values.
+const (
+	DumpFormat  = "drone-dump"
+	DumpVersion = 2
+
+	defaultRestoreChunkSize = 1000
+)
+
+type DumpHeader struct {
+	Format            string    `json:"format"`
+	Version           int       `json:"version"`
+	CreatedAt         time.Time `json:"created_at"`
+	Prefix            string    `json:"prefix,omitempty"`
+	AppliedMigrations []string  `json:"applied_migrations"`
+	KeyCount          int       `json:"key_count"`
+	// Checksum is a sha256 over all record lines of the dump
+	Checksum string `json:"checksum"`
+}
+
+type dumpRecord struct {
+	Key string          `json:"key"`
+	Val json.RawMessage `json:"val,omitempty"`
+	// ValB64 is set instead of Val for values which aren't valid json
+	ValB64 []byte `json:"val_b64,omitempty"`
+}
+
+func newDumpRecord(key string, val []byte) dumpRecord {
+	if json.Valid(val) {
+		return dumpRecord{Key: key, Val: val}
+	}
+	return dumpRecord{Key: key, ValB64: val}
+}
+
+// value returns the stored value of the record
+func (r dumpRecord) value() []byte {
+	if r.Val != nil {
+		return r.Val
+	}
+	return r.ValB64
+}
+
+type DumpOpts struct {
+	// Prefix limits dump to keys with the prefix
+	Prefix string
+	// AppliedMigrationsKey is a key with migrations applied by MigrateJson to put in the header
+	AppliedMigrationsKey string
+}
+
+// DumpJson writes a consistent snapshot of the db without loading it in memory,
+// db is read twice in one transaction to put key count and checksum in the header
+func DumpJson(ctx context.Context, db DB, writer io.Writer, opts DumpOpts) (DumpHeader, error) {
+	var header DumpHeader
+	err := db.View(ctx, func(tx ReadTx) error {
+		header = DumpHeader{
+			Format:            DumpFormat,
+			Version:           DumpVersion,
+			CreatedAt:         time.Now().UTC(),
+			Prefix:            opts.Prefix,
+			AppliedMigrations: make([]string, 0),
+		}
+		if opts.AppliedMigrationsKey != "" {
+			applied, err := GetJsonDefault(tx, opts.AppliedMigrationsKey, appliedMigrations{})
+			if err != nil {
+				return fmt.Errorf("get %q: %w", opts.AppliedMigrationsKey, err)
+			}
+			for _, mgr := range applied.Applied {
+				header.AppliedMigrations = append(header.AppliedMigrations, mgr.ID)
+			}
+		}
+
+		checksum := sha256.New()
+		err := iterateRecordLines(tx, opts.Prefix, func(_ string, line []byte) error {
+			header.KeyCount++
+			checksum.Write(line)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		header.Checksum = hex.EncodeToString(checksum.Sum(nil))
+
+		buf := bufio.NewWriter(writer)
+		if err := writeLine(buf, header); err != nil {
+			return fmt.Errorf("write header: %w", err)
+		}
+
+		err = iterateRecordLines(tx, opts.Prefix, func(key string, line []byte) error {
+			if _, err := buf.Write(line); err != nil {
+				return fmt.Errorf("write key %q: %w", key, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := buf.Flush(); err != nil {
+			return fmt.Errorf("flush dump: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return DumpHeader{}, fmt.Errorf("dump db: %w", err)
+	}
+
+	slog.Info(
+		"dumped db",
+		slog.Int("key_count", header.KeyCount),
+		slog.String("checksum", header.Checksum),
+		slog.String("prefix", header.Prefix),
+	)
+	return header, nil
+}
+
+func iterateRecordLines(tx ReadTx, prefix string, f func(key string, line []byte) error) error {
+	return tx.Iterate(PrefixOpts(prefix), func(key, val []byte) error {
+		line, err := recordLine(newDumpRecord(string(key), val))
+		if err != nil {
+			return err
+		}
+
+		return f(string(key), line)
+	})
+}
+
+func recordLine(record dumpRecord) ([]byte, error) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("marshall key %q: %w", record.Key, err)
+	}
+
+	return append(line, '\n'), nil
+}
+
+func writeLine(writer io.Writer, val any) error {
+	line, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Errorf("marshall: %w", err)
+	}
+
+	_, err = writer.Write(append(line, '\n'))
+	return err
+}
+
+type RestoreOpts struct {
+	// Prefix limits restore to keys with the prefix
+	Prefix string
+	// DryRun reads and verifies the dump without writing to the db
+	DryRun bool
+	// ChunkSize is the number of keys written in one transaction
+	ChunkSize int
+}
+
+type RestoreResult struct {
+	Header   DumpHeader
+	Restored int
+}
+
+// RestoreJson writes dump records to the db in chunks, each chunk in a separate transaction.
+// Count and checksum are verified only after the whole dump is read,
+// so use RestoreOpts.DryRun to check a dump before restoring it.
+func RestoreJson(ctx context.Context, db DB, reader io.Reader, opts RestoreOpts) (RestoreResult, error) {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultRestoreChunkSize
+	}
+
+	restorer := newChunkRestorer(ctx, db, opts)
+	header, err := ScanDump(reader, func(key string, val []byte) error {
+		return restorer.add(key, val)
+	})
+	if err != nil {
+		return RestoreResult{Header: header, Restored: restorer.restored}, err
+	}
+	if err := restorer.flush(); err != nil {
+		return RestoreResult{}, err
+	}
+
+	slog.Info(
+		"restored db",
+		slog.Int("version", header.Version),
+		slog.Int("read", header.KeyCount),
+		slog.Int("restored", restorer.restored),
+		slog.Bool("dry_run", opts.DryRun),
+	)
+	return RestoreResult{Header: header, Restored: restorer.restored}, nil
+}
+
+// ScanDump calls f for every record of the dump in order,
+// the dump key count and checksum are verified after the last record.
+// Legacy dumps have no header, so a header with version 1 and key count is returned for them.
+func ScanDump(reader io.Reader, f func(key string, val []byte) error) (DumpHeader, error) {
+	dec := json.NewDecoder(bufio.NewReader(reader))
+	var first map[string]json.RawMessage
+	if err := dec.Decode(&first); err != nil {
+		return DumpHeader{}, fmt.Errorf("decode dump header: %w", err)
+	}
+
+	var rawFormat string
+	if raw, ok := first["format"]; !ok || json.Unmarshal(raw, &rawFormat) != nil || rawFormat != DumpFormat {
+		return scanLegacyDump(first, f)
+	}
+
+	var header DumpHeader
+	if err := remarshal(first, &header); err != nil {
+		return DumpHeader{}, fmt.Errorf("decode dump header: %w", err)
+	}
+	if header.Version != DumpVersion {
+		return DumpHeader{}, fmt.Errorf("unsupported dump version %d", header.Version)
+	}
+
+	read := 0
+	checksum := sha256.New()
+	for {
+		var record dumpRecord
+		err := dec.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return header, fmt.Errorf("decode record %d: %w", read, err)
+		}
+
+		read++
+		if err := addToChecksum(checksum, record); err != nil {
+			return header, err
+		}
+		if err := f(record.Key, record.value()); err != nil {
+			return header, err
+		}
+	}
+
+	if read != header.KeyCount {
+		return header, fmt.Errorf("dump is corrupted: read %d keys, header has %d", read, header.KeyCount)
+	}
+	if sum := hex.EncodeToString(checksum.Sum(nil)); sum != header.Checksum {
+		return header, fmt.Errorf("dump is corrupted: checksum %s, header has %s", sum, header.Checksum)
+	}
+
+	return header, nil
+}
+
+func scanLegacyDump(raw map[string]json.RawMessage, f func(key string, val []byte) error) (DumpHeader, error) {
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	header := DumpHeader{
+		Version:  1,
+		KeyCount: len(keys),
+	}
+	for _, key := range keys {
+		if err := f(key, raw[key]); err != nil {
+			return header, err
+		}
+	}
+
+	return header, nil
+}
+
+func remarshal(src any, dst any) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dst)
+}
+
+func addToChecksum(checksum hash.Hash, record dumpRecord) error {
+	line, err := recordLine(record)
+	if err != nil {
+		return err
+	}
+
+	checksum.Write(line)
+	return nil
+}
+
+type restoredKey struct {
+	key string
+	val []byte
+}
+
+type chunkRestorer struct {
+	ctx      context.Context
+	db       DB
+	opts     RestoreOpts
+	chunk    []restoredKey
+	restored int
+}
+
+func newChunkRestorer(ctx context.Context, db DB, opts RestoreOpts) *chunkRestorer {
+	return &chunkRestorer{
+		ctx:   ctx,
+		db:    db,
+		opts:  opts,
+		chunk: make([]restoredKey, 0, opts.ChunkSize),
+	}
+}
+
+func (r *chunkRestorer) add(key string, val []byte) error {
+	if !strings.HasPrefix(key, r.opts.Prefix) {
+		return nil
+	}
+
+	r.chunk = append(r.chunk, restoredKey{key: key, val: val})
+	if len(r.chunk) < r.opts.ChunkSize {
+		return nil
+	}
+
+	return r.flush()
+}
+
+func (r *chunkRestorer) flush() error {
+	if len(r.chunk) == 0 {
+		return nil
+	}
+
+	chunk := r.chunk
+	r.chunk = r.chunk[:0]
+	if r.opts.DryRun {
+		r.restored += len(chunk)
+		return nil
+	}
+
+	err := r.db.Do(r.ctx, func(tx Tx) error {
+		for _, record := range chunk {
+			if err := tx.Set([]byte(record.key), record.val); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("restore chunk %q..%q: %w", chunk[0].key, chunk[len(chunk)-1].key, err)
+	}
+
+	r.restored += len(chunk)
+	slog.Info("restored chunk", slog.Int("keys", len(chunk)), slog.Int("restored", r.restored))
+	return nil
+}
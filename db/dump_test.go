@@ -0,0 +1,180 @@
+package db_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/stretchr/testify/require"
+)
+
+const migrationsKey = "migrations"
+
+func newDumpTestDB(t *testing.T, ctx context.Context, keys map[string]int) db.DB {
+	database := memdb.New()
+	require.NoError(t, database.Start(ctx))
+	t.Cleanup(database.Stop)
+
+	err := database.Do(ctx, func(tx db.Tx) error {
+		for key, val := range keys {
+			require.NoError(t, db.SetJson(tx, key, val))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	return database
+}
+
+func dumpKeys(t *testing.T, ctx context.Context, database db.DB) map[string]int {
+	result := make(map[string]int)
+	err := database.View(ctx, func(tx db.ReadTx) error {
+		return tx.Iterate(db.IterOpts{}, func(key, val []byte) error {
+			if string(key) == migrationsKey {
+				return nil
+			}
+			var v int
+			if err := json.Unmarshal(val, &v); err != nil {
+				return err
+			}
+			result[string(key)] = v
+			return nil
+		})
+	})
+	require.NoError(t, err)
+
+	return result
+}
+
+func TestDumpRestore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	keys := make(map[string]int)
+	for i := range 25 {
+		keys[fmt.Sprintf("a:%02d", i)] = i
+		keys[fmt.Sprintf("b:%02d", i)] = -i
+	}
+	database := newDumpTestDB(t, ctx, keys)
+	err := db.MigrateJson(ctx, database, migrationsKey, []db.Migration{
+		{ID: "0001", Name: "noop", Fn: func(tx db.Tx) error { return nil }},
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	header, err := db.DumpJson(ctx, database, &buf, db.DumpOpts{AppliedMigrationsKey: migrationsKey})
+	require.NoError(t, err)
+	require.Equal(t, db.DumpFormat, header.Format)
+	require.Equal(t, db.DumpVersion, header.Version)
+	require.Equal(t, []string{"0001"}, header.AppliedMigrations)
+	require.Equal(t, len(keys)+1, header.KeyCount)
+	require.Len(t, strings.Split(strings.TrimSpace(buf.String()), "\n"), len(keys)+2)
+	dump := buf.Bytes()
+
+	t.Run("chunked", func(t *testing.T) {
+		restoreDB := newDumpTestDB(t, ctx, nil)
+		result, err := db.RestoreJson(ctx, restoreDB, bytes.NewReader(dump), db.RestoreOpts{ChunkSize: 7})
+		require.NoError(t, err)
+		require.Equal(t, len(keys)+1, result.Restored)
+		require.Equal(t, header.Checksum, result.Header.Checksum)
+
+		require.Equal(t, keys, dumpKeys(t, ctx, restoreDB))
+	})
+
+	t.Run("prefix", func(t *testing.T) {
+		restoreDB := newDumpTestDB(t, ctx, nil)
+		result, err := db.RestoreJson(ctx, restoreDB, bytes.NewReader(dump), db.RestoreOpts{Prefix: "b:"})
+		require.NoError(t, err)
+		require.Equal(t, 25, result.Restored)
+
+		for key := range dumpKeys(t, ctx, restoreDB) {
+			require.True(t, strings.HasPrefix(key, "b:"), key)
+		}
+
+		var prefixBuf bytes.Buffer
+		header, err := db.DumpJson(ctx, database, &prefixBuf, db.DumpOpts{Prefix: "a:"})
+		require.NoError(t, err)
+		require.Equal(t, 25, header.KeyCount)
+		require.Equal(t, "a:", header.Prefix)
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		restoreDB := newDumpTestDB(t, ctx, nil)
+		result, err := db.RestoreJson(ctx, restoreDB, bytes.NewReader(dump), db.RestoreOpts{DryRun: true})
+		require.NoError(t, err)
+		require.Equal(t, len(keys)+1, result.Restored)
+		require.Empty(t, dumpKeys(t, ctx, restoreDB))
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		corrupted := bytes.Replace(dump, []byte(`"val":24`), []byte(`"val":42`), 1)
+		require.NotEqual(t, dump, corrupted)
+		_, err := db.RestoreJson(ctx, newDumpTestDB(t, ctx, nil), bytes.NewReader(corrupted), db.RestoreOpts{DryRun: true})
+		require.ErrorContains(t, err, "checksum")
+
+		lines := bytes.SplitAfter(dump, []byte("\n"))
+		truncated := bytes.Join(lines[:len(lines)-2], nil)
+		_, err = db.RestoreJson(ctx, newDumpTestDB(t, ctx, nil), bytes.NewReader(truncated), db.RestoreOpts{DryRun: true})
+		require.ErrorContains(t, err, "keys")
+	})
+}
+
+func TestDumpRestoreRawValues(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	database := newDumpTestDB(t, ctx, nil)
+	vals := map[string][]byte{
+		"json":    []byte(`{"a":1}`),
+		"invalid": []byte("{"),
+		"binary":  {0, 0xff, '\n'},
+	}
+	err := database.Do(ctx, func(tx db.Tx) error {
+		for key, val := range vals {
+			require.NoError(t, tx.Set([]byte(key), val))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	header, err := db.DumpJson(ctx, database, &buf, db.DumpOpts{})
+	require.NoError(t, err)
+	require.Equal(t, len(vals), header.KeyCount)
+	require.Contains(t, buf.String(), `"val_b64":"ew=="`)
+
+	restoreDB := newDumpTestDB(t, ctx, nil)
+	result, err := db.RestoreJson(ctx, restoreDB, &buf, db.RestoreOpts{})
+	require.NoError(t, err)
+	require.Equal(t, len(vals), result.Restored)
+	err = restoreDB.View(ctx, func(tx db.ReadTx) error {
+		for key, val := range vals {
+			restored, err := tx.Get([]byte(key))
+			require.NoError(t, err)
+			require.Equal(t, val, restored, key)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestRestoreLegacyDump(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	keys := map[string]int{"a": 1, "b": 2, "c": 3}
+	dump, err := json.Marshal(keys)
+	require.NoError(t, err)
+
+	database := newDumpTestDB(t, ctx, nil)
+	result, err := db.RestoreJson(ctx, database, bytes.NewReader(dump), db.RestoreOpts{ChunkSize: 2})
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Header.Version)
+	require.Equal(t, 3, result.Restored)
+	require.Equal(t, keys, dumpKeys(t, ctx, database))
+}
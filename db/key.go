@@ -0,0 +1,237 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Key is a key holding a json value of type T,
+// keys are declared once with DeclareKey or DeclareKeyFamily, so a key is always read with the same type
+type Key[T any] struct {
+	name string
+}
+
+// NewKey returns a key without declaring it, use it for keys which are declared by a family of another type,
+// e.g. with raw json values
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+func (k Key[T]) String() string {
+	return k.name
+}
+
+func (k Key[T]) Get(tx ReadTx) (T, error) {
+	result, err := GetJson[T](tx, k.name)
+	if err != nil {
+		return result, fmt.Errorf("get %q: %w", k.name, err)
+	}
+
+	return result, nil
+}
+
+func (k Key[T]) GetDefault(tx ReadTx, defaultVal T) (T, error) {
+	result, err := GetJsonDefault(tx, k.name, defaultVal)
+	if err != nil {
+		return result, fmt.Errorf("get %q: %w", k.name, err)
+	}
+
+	return result, nil
+}
+
+func (k Key[T]) Set(tx Tx, val T) error {
+	if err := SetJson(tx, k.name, val); err != nil {
+		return fmt.Errorf("set %q: %w", k.name, err)
+	}
+
+	return nil
+}
+
+func (k Key[T]) Delete(tx Tx) error {
+	if err := tx.Delete([]byte(k.name)); err != nil {
+		return fmt.Errorf("delete %q: %w", k.name, err)
+	}
+
+	return nil
+}
+
+// KeyFamily is a set of keys sharing a prefix and a type, e.g. a key per user
+type KeyFamily[T any] struct {
+	prefix string
+}
+
+func (f KeyFamily[T]) Prefix() string {
+	return f.prefix
+}
+
+func (f KeyFamily[T]) Key(suffix string) Key[T] {
+	return Key[T]{name: f.prefix + suffix}
+}
+
+func (f KeyFamily[T]) Keyf(format string, args ...any) Key[T] {
+	return f.Key(fmt.Sprintf(format, args...))
+}
+
+// Iterate calls fn with key suffixes and values of the family keys matching opts,
+// empty opts.Prefix means the family prefix
+func (f KeyFamily[T]) Iterate(tx ReadTx, opts IterOpts, fn func(suffix string, val T) error) error {
+	if len(opts.Prefix) == 0 {
+		opts.Prefix = []byte(f.prefix)
+	}
+	if !strings.HasPrefix(string(opts.Prefix), f.prefix) {
+		return fmt.Errorf("prefix %q is outside of family %q", opts.Prefix, f.prefix)
+	}
+
+	return IterateJson(tx, opts, func(key string, val T) error {
+		return fn(strings.TrimPrefix(key, f.prefix), val)
+	})
+}
+
+type KeyInfo struct {
+	Owner string
+	// Pattern is either a key or a family prefix followed by '*'
+	Pattern string
+	// Type is a Go type of values
+	Type   string
+	decode func(val []byte) (any, error)
+}
+
+func (i KeyInfo) Family() bool {
+	return strings.HasSuffix(i.Pattern, "*")
+}
+
+// Decode unmarshalls val into the declared type
+func (i KeyInfo) Decode(val []byte) (any, error) {
+	return i.decode(val)
+}
+
+// Pretty returns val decoded into the declared type and indented,
+// so fields missing in the type are dropped and missing in val are shown with zero values
+func (i KeyInfo) Pretty(val []byte) ([]byte, error) {
+	decoded, err := i.decode(val)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(decoded, "", "  ")
+}
+
+func (i KeyInfo) match(key string) (int, bool) {
+	if prefix, ok := strings.CutSuffix(i.Pattern, "*"); ok {
+		return len(prefix), strings.HasPrefix(key, prefix)
+	}
+	// exact key wins over any prefix of the same length
+	return len(i.Pattern) + 1, key == i.Pattern
+}
+
+// KeyRegistry lists declared keys with their owners
+type KeyRegistry struct {
+	mu    sync.Mutex
+	infos []KeyInfo
+}
+
+func NewKeyRegistry() *KeyRegistry {
+	return &KeyRegistry{}
+}
+
+// DefaultKeyRegistry holds keys declared with DeclareKey and DeclareKeyFamily,
+// packages declare keys in package level vars, so it's filled on import
+var DefaultKeyRegistry = NewKeyRegistry()
+
+func DeclareKey[T any](owner, name string) Key[T] {
+	return DeclareKeyIn[T](DefaultKeyRegistry, owner, name)
+}
+
+func DeclareKeyFamily[T any](owner, prefix string) KeyFamily[T] {
+	return DeclareKeyFamilyIn[T](DefaultKeyRegistry, owner, prefix)
+}
+
+func DeclareKeyIn[T any](r *KeyRegistry, owner, name string) Key[T] {
+	declare[T](r, owner, name)
+	return Key[T]{name: name}
+}
+
+func DeclareKeyFamilyIn[T any](r *KeyRegistry, owner, prefix string) KeyFamily[T] {
+	declare[T](r, owner, prefix+"*")
+	return KeyFamily[T]{prefix: prefix}
+}
+
+func declare[T any](r *KeyRegistry, owner, pattern string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.infos = append(r.infos, KeyInfo{
+		Owner:   owner,
+		Pattern: pattern,
+		Type:    reflect.TypeFor[T]().String(),
+		decode: func(val []byte) (any, error) {
+			var result T
+			if err := json.Unmarshal(val, &result); err != nil {
+				return nil, err
+			}
+			return result, nil
+		},
+	})
+}
+
+// Keys returns declared keys sorted by pattern
+func (r *KeyRegistry) Keys() []KeyInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := slices.Clone(r.infos)
+	slices.SortStableFunc(result, func(a, b KeyInfo) int {
+		return strings.Compare(a.Pattern, b.Pattern)
+	})
+	return result
+}
+
+// Lookup returns the most specific declaration matching the key
+func (r *KeyRegistry) Lookup(key string) (KeyInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result KeyInfo
+	best := -1
+	for _, info := range r.infos {
+		if l, ok := info.match(key); ok && l > best {
+			result, best = info, l
+		}
+	}
+
+	return result, best >= 0
+}
+
+// Collisions returns declarations of the same key or family
+// and keys or families lying inside another family
+func (r *KeyRegistry) Collisions() []string {
+	infos := r.Keys()
+	result := make([]string, 0)
+	for i, a := range infos {
+		for _, b := range infos[i+1:] {
+			if a.Pattern == b.Pattern {
+				result = append(result, fmt.Sprintf(
+					"%s is declared twice: by %s as %s and by %s as %s", a.Pattern, a.Owner, a.Type, b.Owner, b.Type,
+				))
+			}
+		}
+		if !a.Family() {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(a.Pattern, "*")
+		for _, b := range infos {
+			if b.Pattern != a.Pattern && strings.HasPrefix(b.Pattern, prefix) {
+				result = append(result, fmt.Sprintf(
+					"%s of %s is inside family %s of %s", b.Pattern, b.Owner, a.Pattern, a.Owner,
+				))
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,87 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKey(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	database := memdb.New()
+	require.NoError(t, database.Start(ctx))
+	defer database.Stop()
+
+	registry := db.NewKeyRegistry()
+	counter := db.DeclareKeyIn[int](registry, "test", "test:counter")
+	users := db.DeclareKeyFamilyIn[string](registry, "test", "test:user:")
+
+	err := database.Do(ctx, func(tx db.Tx) error {
+		val, err := counter.GetDefault(tx, 10)
+		require.NoError(t, err)
+		require.Equal(t, 10, val)
+		_, err = counter.Get(tx)
+		require.ErrorIs(t, err, db.ErrKeyNotFound)
+
+		require.NoError(t, counter.Set(tx, 11))
+		require.NoError(t, users.Key("1").Set(tx, "first"))
+		require.NoError(t, users.Keyf("%d", 2).Set(tx, "second"))
+		require.NoError(t, users.Key("3").Set(tx, "third"))
+		require.NoError(t, users.Key("3").Delete(tx))
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = database.View(ctx, func(tx db.ReadTx) error {
+		val, err := counter.Get(tx)
+		require.NoError(t, err)
+		require.Equal(t, 11, val)
+
+		found := make(map[string]string)
+		err = users.Iterate(tx, db.IterOpts{}, func(suffix string, val string) error {
+			found[suffix] = val
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"1": "first", "2": "second"}, found)
+
+		err = users.Iterate(tx, db.PrefixOpts("test:counter"), func(string, string) error { return nil })
+		require.Error(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestKeyRegistry(t *testing.T) {
+	t.Parallel()
+
+	registry := db.NewKeyRegistry()
+	db.DeclareKeyFamilyIn[string](registry, "a", "ns:users:")
+	db.DeclareKeyIn[int](registry, "a", "ns:users:count")
+	db.DeclareKeyIn[int](registry, "a", "ns:total")
+	db.DeclareKeyIn[string](registry, "b", "ns:total")
+
+	info, ok := registry.Lookup("ns:users:count")
+	require.True(t, ok)
+	require.Equal(t, "int", info.Type)
+	info, ok = registry.Lookup("ns:users:42")
+	require.True(t, ok)
+	require.True(t, info.Family())
+	require.Equal(t, "ns:users:*", info.Pattern)
+	_, ok = registry.Lookup("other")
+	require.False(t, ok)
+
+	pretty, err := info.Pretty([]byte(`"name"`))
+	require.NoError(t, err)
+	require.Equal(t, `"name"`, string(pretty))
+
+	require.Equal(t, []string{
+		"ns:total is declared twice: by a as int and by b as string",
+		"ns:users:count of a is inside family ns:users:* of a",
+	}, registry.Collisions())
+}
@@ -0,0 +1,257 @@
+package memdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/boar-d-white-foundation/drone/db"
+)
+
+var (
+	ErrClosed   = errors.New("memdb: closed")
+	ErrReadOnly = errors.New("memdb: read-only transaction")
+	ErrDone     = errors.New("memdb: transaction is already done")
+)
+
+type entry struct {
+	val     []byte
+	deleted bool
+	version uint64
+}
+
+// DB is an in-memory db.DB with the same transaction semantics as db.BadgerDB:
+// read-write transactions are serialized, read-only transactions see a snapshot,
+// a transaction is rolled back if f returns an error, and a commit fails with db.ErrConflict
+// if a key read by the transaction was changed by a transaction committed after it started
+type DB struct {
+	mu sync.Mutex // serializes Do
+
+	stateMu sync.RWMutex
+	closed  bool
+	version uint64
+	// committed state, replaced on commit and never mutated in place, so transactions can share it
+	data map[string]entry
+}
+
+type Tx struct {
+	db       *DB
+	readOnly bool
+	done     bool
+	readTs   uint64
+	snapshot map[string]entry
+	writes   map[string]entry
+	reads    map[string]struct{}
+}
+
+var _ db.DB = (*DB)(nil)
+var _ db.Tx = (*Tx)(nil)
+
+func New() *DB {
+	return &DB{
+		data: make(map[string]entry),
+	}
+}
+
+func (d *DB) Start(ctx context.Context) error {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+
+	d.closed = false
+	return nil
+}
+
+func (d *DB) Stop() {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+
+	d.closed = true
+}
+
+// Begin starts a transaction, it must be finished with either Commit or Discard
+func (d *DB) Begin(update bool) (*Tx, error) {
+	d.stateMu.RLock()
+	defer d.stateMu.RUnlock()
+
+	if d.closed {
+		return nil, ErrClosed
+	}
+
+	return &Tx{
+		db:       d,
+		readOnly: !update,
+		readTs:   d.version,
+		snapshot: d.data,
+		writes:   make(map[string]entry),
+		reads:    make(map[string]struct{}),
+	}, nil
+}
+
+func (d *DB) Do(ctx context.Context, f func(db.Tx) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.Begin(true)
+	if err != nil {
+		return fmt.Errorf("memdb tx: %w", err)
+	}
+	defer tx.Discard()
+
+	if err := f(tx); err != nil {
+		return fmt.Errorf("memdb tx: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("memdb tx: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DB) View(ctx context.Context, f func(db.ReadTx) error) error {
+	tx, err := d.Begin(false)
+	if err != nil {
+		return fmt.Errorf("memdb read tx: %w", err)
+	}
+	defer tx.Discard()
+
+	if err := f(tx); err != nil {
+		return fmt.Errorf("memdb read tx: %w", err)
+	}
+
+	return nil
+}
+
+// Commit applies transaction writes, the transaction can't be used after it
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrDone
+	}
+	tx.done = true
+	if len(tx.writes) == 0 {
+		return nil
+	}
+
+	d := tx.db
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+	for key := range tx.reads {
+		if e, ok := d.data[key]; ok && e.version > tx.readTs {
+			return fmt.Errorf("key %q was changed: %w", key, db.ErrConflict)
+		}
+	}
+
+	d.version++
+	data := maps.Clone(d.data)
+	for key, e := range tx.writes {
+		e.version = d.version
+		data[key] = e
+	}
+	d.data = data
+	return nil
+}
+
+// Discard drops transaction writes, it's a noop for a committed transaction
+func (tx *Tx) Discard() {
+	tx.done = true
+}
+
+func (tx *Tx) lookup(key string) (entry, bool) {
+	if e, ok := tx.writes[key]; ok {
+		return e, !e.deleted
+	}
+
+	tx.reads[key] = struct{}{}
+	e, ok := tx.snapshot[key]
+	return e, ok && !e.deleted
+}
+
+func (tx *Tx) Get(key []byte) ([]byte, error) {
+	if tx.done {
+		return nil, ErrDone
+	}
+
+	e, ok := tx.lookup(string(key))
+	if !ok {
+		return nil, db.ErrKeyNotFound
+	}
+
+	return bytes.Clone(e.val), nil
+}
+
+func (tx *Tx) Set(key []byte, val []byte) error {
+	if tx.done {
+		return ErrDone
+	}
+	if tx.readOnly {
+		return ErrReadOnly
+	}
+
+	tx.writes[string(key)] = entry{val: bytes.Clone(val)}
+	return nil
+}
+
+func (tx *Tx) Delete(key []byte) error {
+	if tx.done {
+		return ErrDone
+	}
+	if tx.readOnly {
+		return ErrReadOnly
+	}
+
+	tx.writes[string(key)] = entry{deleted: true}
+	return nil
+}
+
+func (tx *Tx) Iterate(opts db.IterOpts, f func(key, val []byte) error) error {
+	if tx.done {
+		return ErrDone
+	}
+
+	// take the keys upfront, so f is allowed to modify the transaction
+	keys := make([]string, 0)
+	for key := range tx.snapshot {
+		if opts.Contains([]byte(key)) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range tx.writes {
+		if _, ok := tx.snapshot[key]; !ok && opts.Contains([]byte(key)) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	if opts.Reverse {
+		slices.Reverse(keys)
+	}
+
+	type kv struct {
+		key string
+		val []byte
+	}
+	items := make([]kv, 0, len(keys))
+	for _, key := range keys {
+		if e, ok := tx.lookup(key); ok {
+			items = append(items, kv{key: key, val: e.val})
+		}
+	}
+
+	for _, item := range items {
+		err := f([]byte(item.key), bytes.Clone(item.val))
+		if errors.Is(err, db.ErrStopIteration) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
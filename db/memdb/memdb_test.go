@@ -0,0 +1,105 @@
+package memdb_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/dbtest"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemDB(t *testing.T) {
+	t.Parallel()
+
+	dbtest.Run(t, func() db.DB {
+		return memdb.New()
+	})
+}
+
+func TestConflict(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	tx1, err := database.Begin(true)
+	require.NoError(t, err)
+	tx2, err := database.Begin(true)
+	require.NoError(t, err)
+
+	_, err = db.GetJsonDefault(tx1, "key", 0)
+	require.NoError(t, err)
+	_, err = db.GetJsonDefault(tx2, "key", 0)
+	require.NoError(t, err)
+
+	err = db.SetJson(tx1, "key", 1)
+	require.NoError(t, err)
+	err = db.SetJson(tx2, "key", 2)
+	require.NoError(t, err)
+
+	require.NoError(t, tx1.Commit())
+	require.ErrorIs(t, tx2.Commit(), db.ErrConflict)
+
+	// blind writes and writes to unrelated keys don't conflict
+	tx3, err := database.Begin(true)
+	require.NoError(t, err)
+	tx4, err := database.Begin(true)
+	require.NoError(t, err)
+
+	err = db.SetJson(tx3, "key", 3)
+	require.NoError(t, err)
+	_, err = db.GetJsonDefault(tx4, "other", 0)
+	require.NoError(t, err)
+	err = db.SetJson(tx4, "other", 4)
+	require.NoError(t, err)
+
+	require.NoError(t, tx3.Commit())
+	require.NoError(t, tx4.Commit())
+
+	err = database.View(ctx, func(tx db.ReadTx) error {
+		val, err := db.GetJson[int](tx, "key")
+		require.NoError(t, err)
+		require.Equal(t, 3, val)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// deletes are detected as well
+	tx5, err := database.Begin(true)
+	require.NoError(t, err)
+	_, err = db.GetJson[int](tx5, "other")
+	require.NoError(t, err)
+	err = database.Do(ctx, func(tx db.Tx) error {
+		return tx.Delete([]byte("other"))
+	})
+	require.NoError(t, err)
+	err = db.SetJson(tx5, "other", 5)
+	require.NoError(t, err)
+	require.ErrorIs(t, tx5.Commit(), db.ErrConflict)
+}
+
+func TestReadOnly(t *testing.T) {
+	t.Parallel()
+
+	database := memdb.New()
+	err := database.Start(context.Background())
+	require.NoError(t, err)
+
+	tx, err := database.Begin(false)
+	require.NoError(t, err)
+	defer tx.Discard()
+
+	require.ErrorIs(t, tx.Set([]byte("key"), []byte("1")), memdb.ErrReadOnly)
+	require.ErrorIs(t, tx.Delete([]byte("key")), memdb.ErrReadOnly)
+
+	database.Stop()
+	err = database.Do(context.Background(), func(tx db.Tx) error {
+		return nil
+	})
+	require.ErrorIs(t, err, memdb.ErrClosed)
+}
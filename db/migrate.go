@@ -0,0 +1,256 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// AppliedMigrationsKey is the key MigrateJson stores applied migrations under in drone
+const AppliedMigrationsKey = "drone:applied_migrations"
+
+var (
+	ErrUnknownMigration      = errors.New("db: applied migration is missing in code")
+	ErrIrreversibleMigration = errors.New("db: migration can't be reverted")
+)
+
+type appliedMigration struct {
+	ID        string    `json:"id"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+type appliedMigrations struct {
+	Applied []appliedMigration `json:"applied"`
+}
+
+// drone key is declared for the schema, MigrateJson may store applied migrations under any key
+var _ = DeclareKey[appliedMigrations]("db", AppliedMigrationsKey)
+
+type Migration struct {
+	ID   string
+	Name string
+	Fn   func(tx Tx) error
+	// Down reverts Fn, a migration without Down can't be reverted
+	Down func(tx Tx) error
+}
+
+type MigrationStatus struct {
+	ID         string
+	Name       string
+	Applied    bool
+	AppliedAt  time.Time
+	Reversible bool
+	// Unknown is set for an applied migration missing in code
+	Unknown bool
+}
+
+// MigrationsStatus returns statuses of migrations in code order followed by unknown applied migrations
+func MigrationsStatus(
+	ctx context.Context,
+	db DB,
+	appliedKey string,
+	migrations []Migration,
+) ([]MigrationStatus, error) {
+	var result []MigrationStatus
+	err := db.View(ctx, func(tx ReadTx) error {
+		applied, err := GetJsonDefault(tx, appliedKey, appliedMigrations{})
+		if err != nil {
+			return fmt.Errorf("get %q: %w", appliedKey, err)
+		}
+
+		appliedAt := make(map[string]time.Time, len(applied.Applied))
+		for _, mgr := range applied.Applied {
+			appliedAt[mgr.ID] = mgr.AppliedAt
+		}
+
+		result = make([]MigrationStatus, 0, len(migrations))
+		for _, mgr := range migrations {
+			at, ok := appliedAt[mgr.ID]
+			result = append(result, MigrationStatus{
+				ID:         mgr.ID,
+				Name:       mgr.Name,
+				Applied:    ok,
+				AppliedAt:  at,
+				Reversible: mgr.Down != nil,
+			})
+		}
+		for _, mgr := range unknownMigrations(applied, migrations) {
+			result = append(result, MigrationStatus{
+				ID:        mgr.ID,
+				Applied:   true,
+				AppliedAt: mgr.AppliedAt,
+				Unknown:   true,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("migrations status: %w", err)
+	}
+
+	return result, nil
+}
+
+func unknownMigrations(applied appliedMigrations, migrations []Migration) []appliedMigration {
+	result := make([]appliedMigration, 0)
+	for _, mgr := range applied.Applied {
+		known := slices.ContainsFunc(migrations, func(m Migration) bool {
+			return m.ID == mgr.ID
+		})
+		if !known {
+			result = append(result, mgr)
+		}
+	}
+
+	return result
+}
+
+func checkUnknownMigrations(applied appliedMigrations, migrations []Migration) error {
+	unknown := unknownMigrations(applied, migrations)
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(unknown))
+	for _, mgr := range unknown {
+		ids = append(ids, mgr.ID)
+	}
+	return fmt.Errorf("%w: %v", ErrUnknownMigration, ids)
+}
+
+// migrationIdx returns an index of the migration with the id, -1 for an empty id
+func migrationIdx(migrations []Migration, id string) (int, error) {
+	if id == "" {
+		return -1, nil
+	}
+
+	idx := slices.IndexFunc(migrations, func(m Migration) bool {
+		return m.ID == id
+	})
+	if idx == -1 {
+		return 0, fmt.Errorf("unknown migration %q", id)
+	}
+
+	return idx, nil
+}
+
+// MigrateJson applies all not applied migrations in one transaction
+func MigrateJson(ctx context.Context, db DB, appliedKey string, migrations []Migration) error {
+	return MigrateUp(ctx, db, appliedKey, migrations, "")
+}
+
+// MigrateUp applies not applied migrations up to and including the one with id to,
+// empty to means all migrations
+func MigrateUp(ctx context.Context, db DB, appliedKey string, migrations []Migration, to string) error {
+	toIdx, err := migrationIdx(migrations, to)
+	if err != nil {
+		return err
+	}
+	if to == "" {
+		toIdx = len(migrations) - 1
+	}
+
+	slog.Info("applying migrations", slog.String("to", to))
+	err = db.Do(ctx, func(tx Tx) error {
+		applied, err := GetJsonDefault(tx, appliedKey, appliedMigrations{})
+		if err != nil {
+			return fmt.Errorf("get %q: %w", appliedKey, err)
+		}
+		if err := checkUnknownMigrations(applied, migrations); err != nil {
+			return err
+		}
+
+		appliedIDs := make(map[string]struct{}, len(applied.Applied))
+		for _, mgr := range applied.Applied {
+			appliedIDs[mgr.ID] = struct{}{}
+		}
+
+		for _, mgr := range migrations[:toIdx+1] {
+			if _, ok := appliedIDs[mgr.ID]; ok {
+				slog.Info(
+					"migration already applied, skipping",
+					slog.String("id", mgr.ID), slog.String("name", mgr.Name),
+				)
+				continue
+			}
+
+			slog.Info("applying migration", slog.String("id", mgr.ID), slog.String("name", mgr.Name))
+			if err := mgr.Fn(tx); err != nil {
+				return fmt.Errorf("apply migration %s: %w", mgr.Name, err)
+			}
+
+			applied.Applied = append(applied.Applied, appliedMigration{
+				ID:        mgr.ID,
+				AppliedAt: time.Now(),
+			})
+		}
+
+		if err := SetJson(tx, appliedKey, applied); err != nil {
+			return fmt.Errorf("set %q: %w", appliedKey, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("migrations applied")
+	return nil
+}
+
+// MigrateDown reverts applied migrations following the one with id to in reverse order in one transaction,
+// empty to means all migrations. Nothing is reverted if any of them has no Down.
+func MigrateDown(ctx context.Context, db DB, appliedKey string, migrations []Migration, to string) error {
+	toIdx, err := migrationIdx(migrations, to)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("reverting migrations", slog.String("to", to))
+	err = db.Do(ctx, func(tx Tx) error {
+		applied, err := GetJsonDefault(tx, appliedKey, appliedMigrations{})
+		if err != nil {
+			return fmt.Errorf("get %q: %w", appliedKey, err)
+		}
+		if err := checkUnknownMigrations(applied, migrations); err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i > toIdx; i-- {
+			mgr := migrations[i]
+			idx := slices.IndexFunc(applied.Applied, func(m appliedMigration) bool {
+				return m.ID == mgr.ID
+			})
+			if idx == -1 {
+				continue
+			}
+			if mgr.Down == nil {
+				return fmt.Errorf("revert migration %s: %w", mgr.Name, ErrIrreversibleMigration)
+			}
+
+			slog.Info("reverting migration", slog.String("id", mgr.ID), slog.String("name", mgr.Name))
+			if err := mgr.Down(tx); err != nil {
+				return fmt.Errorf("revert migration %s: %w", mgr.Name, err)
+			}
+
+			applied.Applied = slices.Delete(applied.Applied, idx, idx+1)
+		}
+
+		if err := SetJson(tx, appliedKey, applied); err != nil {
+			return fmt.Errorf("set %q: %w", appliedKey, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("migrations reverted")
+	return nil
+}
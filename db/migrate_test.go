@@ -0,0 +1,77 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateUpDown(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	database := memdb.New()
+	require.NoError(t, database.Start(ctx))
+	defer database.Stop()
+
+	const key = "migrations"
+	setter := func(k string) func(tx db.Tx) error {
+		return func(tx db.Tx) error { return db.SetJson(tx, k, true) }
+	}
+	deleter := func(k string) func(tx db.Tx) error {
+		return func(tx db.Tx) error { return tx.Delete([]byte(k)) }
+	}
+	migrations := []db.Migration{
+		{ID: "0001", Name: "first", Fn: setter("a")},
+		{ID: "0002", Name: "second", Fn: setter("b"), Down: deleter("b")},
+		{ID: "0003", Name: "third", Fn: setter("c"), Down: deleter("c")},
+	}
+	keys := func() []string {
+		result := make([]string, 0)
+		err := database.View(ctx, func(tx db.ReadTx) error {
+			return tx.Iterate(db.IterOpts{}, func(k, _ []byte) error {
+				if string(k) != key {
+					result = append(result, string(k))
+				}
+				return nil
+			})
+		})
+		require.NoError(t, err)
+		return result
+	}
+	applied := func() []bool {
+		statuses, err := db.MigrationsStatus(ctx, database, key, migrations)
+		require.NoError(t, err)
+		result := make([]bool, 0, len(statuses))
+		for _, status := range statuses {
+			result = append(result, status.Applied)
+		}
+		return result
+	}
+
+	require.NoError(t, db.MigrateUp(ctx, database, key, migrations, "0002"))
+	require.Equal(t, []string{"a", "b"}, keys())
+	require.Equal(t, []bool{true, true, false}, applied())
+
+	require.NoError(t, db.MigrateJson(ctx, database, key, migrations))
+	require.Equal(t, []string{"a", "b", "c"}, keys())
+
+	require.NoError(t, db.MigrateDown(ctx, database, key, migrations, "0001"))
+	require.Equal(t, []string{"a"}, keys())
+	require.Equal(t, []bool{true, false, false}, applied())
+
+	err := db.MigrateDown(ctx, database, key, migrations, "")
+	require.ErrorIs(t, err, db.ErrIrreversibleMigration)
+	require.Equal(t, []bool{true, false, false}, applied())
+
+	err = db.MigrateJson(ctx, database, key, migrations[1:])
+	require.ErrorIs(t, err, db.ErrUnknownMigration)
+	statuses, err := db.MigrationsStatus(ctx, database, key, migrations[1:])
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+	require.True(t, statuses[2].Unknown)
+	require.Equal(t, "0001", statuses[2].ID)
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// maxReportedUnknownKeys limits the report size, unknown keys are usually of the same kind
+const maxReportedUnknownKeys = 50
+
+// Schema describes known keys, so stored values can be checked to still decode into their types
+// and to satisfy invariants between keys after refactors
+type Schema struct {
+	keys       *KeyRegistry
+	invariants []schemaInvariant
+}
+
+type schemaInvariant struct {
+	owner string
+	name  string
+	check Invariant
+}
+
+// Invariant returns human-readable violations found in the db
+type Invariant func(tx ReadTx) ([]string, error)
+
+func NewSchema() *Schema {
+	return &Schema{
+		keys: NewKeyRegistry(),
+	}
+}
+
+// RegisterJson registers a json value of type T under the key pattern,
+// the pattern is either an exact key or a prefix ending with '*', the longest matching pattern is used
+func RegisterJson[T any](s *Schema, owner, pattern string) {
+	declare[T](s.keys, owner, pattern)
+}
+
+// AddKeys registers all keys declared in the registry
+func (s *Schema) AddKeys(r *KeyRegistry) {
+	infos := r.Keys()
+
+	s.keys.mu.Lock()
+	defer s.keys.mu.Unlock()
+	s.keys.infos = append(s.keys.infos, infos...)
+}
+
+func (s *Schema) AddInvariant(owner, name string, check Invariant) {
+	s.invariants = append(s.invariants, schemaInvariant{
+		owner: owner,
+		name:  name,
+		check: check,
+	})
+}
+
+type CheckProblem struct {
+	Owner string
+	// Source is either a key pattern or an invariant name
+	Source string
+	Key    string
+	Msg    string
+}
+
+type CheckReport struct {
+	Keys     int
+	Problems []CheckProblem
+	// Unknown holds first keys not matching any pattern, UnknownCount is their total count
+	Unknown      []string
+	UnknownCount int
+}
+
+func (r CheckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r CheckReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "checked %d keys: %d problems, %d unknown keys\n", r.Keys, len(r.Problems), r.UnknownCount)
+	if len(r.Problems) > 0 {
+		sb.WriteString("problems:\n")
+	}
+	for _, p := range r.Problems {
+		fmt.Fprintf(&sb, "  [%s] %s", p.Owner, p.Source)
+		if p.Key != "" {
+			fmt.Fprintf(&sb, " %s", p.Key)
+		}
+		fmt.Fprintf(&sb, ": %s\n", p.Msg)
+	}
+	if len(r.Unknown) > 0 {
+		sb.WriteString("unknown keys:\n")
+	}
+	for _, key := range r.Unknown {
+		fmt.Fprintf(&sb, "  %s\n", key)
+	}
+	if r.UnknownCount > len(r.Unknown) {
+		fmt.Fprintf(&sb, "  ... and %d more\n", r.UnknownCount-len(r.Unknown))
+	}
+
+	return sb.String()
+}
+
+// Check decodes every key with its registered type and runs invariants on a snapshot of the db
+func (s *Schema) Check(ctx context.Context, db DB) (CheckReport, error) {
+	var report CheckReport
+	err := db.View(ctx, func(tx ReadTx) error {
+		report = CheckReport{}
+		err := tx.Iterate(IterOpts{}, func(key, val []byte) error {
+			report.Keys++
+			info, ok := s.keys.Lookup(string(key))
+			if !ok {
+				report.UnknownCount++
+				if len(report.Unknown) < maxReportedUnknownKeys {
+					report.Unknown = append(report.Unknown, string(key))
+				}
+				return nil
+			}
+
+			if _, err := info.Decode(val); err != nil {
+				report.Problems = append(report.Problems, CheckProblem{
+					Owner:  info.Owner,
+					Source: info.Pattern,
+					Key:    string(key),
+					Msg:    fmt.Sprintf("decode: %s", err),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("iterate keys: %w", err)
+		}
+
+		for _, inv := range s.invariants {
+			violations, err := inv.check(tx)
+			if err != nil {
+				return fmt.Errorf("check invariant %s: %w", inv.name, err)
+			}
+
+			for _, msg := range violations {
+				report.Problems = append(report.Problems, CheckProblem{
+					Owner:  inv.owner,
+					Source: inv.name,
+					Msg:    msg,
+				})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return CheckReport{}, fmt.Errorf("check db: %w", err)
+	}
+
+	slog.Info(
+		"checked db",
+		slog.Int("keys", report.Keys),
+		slog.Int("problems", len(report.Problems)),
+		slog.Int("unknown", report.UnknownCount),
+	)
+	return report, nil
+}
@@ -0,0 +1,59 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaCheck(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	database := memdb.New()
+	require.NoError(t, database.Start(ctx))
+	defer database.Stop()
+
+	type item struct {
+		Name string `json:"name"`
+	}
+	err := database.Do(ctx, func(tx db.Tx) error {
+		require.NoError(t, db.SetJson(tx, "items:count", 2))
+		require.NoError(t, db.SetJson(tx, "items:1", item{Name: "first"}))
+		require.NoError(t, db.SetJson(tx, "items:2", "second"))
+		require.NoError(t, db.SetJson(tx, "other", true))
+		return nil
+	})
+	require.NoError(t, err)
+
+	schema := db.NewSchema()
+	db.RegisterJson[item](schema, "test", "items:*")
+	// exact key takes precedence over the prefix
+	db.RegisterJson[int](schema, "test", "items:count")
+	schema.AddInvariant("test", "items count", func(tx db.ReadTx) ([]string, error) {
+		count, err := db.GetJson[int](tx, "items:count")
+		if err != nil {
+			return nil, err
+		}
+		if count != 3 {
+			return []string{fmt.Sprintf("count is %d", count)}, nil
+		}
+		return nil, nil
+	})
+
+	report, err := schema.Check(ctx, database)
+	require.NoError(t, err)
+	require.False(t, report.OK())
+	require.Equal(t, 4, report.Keys)
+	require.Equal(t, []string{"other"}, report.Unknown)
+	require.Len(t, report.Problems, 2)
+	require.Equal(t, "items:2", report.Problems[0].Key)
+	require.Equal(t, "items:*", report.Problems[0].Source)
+	require.Equal(t, "items count", report.Problems[1].Source)
+	require.Equal(t, "count is 2", report.Problems[1].Msg)
+	require.Contains(t, report.String(), "2 problems, 1 unknown keys")
+}
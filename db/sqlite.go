@@ -0,0 +1,281 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/boar-d-white-foundation/drone/config"
+	_ "modernc.org/sqlite" // registers "sqlite" driver
+)
+
+// sqliteIterBatch is the number of rows fetched at once while iterating,
+// rows are fetched in batches to not hold the whole result set in memory
+const sqliteIterBatch = 256
+
+type SQLiteDB struct {
+	mu   sync.Mutex
+	path string
+	sdb  *sql.DB
+}
+
+type SQLiteTx struct {
+	ctx context.Context
+	stx *sql.Tx
+}
+
+var _ DB = (*SQLiteDB)(nil)
+var _ Tx = (*SQLiteTx)(nil)
+var _ ReadTx = (*SQLiteTx)(nil)
+
+func NewSQLiteDB(path string) *SQLiteDB {
+	return &SQLiteDB{
+		path: path,
+	}
+}
+
+func NewSQLiteDBFromConfig(cfg config.Config) DB {
+	return NewSQLiteDB(cfg.Storage.SQLitePath)
+}
+
+func NewDBFromConfig(cfg config.Config) (DB, error) {
+	switch cfg.Storage.Driver {
+	case config.StorageDriverBadger:
+		return NewBadgerDBFromConfig(cfg), nil
+	case config.StorageDriverSQLite:
+		return NewSQLiteDBFromConfig(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Storage.Driver)
+	}
+}
+
+func (s *SQLiteDB) Start(ctx context.Context) error {
+	dsn := "file:" + s.path +
+		"?_txlock=immediate&_pragma=journal_mode(WAL)&_pragma=synchronous(FULL)&_pragma=busy_timeout(5000)"
+	if s.path == ":memory:" {
+		dsn = ":memory:"
+	}
+
+	sdb, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return fmt.Errorf("open sqlite: %w", err)
+	}
+	if s.path == ":memory:" {
+		// every connection gets its own in-memory database, so keep just one,
+		// note that View waits for Do in such case
+		sdb.SetMaxOpenConns(1)
+	}
+
+	_, err = sdb.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS kv (
+		key BLOB NOT NULL PRIMARY KEY,
+		val BLOB NOT NULL
+	) WITHOUT ROWID`)
+	if err != nil {
+		if err := sdb.Close(); err != nil {
+			slog.Error("failed to close sqlite", slog.Any("err", err))
+		}
+		return fmt.Errorf("create kv table: %w", err)
+	}
+
+	s.sdb = sdb
+	return nil
+}
+
+func (s *SQLiteDB) Stop() {
+	if err := s.sdb.Close(); err != nil {
+		slog.Error("failed to close sqlite", slog.Any("err", err))
+	}
+}
+
+func (s *SQLiteDB) Do(ctx context.Context, f func(Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.runTx(ctx, false, func(tx *SQLiteTx) error { return f(tx) }); err != nil {
+		return fmt.Errorf("sqlite tx: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteDB) View(ctx context.Context, f func(ReadTx) error) error {
+	// in WAL mode readers work on a snapshot and don't block the writer
+	if err := s.runTx(ctx, true, func(tx *SQLiteTx) error { return f(tx) }); err != nil {
+		return fmt.Errorf("sqlite read tx: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteDB) runTx(ctx context.Context, readOnly bool, f func(*SQLiteTx) error) error {
+	stx, err := s.sdb.BeginTx(ctx, &sql.TxOptions{ReadOnly: readOnly})
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+
+	if err := f(&SQLiteTx{ctx: ctx, stx: stx}); err != nil {
+		if err := stx.Rollback(); err != nil {
+			slog.Error("failed to rollback sqlite tx", slog.Any("err", err))
+		}
+		return err
+	}
+
+	if err := stx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	return nil
+}
+
+func (tx *SQLiteTx) Get(key []byte) ([]byte, error) {
+	var val []byte
+	err := tx.stx.QueryRowContext(tx.ctx, "SELECT val FROM kv WHERE key = ?", key).Scan(&val)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get sqlite key %q: %w", key, err)
+	}
+
+	return val, nil
+}
+
+func (tx *SQLiteTx) Set(key []byte, val []byte) error {
+	_, err := tx.stx.ExecContext(
+		tx.ctx,
+		"INSERT INTO kv (key, val) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET val = excluded.val",
+		key, val,
+	)
+	if err != nil {
+		return fmt.Errorf("set sqlite key %q: %w", key, err)
+	}
+
+	return nil
+}
+
+func (tx *SQLiteTx) Delete(key []byte) error {
+	_, err := tx.stx.ExecContext(tx.ctx, "DELETE FROM kv WHERE key = ?", key)
+	if err != nil {
+		return fmt.Errorf("delete sqlite key %q: %w", key, err)
+	}
+
+	return nil
+}
+
+func (tx *SQLiteTx) Iterate(opts IterOpts, f func(key, val []byte) error) error {
+	lower, upper := sqliteBounds(opts)
+	lowerInclusive := true
+	for {
+		kvs, err := tx.fetchBatch(lower, lowerInclusive, upper, opts.Reverse)
+		if err != nil {
+			return err
+		}
+
+		for _, kv := range kvs {
+			if !opts.Contains(kv.Key) {
+				continue
+			}
+
+			err := f(kv.Key, kv.Val)
+			if errors.Is(err, ErrStopIteration) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(kvs) < sqliteIterBatch {
+			return nil
+		}
+
+		// continue after the last fetched key
+		last := kvs[len(kvs)-1].Key
+		if opts.Reverse {
+			upper = last
+		} else {
+			lower, lowerInclusive = last, false
+		}
+	}
+}
+
+// sqliteBounds returns [lower, upper) bounds for keys matching opts, nil bound means unbounded
+func sqliteBounds(opts IterOpts) ([]byte, []byte) {
+	lower := opts.Prefix
+	if bytes.Compare(opts.From, lower) > 0 {
+		lower = opts.From
+	}
+
+	var upper []byte
+	// the smallest key greater than any key with the prefix
+	for i := len(opts.Prefix) - 1; i >= 0; i-- {
+		if opts.Prefix[i] < 0xff {
+			upper = bytes.Clone(opts.Prefix[:i+1])
+			upper[i]++
+			break
+		}
+	}
+	if len(opts.To) > 0 && (upper == nil || bytes.Compare(opts.To, upper) < 0) {
+		upper = opts.To
+	}
+
+	return lower, upper
+}
+
+func (tx *SQLiteTx) fetchBatch(lower []byte, lowerInclusive bool, upper []byte, reverse bool) ([]KV, error) {
+	conds := make([]string, 0, 2)
+	args := make([]any, 0, 3)
+	if len(lower) > 0 {
+		if lowerInclusive {
+			conds = append(conds, "key >= ?")
+		} else {
+			conds = append(conds, "key > ?")
+		}
+		args = append(args, lower)
+	}
+	if upper != nil {
+		conds = append(conds, "key < ?")
+		args = append(args, upper)
+	}
+
+	query := "SELECT key, val FROM kv"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	if reverse {
+		query += " ORDER BY key DESC"
+	} else {
+		query += " ORDER BY key ASC"
+	}
+	query += " LIMIT ?"
+	args = append(args, sqliteIterBatch)
+
+	rows, err := tx.stx.QueryContext(tx.ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query sqlite keys: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close sqlite rows", slog.Any("err", err))
+		}
+	}()
+
+	result := make([]KV, 0, sqliteIterBatch)
+	for rows.Next() {
+		var kv KV
+		if err := rows.Scan(&kv.Key, &kv.Val); err != nil {
+			return nil, fmt.Errorf("scan sqlite row: %w", err)
+		}
+		result = append(result, kv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,281 @@
+package dbq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/db"
+)
+
+var ErrTaskNotFound = errors.New("dbq: task not found")
+
+// Kinds lists task kinds in the order tasks pass them
+var Kinds = []string{kindPending, kindLease, kindDLX}
+
+type QueueInfo struct {
+	Name    string
+	Pending int
+	// Due counts pending tasks which are due now
+	Due       int
+	Leased    int
+	DLX       int
+	NextDueAt time.Time
+}
+
+type TaskInfo struct {
+	Key  string
+	Kind string
+	ID   uint64
+	// DueAt is the time the task is due for pending tasks
+	DueAt          time.Time
+	TTL            int
+	Attempts       int
+	LastError      string
+	NextAttemptAt  time.Time
+	LeasedUntil    time.Time
+	IdempotencyKey string
+	Args           json.RawMessage
+	// DecodeErr is set for poisoned tasks, only Key, Kind and ID are set for them
+	DecodeErr string
+}
+
+type taskKeyInfo struct {
+	name  string
+	kind  string
+	id    uint64
+	dueAt time.Time
+}
+
+// parseTaskKey parses dbq:queue:<name>:<kind>:<id> and dbq:queue:<name>:pending:<due>:<id> keys,
+// queue names may contain ':', so keys are parsed from the end
+func parseTaskKey(key string) (taskKeyInfo, error) {
+	rest, ok := strings.CutPrefix(key, keyQueues.Prefix())
+	if !ok {
+		return taskKeyInfo{}, fmt.Errorf("invalid task key %q", key)
+	}
+
+	var result taskKeyInfo
+	idx := strings.LastIndexByte(rest, ':')
+	if idx == -1 {
+		return taskKeyInfo{}, fmt.Errorf("invalid task key %q", key)
+	}
+	id, err := strconv.ParseUint(rest[idx+1:], 10, 64)
+	if err != nil {
+		return taskKeyInfo{}, fmt.Errorf("parse task id %q: %w", key, err)
+	}
+	result.id, rest = id, rest[:idx]
+
+	for _, kind := range []string{kindLease, kindDLX} {
+		if name, ok := strings.CutSuffix(rest, ":"+kind); ok {
+			result.name, result.kind = name, kind
+			return result, nil
+		}
+	}
+
+	idx = strings.LastIndexByte(rest, ':')
+	if idx == -1 {
+		return taskKeyInfo{}, fmt.Errorf("invalid task key %q", key)
+	}
+	name, ok := strings.CutSuffix(rest[:idx], ":"+kindPending)
+	if !ok {
+		return taskKeyInfo{}, fmt.Errorf("invalid task key %q", key)
+	}
+	millis, err := strconv.ParseInt(rest[idx+1:], 10, 64)
+	if err != nil {
+		return taskKeyInfo{}, fmt.Errorf("parse due time %q: %w", key, err)
+	}
+	result.name, result.kind, result.dueAt = name, kindPending, time.UnixMilli(millis)
+	return result, nil
+}
+
+func checkKind(kind string) error {
+	if kind != "" && !slices.Contains(Kinds, kind) {
+		return fmt.Errorf("unknown task kind %q, expected one of %v", kind, Kinds)
+	}
+	return nil
+}
+
+// Queues returns queues having tasks sorted by name and keys which can't be parsed as task keys,
+// the latter are skipped, so queues can be inspected when some keys are corrupted
+func Queues(tx db.ReadTx, now time.Time) ([]QueueInfo, []string, error) {
+	queues := make(map[string]*QueueInfo)
+	invalidKeys := make([]string, 0)
+	err := tx.Iterate(db.PrefixOpts(keyQueues.Prefix()), func(key, _ []byte) error {
+		info, err := parseTaskKey(string(key))
+		if err != nil {
+			slog.Warn("skipping invalid task key", slog.String("key", string(key)), slog.Any("err", err))
+			invalidKeys = append(invalidKeys, string(key))
+			return nil
+		}
+
+		queue, ok := queues[info.name]
+		if !ok {
+			queue = &QueueInfo{Name: info.name}
+			queues[info.name] = queue
+		}
+		switch info.kind {
+		case kindPending:
+			queue.Pending++
+			if !info.dueAt.After(now) {
+				queue.Due++
+			}
+			queue.NextDueAt = earliest(queue.NextDueAt, info.dueAt)
+		case kindLease:
+			queue.Leased++
+		case kindDLX:
+			queue.DLX++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("iterate queues: %w", err)
+	}
+
+	result := make([]QueueInfo, 0, len(queues))
+	for _, queue := range queues {
+		result = append(result, *queue)
+	}
+	slices.SortFunc(result, func(a, b QueueInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return result, invalidKeys, nil
+}
+
+// Tasks returns tasks of the queue of the kind, all kinds if kind is empty
+func Tasks(tx db.ReadTx, name, kind string) ([]TaskInfo, error) {
+	if err := checkKind(kind); err != nil {
+		return nil, err
+	}
+
+	result := make([]TaskInfo, 0)
+	for _, k := range Kinds {
+		if kind != "" && k != kind {
+			continue
+		}
+
+		err := tx.Iterate(db.PrefixOpts(taskPrefix(name, k)), func(key, val []byte) error {
+			keyInfo, err := parseTaskKey(string(key))
+			if err != nil {
+				return err
+			}
+
+			info := TaskInfo{
+				Key:  string(key),
+				Kind: keyInfo.kind,
+				ID:   keyInfo.id,
+			}
+			var task dbTask[json.RawMessage]
+			if err := json.Unmarshal(val, &task); err != nil {
+				info.DecodeErr = err.Error()
+				result = append(result, info)
+				return nil
+			}
+
+			if keyInfo.kind == kindPending {
+				info.DueAt = keyInfo.dueAt
+			}
+			info.TTL = task.TTL
+			info.Attempts = task.Attempts
+			info.LastError = task.LastError
+			info.NextAttemptAt = task.NextAttemptAt
+			info.LeasedUntil = task.LeasedUntil
+			info.IdempotencyKey = task.IdempotencyKey
+			info.Args = task.Args
+			result = append(result, info)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("iterate %s %s tasks: %w", name, k, err)
+		}
+	}
+
+	return result, nil
+}
+
+func findTask(tx db.ReadTx, name string, id uint64) (TaskInfo, error) {
+	tasks, err := Tasks(tx, name, "")
+	if err != nil {
+		return TaskInfo{}, err
+	}
+
+	idx := slices.IndexFunc(tasks, func(task TaskInfo) bool {
+		return task.ID == id
+	})
+	if idx == -1 {
+		return TaskInfo{}, fmt.Errorf("%w: %s %d", ErrTaskNotFound, name, id)
+	}
+
+	return tasks[idx], nil
+}
+
+// Requeue moves a task from dlx to pending giving it one more attempt
+func Requeue(tx db.Tx, name string, id uint64, now time.Time) error {
+	info, err := findTask(tx, name, id)
+	if err != nil {
+		return err
+	}
+	if info.Kind != kindDLX {
+		return fmt.Errorf("task %s %d is %s, only dlx tasks can be requeued", name, id, info.Kind)
+	}
+
+	if info.DecodeErr != "" {
+		// a poisoned task is requeued as is, its handler must be able to decode it by now
+		val, err := tx.Get([]byte(info.Key))
+		if err != nil {
+			return fmt.Errorf("get %q: %w", info.Key, err)
+		}
+		if err := tx.Set([]byte(pendingTaskKey[json.RawMessage](name, now, id).String()), val); err != nil {
+			return fmt.Errorf("set pending task %s %d: %w", name, id, err)
+		}
+		return tx.Delete([]byte(info.Key))
+	}
+
+	key := db.NewKey[dbTask[json.RawMessage]](info.Key)
+	task, err := key.Get(tx)
+	if err != nil {
+		return err
+	}
+	task.TTL = 1
+	task.Attempts = 0
+	task.NextAttemptAt = time.Time{}
+	task.LeasedUntil = time.Time{}
+
+	if err := pendingTaskKey[json.RawMessage](name, now, id).Set(tx, task); err != nil {
+		return err
+	}
+	return key.Delete(tx)
+}
+
+// DeleteTask deletes a task of any kind, a deleted leased task finishes its execution, but its result is dropped
+func DeleteTask(tx db.Tx, name string, id uint64) error {
+	info, err := findTask(tx, name, id)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Delete([]byte(info.Key)); err != nil {
+		return fmt.Errorf("delete %q: %w", info.Key, err)
+	}
+	return nil
+}
+
+// Purge deletes tasks of the queue of the kind, all kinds if kind is empty, and returns their count
+func Purge(tx db.Tx, name, kind string) (int, error) {
+	tasks, err := Tasks(tx, name, kind)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, task := range tasks {
+		if err := tx.Delete([]byte(task.Key)); err != nil {
+			return 0, fmt.Errorf("delete %q: %w", task.Key, err)
+		}
+	}
+	return len(tasks), nil
+}
@@ -0,0 +1,110 @@
+package dbq_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/boar-d-white-foundation/drone/dbq"
+	"github.com/boar-d-white-foundation/drone/tg/tgtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmin(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	registry := dbq.NewRegistry()
+	task, err := dbq.RegisterHandler(registry, "admin:task", func(ctx context.Context, i int) error {
+		return nil
+	}, dbq.HandlerOpts{})
+	require.NoError(t, err)
+	_, err = dbq.NewQueue(registry, database, alert.NewManager(tgtest.NewClient(42)), 1, time.Second)
+	require.NoError(t, err)
+
+	now := time.Now()
+	err = database.Do(ctx, func(tx db.Tx) error {
+		require.NoError(t, task.Schedule(tx, 1, 1))
+		require.NoError(t, task.ScheduleAfter(tx, time.Hour, 1, 2))
+
+		dead, err := json.Marshal(map[string]any{"id": 3, "name": "admin:task", "ttl": 0, "args": 3, "attempts": 2})
+		require.NoError(t, err)
+		return tx.Set([]byte(fmt.Sprintf("dbq:queue:admin:task:dlx:%020d", 3)), dead)
+	})
+	require.NoError(t, err)
+
+	err = database.View(ctx, func(tx db.ReadTx) error {
+		queues, invalidKeys, err := dbq.Queues(tx, time.Now().Add(time.Second))
+		require.NoError(t, err)
+		require.Empty(t, invalidKeys)
+		require.Len(t, queues, 1)
+		require.Equal(t, "admin:task", queues[0].Name)
+		require.Equal(t, 2, queues[0].Pending)
+		require.Equal(t, 1, queues[0].Due)
+		require.Equal(t, 1, queues[0].DLX)
+
+		tasks, err := dbq.Tasks(tx, "admin:task", "")
+		require.NoError(t, err)
+		require.Len(t, tasks, 3)
+		require.Equal(t, uint64(1), tasks[0].ID)
+		require.Equal(t, "dlx", tasks[2].Kind)
+		require.JSONEq(t, "3", string(tasks[2].Args))
+
+		_, err = dbq.Tasks(tx, "admin:task", "unknown")
+		require.Error(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = database.Do(ctx, func(tx db.Tx) error {
+		require.Error(t, dbq.Requeue(tx, "admin:task", 1, now))
+		require.NoError(t, dbq.Requeue(tx, "admin:task", 3, now))
+		require.NoError(t, dbq.DeleteTask(tx, "admin:task", 2))
+		require.ErrorIs(t, dbq.DeleteTask(tx, "admin:task", 2), dbq.ErrTaskNotFound)
+
+		tasks, err := dbq.Tasks(tx, "admin:task", "pending")
+		require.NoError(t, err)
+		require.Len(t, tasks, 2)
+		require.Equal(t, uint64(3), tasks[1].ID)
+		require.Equal(t, 1, tasks[1].TTL)
+		require.Equal(t, 0, tasks[1].Attempts)
+		return nil
+	})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	err = dbq.RunCommand(ctx, database, &out, []string{"purge", "admin:task"})
+	require.NoError(t, err)
+	require.Equal(t, "purged 2 tasks of admin:task\n", out.String())
+
+	out.Reset()
+	err = dbq.RunCommand(ctx, database, &out, []string{"queues"})
+	require.NoError(t, err)
+	require.Equal(t, "QUEUE  PENDING  DUE  LEASED  DLX  NEXT DUE\n", out.String())
+
+	// corrupted keys don't break the listing
+	err = database.Do(ctx, func(tx db.Tx) error {
+		require.NoError(t, task.Schedule(tx, 1, 4))
+		return tx.Set([]byte("dbq:queue:admin:task:broken"), []byte("{}"))
+	})
+	require.NoError(t, err)
+	out.Reset()
+	err = dbq.RunCommand(ctx, database, &out, []string{"queues"})
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "admin:task")
+	require.Contains(t, out.String(), "invalid task keys: 1\n  dbq:queue:admin:task:broken\n")
+
+	err = dbq.RunCommand(ctx, database, &out, []string{"requeue", "admin:task"})
+	require.Error(t, err)
+}
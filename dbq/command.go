@@ -0,0 +1,199 @@
+package dbq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/db"
+)
+
+const CommandUsage = `commands:
+  queues                       list queues with their depth
+  tasks <queue> [kind]         print tasks with args, kind is pending, lease or dlx, all by default
+  requeue <queue> <id>         move a dlx task back to pending
+  delete <queue> <id>          delete a task of any kind
+  purge <queue> [kind]         delete tasks of the kind, all by default
+  jobs                         list recurring jobs with their last runs
+  history <job>                print the last runs of a recurring job`
+
+// RunCommand runs an admin command against the db and prints the result to w,
+// it's shared by cmd/dbq and admin chat commands
+func RunCommand(ctx context.Context, database db.DB, w io.Writer, args []string) error {
+	if len(args) == 0 {
+		return errors.New(CommandUsage)
+	}
+
+	now := time.Now()
+	cmd, args := args[0], args[1:]
+	switch {
+	case cmd == "queues" && len(args) == 0:
+		var queues []QueueInfo
+		var invalidKeys []string
+		err := database.View(ctx, func(tx db.ReadTx) error {
+			var err error
+			queues, invalidKeys, err = Queues(tx, now)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return printQueues(w, queues, invalidKeys)
+	case cmd == "tasks" && (len(args) == 1 || len(args) == 2):
+		kind := ""
+		if len(args) == 2 {
+			kind = args[1]
+		}
+
+		var tasks []TaskInfo
+		err := database.View(ctx, func(tx db.ReadTx) error {
+			var err error
+			tasks, err = Tasks(tx, args[0], kind)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return printTasks(w, tasks)
+	case (cmd == "requeue" || cmd == "delete") && len(args) == 2:
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse task id %q: %w", args[1], err)
+		}
+
+		err = database.Do(ctx, func(tx db.Tx) error {
+			if cmd == "requeue" {
+				return Requeue(tx, args[0], id, now)
+			}
+			return DeleteTask(tx, args[0], id)
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s %s %d: done\n", cmd, args[0], id)
+		return err
+	case cmd == "purge" && (len(args) == 1 || len(args) == 2):
+		kind := ""
+		if len(args) == 2 {
+			kind = args[1]
+		}
+
+		var purged int
+		err := database.Do(ctx, func(tx db.Tx) error {
+			var err error
+			purged, err = Purge(tx, args[0], kind)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "purged %d tasks of %s\n", purged, args[0])
+		return err
+	case cmd == "jobs" && len(args) == 0:
+		var jobs []JobInfo
+		err := database.View(ctx, func(tx db.ReadTx) error {
+			var err error
+			jobs, err = Jobs(tx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return printJobs(w, jobs)
+	case cmd == "history" && len(args) == 1:
+		var runs []JobRun
+		err := database.View(ctx, func(tx db.ReadTx) error {
+			var err error
+			runs, err = JobHistory(tx, args[0])
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return printJobRuns(w, runs)
+	default:
+		return fmt.Errorf("invalid command %q with %d args\n%s", cmd, len(args), CommandUsage)
+	}
+}
+
+// maxPrintedInvalidKeys limits invalid keys printed with queues, all of them are logged
+const maxPrintedInvalidKeys = 5
+
+func printQueues(w io.Writer, queues []QueueInfo, invalidKeys []string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "QUEUE\tPENDING\tDUE\tLEASED\tDLX\tNEXT DUE")
+	for _, q := range queues {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\n", q.Name, q.Pending, q.Due, q.Leased, q.DLX, formatTime(q.NextDueAt))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(invalidKeys) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "invalid task keys: %d\n", len(invalidKeys))
+	for _, key := range invalidKeys[:min(len(invalidKeys), maxPrintedInvalidKeys)] {
+		if _, err := fmt.Fprintf(w, "  %s\n", key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printTasks(w io.Writer, tasks []TaskInfo) error {
+	for _, task := range tasks {
+		fmt.Fprintf(w, "%s %d\n", task.Kind, task.ID)
+		if task.DecodeErr != "" {
+			fmt.Fprintf(w, "  poisoned: %s\n", task.DecodeErr)
+			continue
+		}
+
+		fmt.Fprintf(w, "  due: %s, ttl: %d, attempts: %d\n", formatTime(task.DueAt), task.TTL, task.Attempts)
+		if !task.LeasedUntil.IsZero() {
+			fmt.Fprintf(w, "  leased until: %s\n", formatTime(task.LeasedUntil))
+		}
+		if task.IdempotencyKey != "" {
+			fmt.Fprintf(w, "  idempotency key: %s\n", task.IdempotencyKey)
+		}
+		if task.LastError != "" {
+			fmt.Fprintf(w, "  last error: %s\n", task.LastError)
+		}
+		if _, err := fmt.Fprintf(w, "  args: %s\n", task.Args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printJobs(w io.Writer, jobs []JobInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tLAST SCHEDULED\tLAST SUCCESS")
+	for _, job := range jobs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", job.Name, formatTime(job.LastScheduledAt), formatTime(job.LastSuccessAt))
+	}
+	return tw.Flush()
+}
+
+func printJobRuns(w io.Writer, runs []JobRun) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCHEDULED\tSTARTED\tDURATION\tERROR")
+	for _, run := range runs {
+		fmt.Fprintf(
+			tw, "%s\t%s\t%s\t%s\n",
+			formatTime(run.ScheduledAt), formatTime(run.StartedAt), run.FinishedAt.Sub(run.StartedAt), run.Err,
+		)
+	}
+	return tw.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,472 @@
+package dbq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/db"
+)
+
+// errInterrupted is the cause of cancelling tasks still executing after the grace period
+var errInterrupted = errors.New("interrupted by shutdown")
+
+// Interrupted reports whether ctx of a task is cancelled by shutdown,
+// such tasks are returned to pending unless their handler is NotIdempotent
+func Interrupted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errInterrupted)
+}
+
+// StartHandlers executes due tasks with q.workers workers until ctx is done, it also schedules runs of recurring jobs.
+// It sleeps until the next task or run is due, a task is scheduled or finished or pollDelay passes,
+// the latter is needed to notice tasks scheduled by other processes.
+// Once ctx is done it stops claiming tasks and waits for executing ones for q.gracePeriod,
+// tasks still executing after it are cancelled and returned to pending without spending an attempt,
+// tasks of NotIdempotent handlers are failed instead.
+func (q *Queue) StartHandlers(ctx context.Context, pollDelay time.Duration) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// tasks outlive ctx for the grace period
+	execCtx, cancelExec := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer cancelExec(nil)
+
+	taskFinished := make(chan struct{}, 1)
+	var cleanedUpAt, nextRecurringAt time.Time
+	for {
+		if time.Since(cleanedUpAt) >= dedupCleanupInterval {
+			if err := q.deleteExpiredDedups(ctx, time.Now()); err != nil {
+				slog.Error("err delete expired dedup records", slog.Any("err", err))
+			}
+			cleanedUpAt = time.Now()
+		}
+		// the first iteration catches up runs missed while the bot was down
+		if !time.Now().Before(nextRecurringAt) {
+			next, err := q.scheduleRecurring(ctx, time.Now())
+			if err != nil {
+				slog.Error("err schedule recurring jobs", slog.Any("err", err))
+			} else {
+				nextRecurringAt = next
+			}
+		}
+
+		claimed, nextDueAt, err := q.claim(ctx, q.claimOrder())
+		if err != nil {
+			slog.Error("err claim task", slog.Any("err", err))
+		}
+		if claimed != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				q.execute(execCtx, *claimed)
+				q.release(claimed.name)
+				select {
+				case taskFinished <- struct{}{}:
+				default:
+				}
+			}()
+		}
+
+		delay := pollDelay
+		switch {
+		case err != nil:
+		case claimed != nil:
+			// there may be more due tasks
+			delay = 0
+		default:
+			if next := earliest(nextDueAt, nextRecurringAt); !next.IsZero() {
+				delay = min(delay, time.Until(next))
+			}
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-q.taskEnqueued:
+			timer.Stop()
+		case <-taskFinished:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			q.drain(&wg, cancelExec)
+			return
+		}
+	}
+}
+
+// drain waits for executing tasks for the grace period and cancels them after it
+func (q *Queue) drain(wg *sync.WaitGroup, cancelExec context.CancelCauseFunc) {
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	slog.Info("draining dbq", slog.Duration("grace_period", q.gracePeriod))
+	timer := time.NewTimer(q.gracePeriod)
+	defer timer.Stop()
+	select {
+	case <-drained:
+		slog.Info("drained dbq")
+	case <-timer.C:
+		slog.Warn("dbq grace period is over, cancelling executing tasks")
+		cancelExec(errInterrupted)
+		<-drained
+	}
+}
+
+// claimOrder returns handlers which can execute a task in the order they are claimed:
+// by priority and in turn starting after the last claimed one for the same priority.
+// It returns nothing if all workers are busy.
+func (q *Queue) claimOrder() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	total := 0
+	for _, n := range q.running {
+		total += n
+	}
+	if total >= q.workers {
+		return nil
+	}
+
+	byPriority := make(map[int][]string)
+	for name, h := range q.registry.handlers {
+		if q.running[name] < h.opts.MaxConcurrency {
+			byPriority[h.opts.Priority] = append(byPriority[h.opts.Priority], name)
+		}
+	}
+	priorities := make([]int, 0, len(byPriority))
+	for priority := range byPriority {
+		priorities = append(priorities, priority)
+	}
+	slices.Sort(priorities)
+	slices.Reverse(priorities)
+
+	result := make([]string, 0, len(q.registry.handlers))
+	for _, priority := range priorities {
+		names := byPriority[priority]
+		slices.Sort(names)
+		// names sorted after the last claimed one go first
+		idx, _ := slices.BinarySearch(names, q.lastClaimed[priority]+"\x00")
+		result = append(result, names[idx:]...)
+		result = append(result, names[:idx]...)
+	}
+	return result
+}
+
+// acquire counts a claimed task as executing
+func (q *Queue) acquire(name string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.running[name]++
+	q.lastClaimed[q.registry.handlers[name].opts.Priority] = name
+}
+
+func (q *Queue) release(name string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.running[name]--
+}
+
+type claimedTask struct {
+	name string
+	task dbTask[json.RawMessage]
+}
+
+// claim leases the first due task of the first handler in a short transaction,
+// so the db isn't locked while the task is executed.
+// It also retries tasks with expired leases, they were left by a crashed or stuck execution.
+// If there is no due task it returns the time the next task or lease is due if any.
+func (q *Queue) claim(ctx context.Context, names []string) (*claimedTask, time.Time, error) {
+	if len(names) == 0 {
+		return nil, time.Time{}, nil
+	}
+
+	var claimed *claimedTask
+	var nextDueAt time.Time
+	var poisoned []error
+	err := q.database.Do(ctx, func(tx db.Tx) error {
+		claimed, nextDueAt, poisoned = nil, time.Time{}, nil
+		now := time.Now()
+		onPoisoned := func(err error) {
+			poisoned = append(poisoned, err)
+		}
+		for _, name := range names {
+			h := q.registry.handlers[name]
+			leaseDueAt, err := requeueExpiredLeases(tx, name, h, now)
+			if err != nil {
+				return err
+			}
+			nextDueAt = earliest(nextDueAt, leaseDueAt)
+
+			task, dueAt, err := leaseTask(tx, name, h, now, onPoisoned)
+			if err != nil {
+				return err
+			}
+			if task != nil {
+				claimed = &claimedTask{name: name, task: *task}
+				return nil
+			}
+			nextDueAt = earliest(nextDueAt, dueAt)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	for _, err := range poisoned {
+		q.alerts.Errorxf(err, "err decoding dbq task")
+	}
+	if claimed != nil {
+		q.acquire(claimed.name)
+	}
+
+	return claimed, nextDueAt, nil
+}
+
+// earliest returns the earliest of non-zero times
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// leaseTask moves the first due pending task to leases,
+// if there is no due task it returns the due time of the next pending task if any.
+// Pending tasks which can't be decoded are moved to dlx and reported with onPoisoned.
+func leaseTask(
+	tx db.Tx,
+	name string,
+	h registeredHandler,
+	now time.Time,
+	onPoisoned func(error),
+) (*dbTask[json.RawMessage], time.Time, error) {
+	for {
+		key, val, err := firstTask(tx, name, kindPending)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if key == "" {
+			return nil, time.Time{}, nil
+		}
+		dueAt, err := parseDueAt(name, key)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if dueAt.After(now) {
+			return nil, dueAt, nil
+		}
+		if err := tx.Delete([]byte(key)); err != nil {
+			return nil, time.Time{}, fmt.Errorf("delete %q: %w", key, err)
+		}
+
+		var task dbTask[json.RawMessage]
+		if err := json.Unmarshal(val, &task); err != nil {
+			// the pending key is already deleted, so a poisoned task doesn't block the queue
+			dlxKey, dlxErr := deadLetterPoisoned(tx, key, val)
+			if dlxErr != nil {
+				return nil, time.Time{}, dlxErr
+			}
+			slog.Error("err decoding task, moved it to dlx", slog.String("key", key), slog.Any("err", err))
+			onPoisoned(fmt.Errorf("task %q is moved to %q: %w", key, dlxKey, err))
+			continue
+		}
+
+		// attempts identify the lease, so a late ack of an expired lease doesn't touch a redelivered task
+		task.TTL--
+		task.Attempts++
+		task.LeasedUntil = now.Add(h.opts.LeaseTimeout)
+		if err := taskKey[json.RawMessage](name, kindLease, task.ID).Set(tx, task); err != nil {
+			return nil, time.Time{}, err
+		}
+
+		slog.Info("leased task", taskLogCtx(name, task)...)
+		return &task, time.Time{}, nil
+	}
+}
+
+// deadLetterPoisoned stores a task which can't be decoded in dlx as is,
+// so it can be inspected and requeued once its handler decodes it
+func deadLetterPoisoned(tx db.Tx, key string, val []byte) (string, error) {
+	info, err := parseTaskKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	dlxKey := taskKey[json.RawMessage](info.name, kindDLX, info.id).String()
+	if err := tx.Set([]byte(dlxKey), val); err != nil {
+		return "", fmt.Errorf("set %q: %w", dlxKey, err)
+	}
+	return dlxKey, nil
+}
+
+// requeueExpiredLeases fails tasks with expired leases, it returns the time the next lease expires if any
+func requeueExpiredLeases(tx db.Tx, name string, h registeredHandler, now time.Time) (time.Time, error) {
+	expired := make([]dbTask[json.RawMessage], 0)
+	var nextExpiresAt time.Time
+	err := keyQueues.Iterate(
+		tx,
+		db.PrefixOpts(taskPrefix(name, kindLease)),
+		func(_ string, task dbTask[json.RawMessage]) error {
+			if task.LeasedUntil.After(now) {
+				nextExpiresAt = earliest(nextExpiresAt, task.LeasedUntil)
+				return nil
+			}
+
+			expired = append(expired, task)
+			return nil
+		},
+	)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("iterate %s leases: %w", name, err)
+	}
+
+	for _, task := range expired {
+		if err := taskKey[json.RawMessage](name, kindLease, task.ID).Delete(tx); err != nil {
+			return time.Time{}, err
+		}
+		leaseErr := fmt.Errorf("lease expired at %s", task.LeasedUntil.Format(time.RFC3339))
+		if err := failTask(tx, name, h, task, leaseErr, now); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return nextExpiresAt, nil
+}
+
+// execute runs the task without a transaction and acks or fails it in another short one
+func (q *Queue) execute(ctx context.Context, claimed claimedTask) {
+	h := q.registry.handlers[claimed.name]
+	task := RunningTask{
+		Name:          claimed.name,
+		ID:            claimed.task.ID,
+		Attempt:       claimed.task.Attempts,
+		LastAttempt:   isLastAttempt(h, claimed.task),
+		NotIdempotent: h.opts.NotIdempotent,
+		Timeout:       h.opts.Timeout,
+		Args:          claimed.task.Args,
+	}
+	execCtx, cancel := context.WithTimeout(ctx, h.opts.LeaseTimeout)
+	execErr := q.registry.chain(h)(execCtx, task)
+	// an error of the task cancelled by shutdown is not the task failure
+	interrupted := execErr != nil && Interrupted(execCtx)
+	cancel()
+
+	// the result is stored even if ctx is done, otherwise the task would wait for its lease to expire
+	err := q.database.Do(context.WithoutCancel(ctx), func(tx db.Tx) error {
+		if interrupted && !h.opts.NotIdempotent {
+			return interruptTask(tx, claimed.name, claimed.task, time.Now())
+		}
+		if interrupted {
+			execErr = fmt.Errorf("%w: %w", errInterrupted, execErr)
+		}
+		return finishTask(tx, claimed.name, h, claimed.task, execErr, time.Now())
+	})
+	if err != nil {
+		slog.Error("err finish task", append(taskLogCtx(claimed.name, claimed.task), slog.Any("err", err))...)
+	}
+}
+
+// finishTask releases the lease of the executed task and fails it if execErr is not nil
+func finishTask(
+	tx db.Tx,
+	name string,
+	h registeredHandler,
+	task dbTask[json.RawMessage],
+	execErr error,
+	now time.Time,
+) error {
+	ok, err := releaseLease(tx, name, task)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		slog.Warn("task lease is lost, dropping the result", append(taskLogCtx(name, task), slog.Any("err", execErr))...)
+		return nil
+	}
+
+	if execErr != nil {
+		return failTask(tx, name, h, task, execErr, now)
+	}
+
+	slog.Info("finished executing task", taskLogCtx(name, task)...)
+	return nil
+}
+
+// releaseLease deletes the lease of the executed task, it returns false if the lease is expired or deleted
+func releaseLease(tx db.Tx, name string, task dbTask[json.RawMessage]) (bool, error) {
+	leaseKey := taskKey[json.RawMessage](name, kindLease, task.ID)
+	lease, err := leaseKey.Get(tx)
+	if errors.Is(err, db.ErrKeyNotFound) || (err == nil && lease.Attempts != task.Attempts) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, leaseKey.Delete(tx)
+}
+
+// interruptTask returns the task cancelled by shutdown to pending restoring its attempt
+func interruptTask(tx db.Tx, name string, task dbTask[json.RawMessage], now time.Time) error {
+	ok, err := releaseLease(tx, name, task)
+	if err != nil || !ok {
+		return err
+	}
+
+	task.TTL++
+	task.Attempts--
+	task.LeasedUntil = time.Time{}
+	slog.Warn("task is interrupted by shutdown, returning it to pending", taskLogCtx(name, task)...)
+	return pendingTaskKey[json.RawMessage](name, now, task.ID).Set(tx, task)
+}
+
+// failTask records the failure and schedules the next attempt or moves the task to dlx if no retries left
+func failTask(
+	tx db.Tx,
+	name string,
+	h registeredHandler,
+	task dbTask[json.RawMessage],
+	err error,
+	now time.Time,
+) error {
+	task.LeasedUntil = time.Time{}
+	task.LastError = err.Error()
+	task.Failures = append(task.Failures, taskFailure{At: now, Err: err.Error()})
+	if len(task.Failures) > maxFailures {
+		task.Failures = task.Failures[len(task.Failures)-maxFailures:]
+	}
+	logCtx := append(taskLogCtx(name, task), slog.Int("attempts", task.Attempts), slog.Any("err", err))
+
+	if isLastAttempt(h, task) {
+		slog.Error("err executing task, no retries left, moving to dlx", logCtx...)
+		task.NextAttemptAt = time.Time{}
+		return taskKey[json.RawMessage](name, kindDLX, task.ID).Set(tx, task)
+	}
+
+	delay, _ := h.opts.Backoff.GetDelay(task.Attempts - 1)
+
+	task.NextAttemptAt = now.Add(delay)
+	slog.Error("err executing task, retrying", append(logCtx, slog.Time("next_attempt_at", task.NextAttemptAt))...)
+	return pendingTaskKey[json.RawMessage](name, task.NextAttemptAt, task.ID).Set(tx, task)
+}
+
+// isLastAttempt reports whether a leased task is moved to dlx if it fails
+func isLastAttempt(h registeredHandler, task dbTask[json.RawMessage]) bool {
+	_, ok := h.opts.Backoff.GetDelay(task.Attempts - 1)
+	return task.TTL < 1 || !ok
+}
+
+func taskLogCtx(name string, task dbTask[json.RawMessage]) []any {
+	return []any{slog.String("name", name), slog.Uint64("id", task.ID), slog.String("args", string(task.Args))}
+}
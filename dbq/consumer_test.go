@@ -0,0 +1,216 @@
+package dbq_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/boar-d-white-foundation/drone/dbq"
+	"github.com/boar-d-white-foundation/drone/tg/tgtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueConcurrency(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	registry := dbq.NewRegistry()
+	var running, maxRunning atomic.Int32
+	release := make(chan struct{})
+	slowDone := make(chan int)
+	slow, err := dbq.RegisterHandler(registry, "test:slow", func(ctx context.Context, i int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+
+		<-release
+		slowDone <- i
+		return nil
+	}, dbq.HandlerOpts{MaxConcurrency: 2})
+	require.NoError(t, err)
+
+	fastDone := make(chan int)
+	fast, err := dbq.RegisterHandler(registry, "test:fast", func(ctx context.Context, i int) error {
+		fastDone <- i
+		return nil
+	}, dbq.HandlerOpts{})
+	require.NoError(t, err)
+
+	queue, err := dbq.NewQueue(registry, database, alert.NewManager(tgtest.NewClient(42)), 3, time.Second)
+	require.NoError(t, err)
+
+	err = database.Do(ctx, func(tx db.Tx) error {
+		for i := range 4 {
+			require.NoError(t, slow.Schedule(tx, 0, i))
+		}
+		return fast.Schedule(tx, 0, 42)
+	})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		queue.StartHandlers(ctx, time.Hour)
+		close(done)
+	}()
+
+	// slow tasks don't starve other handlers
+	require.Equal(t, 42, <-fastDone)
+	close(release)
+	for range 4 {
+		<-slowDone
+	}
+	cancel()
+	<-done
+
+	require.Equal(t, int32(2), maxRunning.Load())
+}
+
+func TestQueueOrder(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	var mu sync.Mutex
+	var executed []string
+	finished := make(chan struct{}, 10)
+	handler := func(ctx context.Context, s string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		executed = append(executed, s)
+		finished <- struct{}{}
+		return nil
+	}
+
+	registry := dbq.NewRegistry()
+	tasks := make(map[string]dbq.Task[string])
+	for name, priority := range map[string]int{"a": 0, "b": 0, "high": 1} {
+		task, err := dbq.RegisterHandler(registry, "test:"+name, handler, dbq.HandlerOpts{Priority: priority})
+		require.NoError(t, err)
+		tasks[name] = task
+	}
+
+	queue, err := dbq.NewQueue(registry, database, alert.NewManager(tgtest.NewClient(42)), 1, time.Second)
+	require.NoError(t, err)
+
+	err = database.Do(ctx, func(tx db.Tx) error {
+		// all tasks are already due when the queue starts
+		dueAt := time.Now().Add(-time.Second)
+		for _, name := range []string{"a", "a", "a", "b", "b", "high"} {
+			require.NoError(t, tasks[name].ScheduleAt(tx, dueAt, 0, name))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		queue.StartHandlers(ctx, time.Hour)
+		close(done)
+	}()
+	for range 6 {
+		<-finished
+	}
+	cancel()
+	<-done
+
+	// higher priority goes first, handlers of the same priority take turns
+	require.Equal(t, []string{"high", "a", "b", "a", "b", "a"}, executed)
+}
+
+func TestQueueDrain(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	registry := dbq.NewRegistry()
+	started := make(chan struct{}, 3)
+	finishing := make(chan struct{})
+	quick, err := dbq.RegisterHandler(registry, "test:quick", func(ctx context.Context, i int) error {
+		started <- struct{}{}
+		<-finishing
+		return ctx.Err()
+	}, dbq.HandlerOpts{})
+	require.NoError(t, err)
+	stuckHandler := func(ctx context.Context, i int) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	stuck, err := dbq.RegisterHandler(registry, "test:stuck", stuckHandler, dbq.HandlerOpts{})
+	require.NoError(t, err)
+	stuckOnce, err := dbq.RegisterHandler(registry, "test:stuck_once", stuckHandler, dbq.HandlerOpts{
+		NotIdempotent: true,
+	})
+	require.NoError(t, err)
+
+	queue, err := dbq.NewQueue(registry, database, alert.NewManager(tgtest.NewClient(42)), 3, 100*time.Millisecond)
+	require.NoError(t, err)
+
+	err = database.Do(ctx, func(tx db.Tx) error {
+		require.NoError(t, quick.Schedule(tx, 0, 1))
+		require.NoError(t, stuckOnce.Schedule(tx, 0, 3))
+		return stuck.Schedule(tx, 0, 2)
+	})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		queue.StartHandlers(ctx, time.Hour)
+		close(done)
+	}()
+	<-started
+	<-started
+	<-started
+
+	// executing tasks keep running after the queue is stopped
+	cancel()
+	close(finishing)
+	<-done
+
+	err = database.View(context.Background(), func(tx db.ReadTx) error {
+		tasks, err := dbq.Tasks(tx, "test:quick", "")
+		require.NoError(t, err)
+		require.Empty(t, tasks)
+
+		// the task cancelled after the grace period doesn't spend its only attempt
+		tasks, err = dbq.Tasks(tx, "test:stuck", "")
+		require.NoError(t, err)
+		require.Len(t, tasks, 1)
+		require.Equal(t, "pending", tasks[0].Kind)
+		require.Equal(t, 1, tasks[0].TTL)
+		require.Equal(t, 0, tasks[0].Attempts)
+
+		// the not idempotent task isn't executed again
+		tasks, err = dbq.Tasks(tx, "test:stuck_once", "")
+		require.NoError(t, err)
+		require.Len(t, tasks, 1)
+		require.Equal(t, "dlx", tasks[0].Kind)
+		require.Contains(t, tasks[0].LastError, "interrupted by shutdown")
+		return nil
+	})
+	require.NoError(t, err)
+}
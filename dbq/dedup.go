@@ -0,0 +1,83 @@
+package dbq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/db"
+)
+
+var ErrDuplicateTask = errors.New("dbq: duplicate task")
+
+const (
+	defaultDedupRetention = 24 * time.Hour
+	// dedupCleanupInterval is how often the consumer deletes expired dedup records
+	dedupCleanupInterval = time.Hour
+)
+
+// keyDedups holds dedup records as dbq:dedup:<name>:<idempotency key>
+var keyDedups = db.DeclareKeyFamily[dedupRecord](keysOwner, "dbq:dedup:")
+
+type dedupRecord struct {
+	TaskID    uint64    `json:"task_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func dedupKey(name, idempotencyKey string) db.Key[dedupRecord] {
+	return keyDedups.Keyf("%s:%s", name, idempotencyKey)
+}
+
+// dedup stores a dedup record for the task or returns ErrDuplicateTask
+// if a task with the same idempotency key was scheduled within the retention window
+func dedup(tx db.Tx, name, idempotencyKey string, id uint64, retention time.Duration, now time.Time) error {
+	key := dedupKey(name, idempotencyKey)
+	record, err := key.Get(tx)
+	switch {
+	case errors.Is(err, db.ErrKeyNotFound):
+	case err != nil:
+		return err
+	case record.ExpiresAt.After(now):
+		return fmt.Errorf("%w: %s %q is scheduled as %d", ErrDuplicateTask, name, idempotencyKey, record.TaskID)
+	}
+
+	return key.Set(tx, dedupRecord{
+		TaskID:    id,
+		ExpiresAt: now.Add(retention),
+	})
+}
+
+// deleteExpiredDedups deletes dedup records of all queues which are out of their retention window
+func (q *Queue) deleteExpiredDedups(ctx context.Context, now time.Time) error {
+	deleted := 0
+	err := q.database.Do(ctx, func(tx db.Tx) error {
+		expired := make([]string, 0)
+		err := keyDedups.Iterate(tx, db.IterOpts{}, func(suffix string, record dedupRecord) error {
+			if !record.ExpiresAt.After(now) {
+				expired = append(expired, suffix)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("iterate dedup records: %w", err)
+		}
+
+		for _, suffix := range expired {
+			if err := keyDedups.Key(suffix).Delete(tx); err != nil {
+				return err
+			}
+		}
+		deleted = len(expired)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		slog.Info("deleted expired dedup records", slog.Int("count", deleted))
+	}
+	return nil
+}
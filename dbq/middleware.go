@@ -0,0 +1,135 @@
+package dbq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+)
+
+// RunningTask describes an executed task to middleware
+type RunningTask struct {
+	Name    string
+	ID      uint64
+	Attempt int
+	// LastAttempt is set if the task is moved to dlx when this attempt fails,
+	// unless the task is Interrupted and returned to pending
+	LastAttempt bool
+	// NotIdempotent is HandlerOpts.NotIdempotent of the task handler
+	NotIdempotent bool
+	// Timeout is HandlerOpts.Timeout of the task handler
+	Timeout time.Duration
+	Args    json.RawMessage
+}
+
+func (t RunningTask) logCtx() []any {
+	return []any{slog.String("name", t.Name), slog.Uint64("id", t.ID), slog.Int("attempt", t.Attempt)}
+}
+
+// TaskFunc executes a task, it's a handler wrapped by middleware
+type TaskFunc func(ctx context.Context, task RunningTask) error
+
+// Middleware wraps execution of tasks of every handler of a registry
+type Middleware func(next TaskFunc) TaskFunc
+
+// Use adds middleware applied to all handlers of the registry, the first one added is the outermost.
+// It must be called before the queue starts.
+func (r *Registry) Use(mws ...Middleware) {
+	r.middleware = append(r.middleware, mws...)
+}
+
+func (r *Registry) chain(h handler) TaskFunc {
+	result := func(ctx context.Context, task RunningTask) error {
+		return h.do(ctx, task.Args)
+	}
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		result = r.middleware[i](result)
+	}
+	return result
+}
+
+// Logging logs the start and the result of every task with its execution time
+func Logging() Middleware {
+	return func(next TaskFunc) TaskFunc {
+		return func(ctx context.Context, task RunningTask) error {
+			slog.Info("started task", task.logCtx()...)
+			startedAt := time.Now()
+			err := next(ctx, task)
+			logCtx := append(task.logCtx(), slog.Duration("elapsed", time.Since(startedAt)))
+			if err != nil {
+				slog.Error("task failed", append(logCtx, slog.Any("err", err))...)
+				return err
+			}
+
+			slog.Info("task succeeded", logCtx...)
+			return nil
+		}
+	}
+}
+
+// Timing warns about tasks executed for longer than slow, they are about to hit their lease timeout
+func Timing(slow time.Duration) Middleware {
+	return func(next TaskFunc) TaskFunc {
+		return func(ctx context.Context, task RunningTask) error {
+			startedAt := time.Now()
+			err := next(ctx, task)
+			if elapsed := time.Since(startedAt); elapsed > slow {
+				slog.Warn("slow task", append(task.logCtx(), slog.Duration("elapsed", elapsed))...)
+			}
+			return err
+		}
+	}
+}
+
+// Recover fails a panicked task instead of crashing the queue
+func Recover() Middleware {
+	return func(next TaskFunc) TaskFunc {
+		return func(ctx context.Context, task RunningTask) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic: %+v\n%s", r, debug.Stack())
+				}
+			}()
+
+			return next(ctx, task)
+		}
+	}
+}
+
+// AlertOnFinalFailure alerts when a task fails its last attempt and is moved to dlx
+func AlertOnFinalFailure(alerts *alert.Manager) Middleware {
+	return func(next TaskFunc) TaskFunc {
+		return func(ctx context.Context, task RunningTask) error {
+			err := next(ctx, task)
+			// the interrupted task is returned to pending
+			requeued := Interrupted(ctx) && !task.NotIdempotent
+			if err != nil && task.LastAttempt && !requeued {
+				alerts.Errorxf(
+					err, "task %s %d failed after %d attempts, moved to dlx, args: %s",
+					task.Name, task.ID, task.Attempt, task.Args,
+				)
+			}
+			return err
+		}
+	}
+}
+
+// Timeout limits execution of a task by its HandlerOpts.Timeout or by d if the former is not set
+func Timeout(d time.Duration) Middleware {
+	return func(next TaskFunc) TaskFunc {
+		return func(ctx context.Context, task RunningTask) error {
+			timeout := d
+			if task.Timeout > 0 {
+				timeout = task.Timeout
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next(ctx, task)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package dbq_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/boar-d-white-foundation/drone/dbq"
+	"github.com/boar-d-white-foundation/drone/retry"
+	"github.com/boar-d-white-foundation/drone/tg/tgtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	var mu sync.Mutex
+	var calls []string
+	trace := func(name string) dbq.Middleware {
+		return func(next dbq.TaskFunc) dbq.TaskFunc {
+			return func(ctx context.Context, task dbq.RunningTask) error {
+				mu.Lock()
+				calls = append(calls, name+":"+task.Name)
+				mu.Unlock()
+				return next(ctx, task)
+			}
+		}
+	}
+
+	sender := tgtest.NewClient(42)
+	registry := dbq.NewRegistry()
+	registry.Use(
+		trace("outer"),
+		dbq.Logging(),
+		dbq.AlertOnFinalFailure(alert.NewManager(sender)),
+		dbq.Recover(),
+		dbq.Timeout(time.Hour),
+		trace("inner"),
+	)
+
+	done := make(chan error, 10)
+	panicky, err := dbq.RegisterHandler(registry, "test:panic", func(ctx context.Context, i int) error {
+		defer func() { done <- nil }()
+		panic("boom")
+	}, dbq.HandlerOpts{Backoff: retry.LinearBackoff{MaxAttempts: 2}})
+	require.NoError(t, err)
+	slow, err := dbq.RegisterHandler(registry, "test:slow", func(ctx context.Context, i int) error {
+		<-ctx.Done()
+		done <- ctx.Err()
+		return ctx.Err()
+	}, dbq.HandlerOpts{Backoff: retry.LinearBackoff{MaxAttempts: 1}, Timeout: 10 * time.Millisecond})
+	require.NoError(t, err)
+
+	queue, err := dbq.NewQueue(registry, database, alert.NewManager(tgtest.NewClient(42)), 1, time.Second)
+	require.NoError(t, err)
+
+	err = database.Do(ctx, func(tx db.Tx) error {
+		require.NoError(t, panicky.Schedule(tx, 1, 1))
+		return slow.Schedule(tx, 0, 2)
+	})
+	require.NoError(t, err)
+
+	stopped := make(chan struct{})
+	go func() {
+		queue.StartHandlers(ctx, time.Hour)
+		close(stopped)
+	}()
+	results := make([]error, 0, 3)
+	for range 3 {
+		results = append(results, <-done)
+	}
+	require.Contains(t, results, context.DeadlineExceeded)
+	require.Eventually(t, func() bool {
+		return len(sender.Alerts()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-stopped
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, calls, 6)
+	require.Equal(t, "outer:test:panic", calls[0])
+	require.Equal(t, "inner:test:panic", calls[1])
+
+	// the panicked task is alerted only after its retry
+	msgs := sender.Alerts()
+	require.Contains(t, msgs[0]+msgs[1], "task test:panic 1 failed after 2 attempts")
+	require.Contains(t, msgs[0]+msgs[1], "panic: boom")
+	require.Contains(t, msgs[0]+msgs[1], "task test:slow 1 failed after 1 attempts")
+}
+
+func TestAlertOnFinalFailureInterrupted(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	sender := tgtest.NewClient(42)
+	registry := dbq.NewRegistry()
+	registry.Use(dbq.AlertOnFinalFailure(alert.NewManager(sender)))
+
+	started := make(chan struct{}, 2)
+	stuckHandler := func(ctx context.Context, i int) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	stuck, err := dbq.RegisterHandler(registry, "test:stuck", stuckHandler, dbq.HandlerOpts{})
+	require.NoError(t, err)
+	stuckOnce, err := dbq.RegisterHandler(registry, "test:stuck_once", stuckHandler, dbq.HandlerOpts{
+		NotIdempotent: true,
+	})
+	require.NoError(t, err)
+
+	queue, err := dbq.NewQueue(registry, database, alert.NewManager(tgtest.NewClient(42)), 2, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	err = database.Do(ctx, func(tx db.Tx) error {
+		require.NoError(t, stuck.Schedule(tx, 0, 1))
+		return stuckOnce.Schedule(tx, 0, 2)
+	})
+	require.NoError(t, err)
+
+	stopped := make(chan struct{})
+	go func() {
+		queue.StartHandlers(ctx, time.Hour)
+		close(stopped)
+	}()
+	<-started
+	<-started
+	cancel()
+	<-stopped
+
+	// the task returned to pending isn't alerted, the not idempotent one is moved to dlx
+	msgs := sender.Alerts()
+	require.Len(t, msgs, 1)
+	require.Contains(t, msgs[0], "task test:stuck_once 1 failed after 1 attempts, moved to dlx")
+}
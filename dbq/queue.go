@@ -1,216 +1,296 @@
 package dbq
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/config"
 	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/retry"
 )
 
-func queueKey(name string) string {
-	return "dbq:queue:" + name
+const keysOwner = "dbq"
+
+const (
+	kindPending = "pending"
+	kindLease   = "lease"
+	kindDLX     = "dlx"
+)
+
+var (
+	// keyQueues is declared with raw args, handlers decode args with their own type
+	keyQueues = db.DeclareKeyFamily[dbTask[json.RawMessage]](keysOwner, "dbq:queue:")
+	// keySeqs holds the last task id of every queue
+	keySeqs = db.DeclareKeyFamily[uint64](keysOwner, "dbq:seq:")
+)
+
+// tasks are stored one per key as dbq:queue:<name>:<kind>:<id>, pending tasks are additionally prefixed
+// with their due time as dbq:queue:<name>:pending:<due unix millis>:<id>, numbers are zero-padded,
+// so pending tasks are iterated in the order they are due and dlx tasks in the order they were scheduled.
+// Executed tasks are kept as leases, failed tasks are retried as pending ones,
+// dlx keeps tasks which ran out of retries.
+func taskPrefix(name, kind string) string {
+	return fmt.Sprintf("%s%s:%s:", keyQueues.Prefix(), name, kind)
 }
 
-func queueDLXKey(name string) string {
-	return queueKey(name) + ":dlx"
+func taskKey[T any](name, kind string, id uint64) db.Key[dbTask[T]] {
+	return db.NewKey[dbTask[T]](fmt.Sprintf("%s%020d", taskPrefix(name, kind), id))
+}
+
+func pendingTaskKey[T any](name string, dueAt time.Time, id uint64) db.Key[dbTask[T]] {
+	// rounded up, so a task is never executed before dueAt, tasks due before the epoch are due right away
+	millis := max(dueAt.Add(time.Millisecond-time.Nanosecond).UnixMilli(), 0)
+	return db.NewKey[dbTask[T]](fmt.Sprintf("%s%020d:%020d", taskPrefix(name, kindPending), millis, id))
+}
+
+// parseDueAt returns the due time of a pending task key
+func parseDueAt(name, key string) (time.Time, error) {
+	rawDueAt, _, ok := strings.Cut(strings.TrimPrefix(key, taskPrefix(name, kindPending)), ":")
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid pending task key %q", key)
+	}
+	millis, err := strconv.ParseInt(rawDueAt, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse due time %q: %w", key, err)
+	}
+
+	return time.UnixMilli(millis), nil
+}
+
+// maxFailures limits the failure history stored in a task
+const maxFailures = 10
+
+const defaultLeaseTimeout = 5 * time.Minute
+
+var defaultBackoff = retry.ExponentialBackoff{
+	Initial:     10 * time.Second,
+	Max:         time.Hour,
+	MaxAttempts: 20,
 }
 
 type handler interface {
-	do(ctx context.Context, tx db.Tx, task any) (int, any, error)
-	getQueue(tx db.Tx, key string) ([]any, error)
-	setQueue(tx db.Tx, key string, queue []any) error
+	do(ctx context.Context, args json.RawMessage) error
+}
+
+type HandlerOpts struct {
+	// Backoff returns a delay before retrying a task after a failed attempt, attempts are counted from 0.
+	// A task is retried while both Backoff allows it and the task has retries left.
+	Backoff retry.Backoff
+	// LeaseTimeout limits a task execution, a task is retried once its lease expires
+	LeaseTimeout time.Duration
+	// Timeout is used by the Timeout middleware instead of its default, it's capped by LeaseTimeout
+	Timeout time.Duration
+	// MaxConcurrency limits tasks executed at once, 1 by default, so tasks are executed in order
+	MaxConcurrency int
+	// Priority orders handlers, tasks of higher priority handlers are executed first,
+	// handlers of the same priority are picked in turn
+	Priority int
+	// DedupRetention is how long a task idempotency key rejects duplicates, 24h by default
+	DedupRetention time.Duration
+	// NotIdempotent tasks interrupted by shutdown are failed instead of returned to pending,
+	// their handler may have done a part of its work already
+	NotIdempotent bool
+}
+
+type registeredHandler struct {
+	handler
+	opts HandlerOpts
 }
 
 type Registry struct {
-	queue    *Queue
-	handlers map[string]handler
+	queue      *Queue
+	handlers   map[string]registeredHandler
+	recurring  map[string]recurringJob
+	middleware []Middleware
 }
 
 func NewRegistry() *Registry {
 	return &Registry{
-		handlers: make(map[string]handler),
+		handlers:  make(map[string]registeredHandler),
+		recurring: make(map[string]recurringJob),
 	}
 }
 
 type Queue struct {
-	registry     *Registry
-	database     db.DB
+	registry *Registry
+	database db.DB
+	alerts   *alert.Manager
+	workers  int
+	// gracePeriod is how long in-flight tasks may run once the queue is stopped
+	gracePeriod  time.Duration
 	taskEnqueued chan struct{}
+
+	mu sync.Mutex
+	// running counts executing tasks per handler
+	running map[string]int
+	// lastClaimed holds the last claimed handler per priority to round-robin between handlers
+	lastClaimed map[int]string
 }
 
 func NewQueue(
 	registry *Registry,
 	database db.DB,
+	alerts *alert.Manager,
+	workers int,
+	gracePeriod time.Duration,
 ) (*Queue, error) {
 	if registry.queue != nil {
 		return nil, errors.New("registrty is already bound to another queue")
 	}
+	if workers < 1 {
+		return nil, fmt.Errorf("workers must be positive, got %d", workers)
+	}
+	if gracePeriod <= 0 {
+		return nil, fmt.Errorf("grace period must be positive, got %s", gracePeriod)
+	}
 
 	result := Queue{
-		registry: registry,
-		database: database,
+		registry:    registry,
+		database:    database,
+		alerts:      alerts,
+		workers:     workers,
+		gracePeriod: gracePeriod,
 		// allow burst for 25 tasks, we can miss an added task if there's another executing
 		// in such case we'll wait for pollDelay to consume it instead of consuming it immediately
 		taskEnqueued: make(chan struct{}, 25),
+		running:      make(map[string]int),
+		lastClaimed:  make(map[int]string),
 	}
 	registry.queue = &result
 	return &result, nil
 }
 
-func (q *Queue) StartHandlers(ctx context.Context, pollDelay time.Duration) {
-	for {
-		err := q.database.Do(ctx, func(tx db.Tx) error {
-			// consume just 1 task to release db lock fast
-			// pick regular tasks before dlx
-			for k, handler := range q.registry.handlers {
-				key, dlxKey := queueKey(k), queueDLXKey(k)
-				queue, err := handler.getQueue(tx, key)
-				if err != nil {
-					return err
-				}
-				dlx, err := handler.getQueue(tx, dlxKey)
-				if err != nil {
-					return err
-				}
-
-				var task any
-				var selected []any
-				var selectedKey string
-				switch {
-				case len(queue) > 0:
-					task, queue = queue[0], queue[1:]
-					selected, selectedKey = queue, key
-				case len(dlx) > 0:
-					task, dlx = dlx[0], dlx[1:]
-					selected, selectedKey = dlx, dlxKey
-				default:
-					continue
-				}
-
-				slog.Info("fetched task to execute", slog.Any("task", task))
-				if err := handler.setQueue(tx, selectedKey, selected); err != nil {
-					return err
-				}
-
-				if ttl, task, err := handler.do(ctx, tx, task); err != nil {
-					// move task to dlx to deal with it later
-					logCtx := []any{slog.Any("err", err), slog.String("key", key), slog.Any("task", task)}
-					if ttl < 1 {
-						slog.Error("err executing task, ttl is zero, stopping retrying", logCtx...)
-						break
-					}
-
-					slog.Error("err executing task, ttl is not zero, moving to dlx", logCtx...)
-					dlx = append(dlx, task)
-					if err := handler.setQueue(tx, dlxKey, dlx); err != nil {
-						return err
-					}
-					break
-				}
-
-				slog.Info("finished executing task", slog.Any("task", task))
-				break
-			}
-
-			return nil
-		})
-		if err != nil {
-			slog.Error("err consume task", slog.Any("err", err))
-			continue
-		}
-
-		select {
-		case <-time.After(pollDelay):
-		case <-q.taskEnqueued:
-		case <-ctx.Done():
-			return
-		}
-	}
-}
-
-type Handler[T any] func(context.Context, db.Tx, T) error
-
-func (h Handler[T]) do(ctx context.Context, tx db.Tx, task any) (int, any, error) {
-	casted, ok := task.(dbTask[T])
-	if !ok {
-		return 0, nil, fmt.Errorf("invalid task type %T: %+v", task, task)
-	}
-
-	casted.TTL--
-	return casted.TTL, casted, h(ctx, tx, casted.Args)
+func NewQueueFromConfig(
+	cfg config.Config,
+	registry *Registry,
+	database db.DB,
+	alerts *alert.Manager,
+) (*Queue, error) {
+	return NewQueue(registry, database, alerts, cfg.DBQ.Workers, cfg.DBQ.ShutdownGracePeriod)
 }
 
-func (h Handler[T]) getQueue(tx db.Tx, key string) ([]any, error) {
-	queue, err := db.GetJsonDefault[[]dbTask[T]](tx, key, nil)
+// firstTask returns the key and the value of the oldest task of the kind, the key is empty if there are none
+func firstTask(tx db.ReadTx, name, kind string) (string, []byte, error) {
+	var key string
+	var val []byte
+	err := tx.Iterate(db.PrefixOpts(taskPrefix(name, kind)), func(k, v []byte) error {
+		key, val = string(k), bytes.Clone(v)
+		return db.ErrStopIteration
+	})
 	if err != nil {
-		return nil, fmt.Errorf("get queue %q: %w", key, err)
+		return "", nil, fmt.Errorf("iterate %s %s tasks: %w", name, kind, err)
 	}
 
-	result := make([]any, 0, len(queue))
-	for _, task := range queue {
-		result = append(result, task)
-	}
-	return result, nil
+	return key, val, nil
 }
 
-func (h Handler[T]) setQueue(tx db.Tx, key string, queue []any) error {
-	castedQueue := make([]dbTask[T], 0, len(queue))
-	for _, task := range queue {
-		castedTask, ok := task.(dbTask[T])
-		if !ok {
-			return fmt.Errorf("invalid task type %T: %+v", task, task)
-		}
-
-		castedQueue = append(castedQueue, castedTask)
-	}
+// Handler executes a task outside of a transaction, handlers must be idempotent,
+// a task is executed again if its result can't be stored or its lease expires
+type Handler[T any] func(context.Context, T) error
 
-	if err := db.SetJson(tx, key, castedQueue); err != nil {
-		return fmt.Errorf("set queue %q: %w", key, err)
+func (h Handler[T]) do(ctx context.Context, args json.RawMessage) error {
+	var casted T
+	if err := json.Unmarshal(args, &casted); err != nil {
+		return fmt.Errorf("unmarshall args %T: %w", casted, err)
 	}
 
-	return nil
+	return h(ctx, casted)
 }
 
 type dbTask[T any] struct {
-	Name string `json:"name"`
-	TTL  int    `json:"ttl"`
-	Args T      `json:"args"`
+	ID    uint64    `json:"id"`
+	Name  string    `json:"name"`
+	TTL   int       `json:"ttl"`
+	DueAt time.Time `json:"due_at"`
+	Args  T         `json:"args"`
+	// IdempotencyKey is set for tasks scheduled with one
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// Attempts counts executions, Failures keeps the last maxFailures of them
+	Attempts      int           `json:"attempts,omitempty"`
+	LastError     string        `json:"last_error,omitempty"`
+	Failures      []taskFailure `json:"failures,omitempty"`
+	NextAttemptAt time.Time     `json:"next_attempt_at"`
+	LeasedUntil   time.Time     `json:"leased_until"`
+}
+
+type taskFailure struct {
+	At  time.Time `json:"at"`
+	Err string    `json:"err"`
 }
 
 type Task[T any] struct {
-	name     string
-	registry *Registry
+	name           string
+	registry       *Registry
+	idempotencyKey string
+}
+
+// WithIdempotencyKey returns the task which rejects scheduling with ErrDuplicateTask
+// if a task with the same key was scheduled within the handler DedupRetention
+func (t Task[T]) WithIdempotencyKey(key string) Task[T] {
+	t.idempotencyKey = key
+	return t
 }
 
+// Schedule schedules the task to be executed as soon as possible
 func (t Task[T]) Schedule(tx db.Tx, retries int, args T) error {
-	key := queueKey(t.name)
-	queue, err := db.GetJsonDefault[[]dbTask[T]](tx, key, nil)
+	return t.ScheduleAt(tx, time.Now(), retries, args)
+}
+
+// ScheduleAfter schedules the task to be executed after delay
+func (t Task[T]) ScheduleAfter(tx db.Tx, delay time.Duration, retries int, args T) error {
+	return t.ScheduleAt(tx, time.Now().Add(delay), retries, args)
+}
+
+// ScheduleAt schedules the task to be executed at dueAt or right away if it's in the past
+func (t Task[T]) ScheduleAt(tx db.Tx, dueAt time.Time, retries int, args T) error {
+	seqKey := keySeqs.Key(t.name)
+	id, err := seqKey.GetDefault(tx, 0)
 	if err != nil {
-		return fmt.Errorf("get queue %q: %w", key, err)
+		return err
 	}
+	id++
 
-	dbt := dbTask[T]{
-		Name: t.name,
-		TTL:  retries + 1,
-		Args: args,
+	if t.idempotencyKey != "" {
+		retention := t.registry.handlers[t.name].opts.DedupRetention
+		if err := dedup(tx, t.name, t.idempotencyKey, id, retention, time.Now()); err != nil {
+			return err
+		}
 	}
-	queue = append(queue, dbt)
 
-	bytes, err := json.Marshal(queue)
+	dbt := dbTask[T]{
+		ID:             id,
+		Name:           t.name,
+		TTL:            retries + 1,
+		DueAt:          dueAt,
+		Args:           args,
+		IdempotencyKey: t.idempotencyKey,
+	}
+	bytes, err := json.Marshal(dbt)
 	if err != nil {
-		return fmt.Errorf("marshall queue: %w", err)
+		return fmt.Errorf("marshall task: %w", err)
 	}
 	// just a sanity check to not poison the queue with unmarshallable messages
-	var unmarshalled []dbTask[T]
+	var unmarshalled dbTask[T]
 	if err := json.Unmarshal(bytes, &unmarshalled); err != nil {
-		return fmt.Errorf("unmarshall queue: %w", err)
+		return fmt.Errorf("unmarshall task: %w", err)
+	}
+
+	key := pendingTaskKey[T](t.name, dueAt, id)
+	if err := tx.Set([]byte(key.String()), bytes); err != nil {
+		return fmt.Errorf("set task %q: %w", key, err)
 	}
-	if err := tx.Set([]byte(key), bytes); err != nil {
-		return fmt.Errorf("set queue %q: %w", key, err)
+	if err := seqKey.Set(tx, id); err != nil {
+		return err
 	}
 
 	select {
@@ -223,13 +303,29 @@ func (t Task[T]) Schedule(tx db.Tx, retries int, args T) error {
 	return nil
 }
 
-func RegisterHandler[T any](registry *Registry, name string, handler Handler[T]) (Task[T], error) {
+// RegisterHandler registers a handler of the queue, zero opts fields are set to defaults
+func RegisterHandler[T any](registry *Registry, name string, handler Handler[T], opts HandlerOpts) (Task[T], error) {
 	if _, ok := registry.handlers[name]; ok {
 		return Task[T]{}, fmt.Errorf("task handler for %q already registered", name)
 	}
+	if opts.Backoff == nil {
+		opts.Backoff = defaultBackoff
+	}
+	if opts.LeaseTimeout <= 0 {
+		opts.LeaseTimeout = defaultLeaseTimeout
+	}
+	if opts.MaxConcurrency <= 0 {
+		opts.MaxConcurrency = 1
+	}
+	if opts.DedupRetention <= 0 {
+		opts.DedupRetention = defaultDedupRetention
+	}
 
 	slog.Info("registered task handler", slog.String("name", name))
-	registry.handlers[name] = handler
+	registry.handlers[name] = registeredHandler{
+		handler: handler,
+		opts:    opts,
+	}
 	return Task[T]{
 		name:     name,
 		registry: registry,
@@ -1,13 +1,20 @@
 package dbq_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/backup"
 	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
 	"github.com/boar-d-white-foundation/drone/dbq"
+	"github.com/boar-d-white-foundation/drone/retry"
+	"github.com/boar-d-white-foundation/drone/tg/tgtest"
 	"github.com/stretchr/testify/require"
 )
 
@@ -15,7 +22,7 @@ func TestQueue(t *testing.T) {
 	t.Parallel()
 
 	ctx, cancel := context.WithCancel(context.Background())
-	database := db.NewBadgerDB(":memory:")
+	database := memdb.New()
 	err := database.Start(ctx)
 	require.NoError(t, err)
 	defer database.Stop()
@@ -23,7 +30,7 @@ func TestQueue(t *testing.T) {
 	registry := dbq.NewRegistry()
 	result := make(chan int)
 	shouldRetry := true
-	task, err := dbq.RegisterHandler(registry, "task", func(ctx context.Context, tx db.Tx, i int) error {
+	task, err := dbq.RegisterHandler(registry, "task", func(ctx context.Context, i int) error {
 		if shouldRetry {
 			shouldRetry = false
 			return errors.New("retry")
@@ -31,10 +38,10 @@ func TestQueue(t *testing.T) {
 
 		result <- i
 		return nil
-	})
+	}, dbq.HandlerOpts{Backoff: retry.LinearBackoff{MaxAttempts: 1}})
 	require.NoError(t, err)
 
-	queue, err := dbq.NewQueue(registry, database)
+	queue, err := dbq.NewQueue(registry, database, alert.NewManager(tgtest.NewClient(42)), 1, time.Second)
 	require.NoError(t, err)
 
 	done := make(chan struct{})
@@ -54,3 +61,409 @@ func TestQueue(t *testing.T) {
 	cancel()
 	<-done
 }
+
+func TestQueuePerTaskKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	registry := dbq.NewRegistry()
+	result := make(chan int)
+	task, err := dbq.RegisterHandler(registry, "test:task", func(ctx context.Context, i int) error {
+		result <- i
+		return nil
+	}, dbq.HandlerOpts{})
+	require.NoError(t, err)
+
+	sender := tgtest.NewClient(42)
+	queue, err := dbq.NewQueue(registry, database, alert.NewManager(sender), 1, time.Second)
+	require.NoError(t, err)
+
+	err = database.Do(ctx, func(tx db.Tx) error {
+		for i := range 3 {
+			require.NoError(t, task.Schedule(tx, 0, i+1))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	var keys []string
+	err = database.View(ctx, func(tx db.ReadTx) error {
+		return tx.Iterate(db.PrefixOpts("dbq:"), func(key, _ []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	require.Len(t, keys, 4)
+	for i, key := range keys[:3] {
+		require.Regexp(t, fmt.Sprintf(`^dbq:queue:test:task:pending:\d{20}:%020d$`, i+1), key)
+	}
+	require.Equal(t, "dbq:seq:test:task", keys[3])
+
+	// a poisoned task is moved to dlx alone, the rest of the queue is kept
+	poisonedKey := keys[1]
+	err = database.Do(ctx, func(tx db.Tx) error {
+		return tx.Set([]byte(poisonedKey), []byte("{"))
+	})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		queue.StartHandlers(ctx, 10*time.Millisecond)
+		done <- struct{}{}
+	}()
+
+	require.Equal(t, 1, <-result)
+	require.Equal(t, 3, <-result)
+	cancel()
+	<-done
+
+	err = database.View(ctx, func(tx db.ReadTx) error {
+		_, err := tx.Get([]byte(poisonedKey))
+		require.ErrorIs(t, err, db.ErrKeyNotFound)
+		val, err := tx.Get([]byte(fmt.Sprintf("dbq:queue:test:task:dlx:%020d", 2)))
+		require.NoError(t, err)
+		require.Equal(t, "{", string(val))
+
+		dead, err := dbq.Tasks(tx, "test:task", "dlx")
+		require.NoError(t, err)
+		require.Len(t, dead, 1)
+		require.NotEmpty(t, dead[0].DecodeErr)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, sender.Alerts(), 1)
+	require.Contains(t, sender.Alerts()[0], poisonedKey)
+
+	// it's requeued as is
+	err = database.Do(ctx, func(tx db.Tx) error {
+		require.NoError(t, dbq.Requeue(tx, "test:task", 2, time.Now()))
+		pending, err := dbq.Tasks(tx, "test:task", "pending")
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		val, err := tx.Get([]byte(pending[0].Key))
+		require.NoError(t, err)
+		require.Equal(t, "{", string(val))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestQueuePoisonedTaskBackup(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	registry := dbq.NewRegistry()
+	result := make(chan int)
+	task, err := dbq.RegisterHandler(registry, "test:task", func(ctx context.Context, i int) error {
+		result <- i
+		return nil
+	}, dbq.HandlerOpts{})
+	require.NoError(t, err)
+	alerts := alert.NewManager(tgtest.NewClient(42))
+	queue, err := dbq.NewQueue(registry, database, alerts, 1, time.Second)
+	require.NoError(t, err)
+
+	err = database.Do(ctx, func(tx db.Tx) error {
+		require.NoError(t, task.Schedule(tx, 0, 1))
+		require.NoError(t, task.Schedule(tx, 0, 2))
+		var poisonedKey []byte
+		err := tx.Iterate(db.PrefixOpts("dbq:queue:test:task:pending:"), func(key, _ []byte) error {
+			if poisonedKey == nil {
+				poisonedKey = bytes.Clone(key)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		return tx.Set(poisonedKey, []byte("{"))
+	})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		queue.StartHandlers(ctx, 10*time.Millisecond)
+		done <- struct{}{}
+	}()
+	require.Equal(t, 2, <-result)
+	cancel()
+	<-done
+
+	err = database.View(context.Background(), func(tx db.ReadTx) error {
+		val, err := tx.Get([]byte(fmt.Sprintf("dbq:queue:test:task:dlx:%020d", 1)))
+		require.NoError(t, err)
+		require.Equal(t, "{", string(val))
+		return nil
+	})
+	require.NoError(t, err)
+
+	// the dead lettered raw value doesn't break backups
+	service := backup.NewService(database, alerts, t.TempDir(), 1)
+	require.NoError(t, service.Backup(context.Background()))
+}
+
+func TestQueueScheduleAt(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	registry := dbq.NewRegistry()
+	result := make(chan string)
+	task, err := dbq.RegisterHandler(registry, "test:task", func(ctx context.Context, s string) error {
+		result <- s
+		return nil
+	}, dbq.HandlerOpts{})
+	require.NoError(t, err)
+
+	queue, err := dbq.NewQueue(registry, database, alert.NewManager(tgtest.NewClient(42)), 1, time.Second)
+	require.NoError(t, err)
+
+	delay := 200 * time.Millisecond
+	start := time.Now()
+	err = database.Do(ctx, func(tx db.Tx) error {
+		require.NoError(t, task.ScheduleAfter(tx, delay, 0, "delayed"))
+		require.NoError(t, task.Schedule(tx, 0, "now"))
+		require.NoError(t, task.ScheduleAt(tx, start.Add(-time.Hour), 0, "overdue"))
+		return nil
+	})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		// the queue must wake up at the due time instead of polling
+		queue.StartHandlers(ctx, time.Hour)
+		done <- struct{}{}
+	}()
+
+	require.Equal(t, "overdue", <-result)
+	require.Equal(t, "now", <-result)
+	require.Equal(t, "delayed", <-result)
+	require.GreaterOrEqual(t, time.Since(start), delay)
+	cancel()
+	<-done
+}
+
+func TestQueueRetries(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	registry := dbq.NewRegistry()
+	var attempts int
+	task, err := dbq.RegisterHandler(registry, "test:task", func(ctx context.Context, i int) error {
+		attempts++
+		return fmt.Errorf("attempt %d failed", attempts)
+	}, dbq.HandlerOpts{
+		Backoff: retry.ExponentialBackoff{Initial: 50 * time.Millisecond, Max: time.Second, MaxAttempts: 5},
+	})
+	require.NoError(t, err)
+
+	queue, err := dbq.NewQueue(registry, database, alert.NewManager(tgtest.NewClient(42)), 1, time.Second)
+	require.NoError(t, err)
+
+	err = database.Do(ctx, func(tx db.Tx) error {
+		return task.Schedule(tx, 2, 42)
+	})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		queue.StartHandlers(ctx, time.Hour)
+		done <- struct{}{}
+	}()
+
+	type failedTask struct {
+		ID        uint64 `json:"id"`
+		Args      int    `json:"args"`
+		Attempts  int    `json:"attempts"`
+		LastError string `json:"last_error"`
+		Failures  []struct {
+			At  time.Time `json:"at"`
+			Err string    `json:"err"`
+		} `json:"failures"`
+	}
+	// the task is kept in dlx after it runs out of retries
+	var dead []failedTask
+	require.Eventually(t, func() bool {
+		err := database.View(ctx, func(tx db.ReadTx) error {
+			dead = nil
+			return db.IterateJson(tx, db.PrefixOpts("dbq:queue:test:task:dlx:"), func(_ string, task failedTask) error {
+				dead = append(dead, task)
+				return nil
+			})
+		})
+		require.NoError(t, err)
+		return len(dead) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	require.Equal(t, 42, dead[0].Args)
+	require.Equal(t, 3, dead[0].Attempts)
+	require.Equal(t, "attempt 3 failed", dead[0].LastError)
+	require.Len(t, dead[0].Failures, 3)
+	require.Equal(t, 3, attempts)
+	// stored times are wall clock ones like due times of keys, time.Now() of the handler can disagree with them
+	failures := dead[0].Failures
+	require.GreaterOrEqual(t, failures[1].At.Sub(failures[0].At), 50*time.Millisecond)
+	require.GreaterOrEqual(t, failures[2].At.Sub(failures[1].At), 100*time.Millisecond)
+}
+
+func TestQueueLeases(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	// the first queue gets stuck executing the task like a crashed process
+	stuckRegistry := dbq.NewRegistry()
+	stuck, release := make(chan struct{}), make(chan struct{})
+	task, err := dbq.RegisterHandler(stuckRegistry, "test:task", func(ctx context.Context, i int) error {
+		close(stuck)
+		<-release
+		return nil
+	}, dbq.HandlerOpts{LeaseTimeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+	stuckQueue, err := dbq.NewQueue(stuckRegistry, database, alert.NewManager(tgtest.NewClient(42)), 1, time.Second)
+	require.NoError(t, err)
+
+	err = database.Do(ctx, func(tx db.Tx) error {
+		return task.Schedule(tx, 1, 42)
+	})
+	require.NoError(t, err)
+
+	stuckDone := make(chan struct{})
+	go func() {
+		stuckQueue.StartHandlers(ctx, time.Hour)
+		close(stuckDone)
+	}()
+	<-stuck
+
+	// the db isn't locked while the task is executed, the second queue redelivers the task after its lease expires
+	registry := dbq.NewRegistry()
+	result := make(chan int)
+	_, err = dbq.RegisterHandler(registry, "test:task", func(ctx context.Context, i int) error {
+		err := database.Do(ctx, func(tx db.Tx) error {
+			return db.SetJson(tx, "test:handled", i)
+		})
+		result <- i
+		return err
+	}, dbq.HandlerOpts{
+		// an expired lease is a failed attempt
+		Backoff:      retry.LinearBackoff{MaxAttempts: 1},
+		LeaseTimeout: 100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	queue, err := dbq.NewQueue(registry, database, alert.NewManager(tgtest.NewClient(42)), 1, time.Second)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		queue.StartHandlers(ctx, time.Hour)
+		close(done)
+	}()
+	require.Equal(t, 42, <-result)
+
+	// the late result of the expired lease is dropped
+	close(release)
+	cancel()
+	<-stuckDone
+	<-done
+
+	err = database.View(context.Background(), func(tx db.ReadTx) error {
+		handled, err := db.GetJson[int](tx, "test:handled")
+		require.NoError(t, err)
+		require.Equal(t, 42, handled)
+
+		var keys []string
+		err = tx.Iterate(db.PrefixOpts("dbq:queue:"), func(key, _ []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		})
+		require.NoError(t, err)
+		require.Empty(t, keys)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestQueueIdempotencyKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	registry := dbq.NewRegistry()
+	result := make(chan int)
+	task, err := dbq.RegisterHandler(registry, "dedup:task", func(ctx context.Context, i int) error {
+		result <- i
+		return nil
+	}, dbq.HandlerOpts{DedupRetention: 100 * time.Millisecond})
+	require.NoError(t, err)
+
+	queue, err := dbq.NewQueue(registry, database, alert.NewManager(tgtest.NewClient(42)), 1, time.Second)
+	require.NoError(t, err)
+
+	err = database.Do(ctx, func(tx db.Tx) error {
+		require.NoError(t, task.WithIdempotencyKey("a").ScheduleAfter(tx, time.Hour, 1, 1))
+		require.ErrorIs(t, task.WithIdempotencyKey("a").ScheduleAfter(tx, time.Hour, 1, 2), dbq.ErrDuplicateTask)
+		require.NoError(t, task.WithIdempotencyKey("b").ScheduleAfter(tx, time.Hour, 1, 3))
+		require.NoError(t, task.ScheduleAfter(tx, time.Hour, 1, 4))
+		require.NoError(t, task.ScheduleAfter(tx, time.Hour, 1, 5))
+
+		tasks, err := dbq.Tasks(tx, "dedup:task", "pending")
+		require.NoError(t, err)
+		require.Len(t, tasks, 4)
+		require.Equal(t, "a", tasks[0].IdempotencyKey)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// the key is accepted again once the retention window passes
+	time.Sleep(150 * time.Millisecond)
+	err = database.Do(ctx, func(tx db.Tx) error {
+		return task.WithIdempotencyKey("a").Schedule(tx, 1, 6)
+	})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		queue.StartHandlers(ctx, time.Second)
+		done <- struct{}{}
+	}()
+	require.Equal(t, 6, <-result)
+	cancel()
+	<-done
+
+	// expired dedup records are deleted by the consumer
+	err = database.View(context.Background(), func(tx db.ReadTx) error {
+		_, err := tx.Get([]byte("dbq:dedup:dedup:task:b"))
+		require.ErrorIs(t, err, db.ErrKeyNotFound)
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,311 @@
+package dbq
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/robfig/cron/v3"
+)
+
+// MisfirePolicy decides what to do with runs of a recurring job missed while the bot was down
+type MisfirePolicy string
+
+const (
+	// MisfireRunOnce runs the job once for all missed runs
+	MisfireRunOnce MisfirePolicy = "run_once"
+	// MisfireSkip skips missed runs, a run is missed if it's late for more than MisfireGrace
+	MisfireSkip MisfirePolicy = "skip"
+	// MisfireRunAll runs the job for every missed run up to maxCatchUpRuns
+	MisfireRunAll MisfirePolicy = "run_all"
+)
+
+const (
+	recurringTaskPrefix = "recurring:"
+	defaultMisfireGrace = time.Minute
+	// maxCatchUpRuns limits runs scheduled at once by MisfireRunAll
+	maxCatchUpRuns = 100
+	// maxJobRuns limits the history kept per job
+	maxJobRuns = 50
+)
+
+var (
+	// keyJobs holds the state of every recurring job as dbq:job:<name>
+	keyJobs = db.DeclareKeyFamily[jobState](keysOwner, "dbq:job:")
+	// keyJobRuns holds runs of recurring jobs as dbq:job_run:<escaped name>:<started at unix nanos>
+	keyJobRuns = db.DeclareKeyFamily[JobRun](keysOwner, "dbq:job_run:")
+)
+
+// jobRunsPrefix returns the prefix of runs of the job relative to keyJobRuns,
+// the name is escaped to have no ':', so runs of a job named a aren't prefixed by runs of a:b
+func jobRunsPrefix(name string) string {
+	return url.QueryEscape(name) + ":"
+}
+
+type RecurringOpts struct {
+	// Cron is a standard cron expression evaluated in UTC
+	Cron    string
+	Misfire MisfirePolicy
+	// MisfireGrace is how late a run may be scheduled before it's considered missed, 1 minute by default
+	MisfireGrace time.Duration
+	// Retries of a single run, runs out of retries are moved to dlx
+	Retries     int
+	HandlerOpts HandlerOpts
+}
+
+type recurringJob struct {
+	name     string
+	schedule cron.Schedule
+	opts     RecurringOpts
+	task     Task[jobRunArgs]
+}
+
+type jobState struct {
+	// LastScheduledAt is the time of the last run scheduled or skipped
+	LastScheduledAt time.Time `json:"last_scheduled_at"`
+	// LastSuccessAt is the scheduled time of the last successful run
+	LastSuccessAt time.Time `json:"last_success_at"`
+}
+
+type jobRunArgs struct {
+	ScheduledAt time.Time `json:"scheduled_at"`
+}
+
+// JobRun is a single execution of a recurring job, failed attempts of the same run are recorded separately
+type JobRun struct {
+	ScheduledAt time.Time `json:"scheduled_at"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Err         string    `json:"err,omitempty"`
+}
+
+// RegisterRecurring registers a job executed by the queue according to opts.Cron.
+// Runs are stored as tasks of the recurring:<name> queue, so they survive restarts,
+// runs missed while the bot was down are handled according to opts.Misfire on start.
+func RegisterRecurring(registry *Registry, name string, f func(context.Context) error, opts RecurringOpts) error {
+	if _, ok := registry.recurring[name]; ok {
+		return fmt.Errorf("recurring job %q already registered", name)
+	}
+	schedule, err := cron.ParseStandard(opts.Cron)
+	if err != nil {
+		return fmt.Errorf("parse cron %q of %q: %w", opts.Cron, name, err)
+	}
+	switch opts.Misfire {
+	case "":
+		opts.Misfire = MisfireRunOnce
+	case MisfireRunOnce, MisfireSkip, MisfireRunAll:
+	default:
+		return fmt.Errorf("unknown misfire policy %q of %q", opts.Misfire, name)
+	}
+	if opts.MisfireGrace <= 0 {
+		opts.MisfireGrace = defaultMisfireGrace
+	}
+
+	run := func(ctx context.Context, args jobRunArgs) (runErr error) {
+		startedAt := time.Now()
+		// a panicking run is recorded as failed too, the panic is returned as an error like Recover does
+		defer func() {
+			if r := recover(); r != nil {
+				runErr = fmt.Errorf("panic: %+v\n%s", r, debug.Stack())
+			}
+			jobRun := JobRun{
+				ScheduledAt: args.ScheduledAt,
+				StartedAt:   startedAt,
+				FinishedAt:  time.Now(),
+			}
+			if runErr != nil {
+				jobRun.Err = runErr.Error()
+			}
+
+			err := registry.queue.database.Do(context.WithoutCancel(ctx), func(tx db.Tx) error {
+				return recordJobRun(tx, name, jobRun)
+			})
+			if err != nil {
+				slog.Error("err record job run", slog.String("name", name), slog.Any("err", err))
+			}
+		}()
+
+		return f(ctx)
+	}
+	task, err := RegisterHandler(registry, recurringTaskPrefix+name, run, opts.HandlerOpts)
+	if err != nil {
+		return err
+	}
+
+	registry.recurring[name] = recurringJob{
+		name:     name,
+		schedule: schedule,
+		opts:     opts,
+		task:     task,
+	}
+	return nil
+}
+
+func recordJobRun(tx db.Tx, name string, jobRun JobRun) error {
+	if err := keyJobRuns.Keyf("%s%020d", jobRunsPrefix(name), jobRun.StartedAt.UnixNano()).Set(tx, jobRun); err != nil {
+		return err
+	}
+
+	suffixes := make([]string, 0)
+	opts := db.IterOpts{Prefix: []byte(keyJobRuns.Prefix() + jobRunsPrefix(name)), Reverse: true}
+	err := keyJobRuns.Iterate(tx, opts, func(suffix string, _ JobRun) error {
+		suffixes = append(suffixes, suffix)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterate %s runs: %w", name, err)
+	}
+	for _, suffix := range suffixes[min(len(suffixes), maxJobRuns):] {
+		if err := keyJobRuns.Key(suffix).Delete(tx); err != nil {
+			return err
+		}
+	}
+
+	if jobRun.Err != "" {
+		return nil
+	}
+	key := keyJobs.Key(name)
+	state, err := key.GetDefault(tx, jobState{})
+	if err != nil {
+		return err
+	}
+	if jobRun.ScheduledAt.After(state.LastSuccessAt) {
+		state.LastSuccessAt = jobRun.ScheduledAt
+	}
+	return key.Set(tx, state)
+}
+
+// scheduleRecurring schedules due runs of recurring jobs and returns the time the next run is due.
+// A job is scheduled for the first time at its next run after now, earlier runs are not caught up.
+func (q *Queue) scheduleRecurring(ctx context.Context, now time.Time) (time.Time, error) {
+	names := make([]string, 0, len(q.registry.recurring))
+	for name := range q.registry.recurring {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var nextAt time.Time
+	err := q.database.Do(ctx, func(tx db.Tx) error {
+		nextAt = time.Time{}
+		for _, name := range names {
+			next, err := scheduleJob(tx, q.registry.recurring[name], now)
+			if err != nil {
+				return fmt.Errorf("schedule %s: %w", name, err)
+			}
+			nextAt = earliest(nextAt, next)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return nextAt, nil
+}
+
+func scheduleJob(tx db.Tx, job recurringJob, now time.Time) (time.Time, error) {
+	now = now.UTC()
+	key := keyJobs.Key(job.name)
+	state, err := key.GetDefault(tx, jobState{})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if state.LastScheduledAt.IsZero() {
+		state.LastScheduledAt = now
+		if err := key.Set(tx, state); err != nil {
+			return time.Time{}, err
+		}
+		return job.schedule.Next(now), nil
+	}
+
+	due := make([]time.Time, 0, 1)
+	next := job.schedule.Next(state.LastScheduledAt.UTC())
+	for ; !next.After(now); next = job.schedule.Next(next) {
+		due = append(due, next)
+	}
+	if len(due) == 0 {
+		return next, nil
+	}
+
+	latest := due[len(due)-1]
+	missed := now.Sub(due[0]) > job.opts.MisfireGrace
+	if missed {
+		slog.Warn(
+			"recurring job missed runs",
+			slog.String("name", job.name),
+			slog.Int("runs", len(due)),
+			slog.Time("first", due[0]),
+			slog.String("misfire", string(job.opts.Misfire)),
+		)
+	}
+	switch job.opts.Misfire {
+	case MisfireRunOnce:
+		due = []time.Time{latest}
+	case MisfireSkip:
+		due = due[:0]
+		if now.Sub(latest) <= job.opts.MisfireGrace {
+			due = append(due, latest)
+		}
+	case MisfireRunAll:
+		due = due[max(len(due)-maxCatchUpRuns, 0):]
+	}
+
+	for _, scheduledAt := range due {
+		err := job.task.ScheduleAt(tx, now, job.opts.Retries, jobRunArgs{ScheduledAt: scheduledAt})
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	state.LastScheduledAt = latest
+	if err := key.Set(tx, state); err != nil {
+		return time.Time{}, err
+	}
+	slog.Info("scheduled recurring job", slog.String("name", job.name), slog.Time("next_run", next))
+	return next, nil
+}
+
+type JobInfo struct {
+	Name            string
+	LastScheduledAt time.Time
+	LastSuccessAt   time.Time
+}
+
+// Jobs returns states of recurring jobs stored in the db sorted by name
+func Jobs(tx db.ReadTx) ([]JobInfo, error) {
+	result := make([]JobInfo, 0)
+	err := keyJobs.Iterate(tx, db.IterOpts{}, func(name string, state jobState) error {
+		result = append(result, JobInfo{
+			Name:            name,
+			LastScheduledAt: state.LastScheduledAt,
+			LastSuccessAt:   state.LastSuccessAt,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("iterate jobs: %w", err)
+	}
+
+	return result, nil
+}
+
+// JobHistory returns the last runs of the recurring job, the latest first
+func JobHistory(tx db.ReadTx, name string) ([]JobRun, error) {
+	result := make([]JobRun, 0)
+	opts := db.IterOpts{Prefix: []byte(keyJobRuns.Prefix() + jobRunsPrefix(name)), Reverse: true}
+	err := keyJobRuns.Iterate(tx, opts, func(_ string, jobRun JobRun) error {
+		result = append(result, jobRun)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("iterate %s runs: %w", name, err)
+	}
+
+	return result, nil
+}
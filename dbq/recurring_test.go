@@ -0,0 +1,174 @@
+package dbq_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/boar-d-white-foundation/drone/dbq"
+	"github.com/boar-d-white-foundation/drone/tg/tgtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecurringMisfire(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	// the bot was down for 5 hourly runs
+	lastScheduledAt := time.Now().UTC().Truncate(time.Hour).Add(-5*time.Hour + time.Second)
+	err = database.Do(ctx, func(tx db.Tx) error {
+		for _, name := range []string{"once", "skip", "all", "panic"} {
+			state, err := json.Marshal(map[string]any{"last_scheduled_at": lastScheduledAt})
+			require.NoError(t, err)
+			require.NoError(t, tx.Set([]byte("dbq:job:"+name), state))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	registry := dbq.NewRegistry()
+	runs := make(map[string]*atomic.Int32)
+	for name, misfire := range map[string]dbq.MisfirePolicy{
+		"once":  dbq.MisfireRunOnce,
+		"skip":  dbq.MisfireSkip,
+		"all":   dbq.MisfireRunAll,
+		"new":   dbq.MisfireRunAll,
+		"panic": dbq.MisfireRunOnce,
+	} {
+		runs[name] = new(atomic.Int32)
+		err := dbq.RegisterRecurring(registry, name, func(ctx context.Context) error {
+			n := runs[name].Add(1)
+			switch {
+			case name == "panic":
+				panic("job panicked")
+			case name == "all" && n == 1:
+				return errors.New("first run fails")
+			}
+			return nil
+		}, dbq.RecurringOpts{
+			Cron:         "0 * * * *",
+			Misfire:      misfire,
+			MisfireGrace: time.Nanosecond,
+		})
+		require.NoError(t, err)
+	}
+	err = dbq.RegisterRecurring(registry, "once", func(ctx context.Context) error {
+		return nil
+	}, dbq.RecurringOpts{Cron: "0 * * * *"})
+	require.Error(t, err)
+	err = dbq.RegisterRecurring(registry, "invalid", func(ctx context.Context) error {
+		return nil
+	}, dbq.RecurringOpts{Cron: "0 * *"})
+	require.Error(t, err)
+
+	queue, err := dbq.NewQueue(registry, database, alert.NewManager(tgtest.NewClient(42)), 4, time.Second)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		queue.StartHandlers(ctx, time.Hour)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		return runs["once"].Load() == 1 && runs["all"].Load() == 5 && runs["panic"].Load() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	require.Equal(t, int32(0), runs["skip"].Load())
+	require.Equal(t, int32(0), runs["new"].Load())
+
+	err = database.View(context.Background(), func(tx db.ReadTx) error {
+		history, err := dbq.JobHistory(tx, "all")
+		require.NoError(t, err)
+		require.Len(t, history, 5)
+		failed := 0
+		for _, run := range history {
+			if run.Err != "" {
+				failed++
+			}
+		}
+		require.Equal(t, 1, failed)
+
+		history, err = dbq.JobHistory(tx, "panic")
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		require.Contains(t, history[0].Err, "panic: job panicked")
+
+		jobs, err := dbq.Jobs(tx)
+		require.NoError(t, err)
+		require.Len(t, jobs, 5)
+		for _, job := range jobs {
+			require.False(t, job.LastScheduledAt.Before(time.Now().Truncate(time.Hour)), job.Name)
+			if job.Name == "once" || job.Name == "all" {
+				require.Equal(t, time.Now().UTC().Truncate(time.Hour), job.LastSuccessAt.UTC(), job.Name)
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestRecurringHistoryOfPrefixedNames(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	database := memdb.New()
+	err := database.Start(ctx)
+	require.NoError(t, err)
+	defer database.Stop()
+
+	// jobs of other communities are prefixed by their name
+	names := []string{"job", "job:other", "other:job"}
+	lastScheduledAt := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour + time.Second)
+	registry := dbq.NewRegistry()
+	var runs atomic.Int32
+	for _, name := range names {
+		err = database.Do(ctx, func(tx db.Tx) error {
+			state, err := json.Marshal(map[string]any{"last_scheduled_at": lastScheduledAt})
+			require.NoError(t, err)
+			return tx.Set([]byte("dbq:job:"+name), state)
+		})
+		require.NoError(t, err)
+		err := dbq.RegisterRecurring(registry, name, func(ctx context.Context) error {
+			runs.Add(1)
+			return nil
+		}, dbq.RecurringOpts{Cron: "0 * * * *"})
+		require.NoError(t, err)
+	}
+
+	queue, err := dbq.NewQueue(registry, database, alert.NewManager(tgtest.NewClient(42)), 1, time.Second)
+	require.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		queue.StartHandlers(ctx, time.Hour)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		return runs.Load() == int32(len(names))
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	err = database.View(context.Background(), func(tx db.ReadTx) error {
+		for _, name := range names {
+			history, err := dbq.JobHistory(tx, name)
+			require.NoError(t, err)
+			require.Len(t, history, 1, name)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
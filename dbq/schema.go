@@ -0,0 +1,11 @@
+package dbq
+
+import (
+	"github.com/boar-d-white-foundation/drone/db"
+)
+
+// RegisterTaskSchema registers tasks of the queue with its args type,
+// tasks of other queues are checked with raw args
+func RegisterTaskSchema[T any](s *db.Schema, owner, name string) {
+	db.RegisterJson[dbTask[T]](s, owner, keyQueues.Prefix()+name+":*")
+}
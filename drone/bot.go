@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
+	"github.com/boar-d-white-foundation/drone/admin"
 	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/backup"
 	"github.com/boar-d-white-foundation/drone/boardwhite"
 	"github.com/boar-d-white-foundation/drone/chrome"
 	"github.com/boar-d-white-foundation/drone/config"
@@ -14,11 +17,12 @@ import (
 	"github.com/boar-d-white-foundation/drone/dbq"
 	"github.com/boar-d-white-foundation/drone/leetcode"
 	"github.com/boar-d-white-foundation/drone/media"
+	"github.com/boar-d-white-foundation/drone/migrations"
+	"github.com/boar-d-white-foundation/drone/schema"
 	"github.com/boar-d-white-foundation/drone/tg"
-	"github.com/go-co-op/gocron/v2"
 )
 
-func startDrone(ctx context.Context, cfg config.Config, alerts *alert.Manager) error {
+func startDrone(ctx context.Context, cfg config.Config, alerts *alert.Manager, tgService *tg.Service) error {
 	started := make(chan struct{}, 1)
 	go func() {
 		select {
@@ -41,37 +45,77 @@ func startDrone(ctx context.Context, cfg config.Config, alerts *alert.Manager) e
 
 	lcClient := leetcode.NewClientFromConfig(cfg)
 
-	tgService, err := tg.NewBoardwhiteServiceFromConfig(cfg, alerts)
+	database, err := db.NewDBFromConfig(cfg)
 	if err != nil {
 		return err
 	}
-
-	database := db.NewBadgerDBFromConfig(cfg)
 	if err := database.Start(ctx); err != nil {
 		return err
 	}
-	defer database.Stop()
+	stopDatabase := sync.OnceFunc(database.Stop)
+	defer stopDatabase()
+	if bdb, ok := database.(*db.BadgerDB); ok {
+		bdb.StartMaintenance(alerts, db.NewBadgerMaintenanceOptsFromConfig(cfg))
+	}
 
-	if err := migrate(ctx, database); err != nil {
+	if err := migrations.Migrate(ctx, database); err != nil {
 		return err
 	}
 
-	bw, err := boardwhite.NewServiceFromConfig(cfg, tgService, database, alerts, mediaGenerator, lcClient)
+	// a broken key shouldn't stop the bot, most of the handlers don't touch it
+	report, err := schema.New(cfg).Check(ctx, database)
 	if err != nil {
 		return err
 	}
+	if !report.OK() {
+		alerts.Errorf("db check failed:\n%s", report)
+	}
+
+	router := tg.NewRouter(tgService.BotName())
+	communities := make([]*boardwhite.Service, 0, len(cfg.AllCommunities()))
+	for _, community := range cfg.AllCommunities() {
+		bw, err := boardwhite.NewServiceFromConfig(
+			cfg, community, tgService, database, alerts, mediaGenerator, lcClient,
+		)
+		if err != nil {
+			return fmt.Errorf("new %s service: %w", community.Name, err)
+		}
 
-	bw.RegisterHandlers(ctx, tgService)
+		bw.RegisterHandlers(ctx, tgService)
+		if err := bw.RegisterCommands(ctx, router); err != nil {
+			return fmt.Errorf("register %s commands: %w", community.Name, err)
+		}
+		communities = append(communities, bw)
+	}
+	if err := admin.NewServiceFromConfig(cfg, database).RegisterCommands(ctx, router); err != nil {
+		return fmt.Errorf("register admin commands: %w", err)
+	}
+	router.Register(tgService)
 	tgService.Start()
-	defer tgService.Stop()
+	stopUpdates := sync.OnceFunc(tgService.Stop)
+	defer stopUpdates()
 	slog.Info("started tg handlers")
 
 	dbqRegistry := dbq.NewRegistry()
-	if err := bw.RegisterTasks(dbqRegistry); err != nil {
+	dbqRegistry.Use(
+		dbq.Logging(),
+		dbq.Timing(time.Minute),
+		dbq.AlertOnFinalFailure(alerts),
+		dbq.Recover(),
+		dbq.Timeout(2*time.Minute),
+	)
+	for _, bw := range communities {
+		if err := bw.RegisterTasks(dbqRegistry); err != nil {
+			return err
+		}
+	}
+
+	backupService := backup.NewServiceFromConfig(cfg, database, alerts)
+	if err := registerRecurringJobs(cfg, dbqRegistry, communities, backupService); err != nil {
 		return err
 	}
 
-	queue, err := dbq.NewQueue(dbqRegistry, database)
+	queue, err := dbq.NewQueueFromConfig(cfg, dbqRegistry, database, alerts)
 	if err != nil {
 		return err
 	}
@@ -79,146 +123,72 @@ func startDrone(ctx context.Context, cfg config.Config, alerts *alert.Manager) e
 	dbqDone := make(chan struct{})
 	go func() {
 		queue.StartHandlers(ctx, 30*time.Second)
-		dbqDone <- struct{}{}
+		close(dbqDone)
 	}()
 	slog.Info("started dbq")
-
-	scheduler, err := gocron.NewScheduler(gocron.WithLocation(time.UTC))
-	if err != nil {
-		return err
-	}
-
-	jobs, err := registerCronJobs(ctx, cfg, alerts, scheduler, bw)
-	if err != nil {
-		return err
-	}
-
-	scheduler.Start()
-	slog.Info("started scheduler")
-	for _, jb := range jobs {
-		t, err := jb.NextRun()
-		if err != nil {
-			return err
-		}
-		slog.Info(
-			"scheduled job",
-			slog.String("name", jb.name),
-			slog.String("cron", jb.cron),
-			slog.String("next_run", t.String()),
-		)
-	}
 	slog.Info("start bot OK")
 
 	started <- struct{}{}
 	<-ctx.Done()
-	<-dbqDone
-	return scheduler.Shutdown()
+	shutdown(stopUpdates, dbqDone, stopDatabase)
+	return nil
 }
 
-type job struct {
-	gocron.Job
-
+type recurringJob struct {
 	name string
 	cron string
+	f    func(context.Context) error
+	// idempotent jobs interrupted by shutdown are run again on start
+	idempotent bool
 }
 
-func registerCronJobs(
-	ctx context.Context,
+// registerRecurringJobs registers jobs executed by dbq, runs missed while the bot is down are run once on start
+func registerRecurringJobs(
 	cfg config.Config,
-	alerts *alert.Manager,
-	scheduler gocron.Scheduler,
-	bw *boardwhite.Service,
-) ([]job, error) {
-	jobs := make([]job, 0)
-	jb, err := registerJob(ctx, alerts, scheduler, "PublishLCDaily", cfg.LeetcodeDaily.Cron, bw.PublishLCDaily)
-	if err != nil {
-		return nil, err
-	}
-	jobs = append(jobs, jb)
-
-	jb, err = registerJob(
-		ctx,
-		alerts,
-		scheduler,
-		"PublishLCChickensDaily",
-		cfg.LeetcodeDaily.Cron,
-		bw.PublishLCChickensDaily,
-	)
-	if err != nil {
-		return nil, err
-	}
-	jobs = append(jobs, jb)
-
-	jb, err = registerJob(ctx, alerts, scheduler, "PublishLCRating", cfg.LeetcodeDaily.RatingCron, bw.PublishLCRating)
-	if err != nil {
-		return nil, err
-	}
-	jobs = append(jobs, jb)
-
-	jb, err = registerJob(
-		ctx,
-		alerts,
-		scheduler,
-		"PublishLCChickensRating",
-		cfg.LeetcodeDaily.RatingCron,
-		bw.PublishLCChickensRating,
-	)
-	if err != nil {
-		return nil, err
-	}
-	jobs = append(jobs, jb)
-
-	jb, err = registerJob(ctx, alerts, scheduler, "PublishNCDaily", cfg.NeetcodeDaily.Cron, bw.PublishNCDaily)
-	if err != nil {
-		return nil, err
-	}
-	jobs = append(jobs, jb)
-
-	jb, err = registerJob(ctx, alerts, scheduler, "PublishNCRating", cfg.NeetcodeDaily.RatingCron, bw.PublishNCRating)
-	if err != nil {
-		return nil, err
-	}
-	jobs = append(jobs, jb)
-
-	return jobs, nil
-}
-
-func registerJob(
-	ctx context.Context,
-	alerts *alert.Manager,
-	s gocron.Scheduler,
-	name, cron string,
-	f func(context.Context) error,
-) (job, error) {
-	jb, err := s.NewJob(
-		gocron.CronJob(cron, false),
-		gocron.NewTask(wrapErrors(alerts, name, f), ctx),
-	)
-	if err != nil {
-		return job{}, err
+	registry *dbq.Registry,
+	communities []*boardwhite.Service,
+	backupService *backup.Service,
+) error {
+	jobs := make([]recurringJob, 0)
+	for _, bw := range communities {
+		// boardwhite jobs keep names they had before communities, the state of a job is stored by its name
+		prefix := ""
+		if bw.Name() != boardwhite.DefaultName {
+			prefix = bw.Name() + ":"
+		}
+		jobs = append(
+			jobs,
+			recurringJob{name: prefix + "PublishLCDaily", cron: cfg.LeetcodeDaily.Cron, f: bw.PublishLCDaily},
+			recurringJob{
+				name: prefix + "PublishLCChickensDaily", cron: cfg.LeetcodeDaily.Cron, f: bw.PublishLCChickensDaily,
+			},
+			recurringJob{name: prefix + "PublishLCRating", cron: cfg.LeetcodeDaily.RatingCron, f: bw.PublishLCRating},
+			recurringJob{
+				name: prefix + "PublishLCChickensRating", cron: cfg.LeetcodeDaily.RatingCron, f: bw.PublishLCChickensRating,
+			},
+			recurringJob{name: prefix + "PublishNCDaily", cron: cfg.NeetcodeDaily.Cron, f: bw.PublishNCDaily},
+			recurringJob{name: prefix + "PublishNCRating", cron: cfg.NeetcodeDaily.RatingCron, f: bw.PublishNCRating},
+		)
 	}
-
-	return job{
-		Job:  jb,
-		name: name,
-		cron: cron,
-	}, nil
-}
-
-func wrapErrors(alerts *alert.Manager, name string, f func(context.Context) error) func(context.Context) {
-	return func(ctx context.Context) {
-		defer func() {
-			if err := recover(); err != nil {
-				alerts.Errorf("panic in cron task %s: %s", name, fmt.Sprintf("%+v", err))
-			}
-		}()
-
-		slog.Info("started cron task run", slog.String("name", name))
-		err := f(ctx)
+	if cfg.Backup.Enabled {
+		jobs = append(jobs, recurringJob{name: "Backup", cron: cfg.Backup.Cron, f: backupService.Backup, idempotent: true})
+	}
+
+	for _, job := range jobs {
+		err := dbq.RegisterRecurring(registry, job.name, job.f, dbq.RecurringOpts{
+			Cron: job.cron,
+			// publishing isn't idempotent, so a missed day is published once and failed runs aren't retried
+			Misfire: dbq.MisfireRunOnce,
+			HandlerOpts: dbq.HandlerOpts{
+				LeaseTimeout:  30 * time.Minute,
+				Timeout:       30 * time.Minute,
+				NotIdempotent: !job.idempotent,
+			},
+		})
 		if err != nil {
-			alerts.Errorxf(err, "err in cron task %s", name)
-			return
+			return fmt.Errorf("register %s: %w", job.name, err)
 		}
-		slog.Info("finished cron task run", slog.String("name", name))
 	}
+
+	return nil
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/boardwhite"
+	"github.com/boar-d-white-foundation/drone/config"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/boar-d-white-foundation/drone/leetcode"
+	"github.com/boar-d-white-foundation/drone/migrations"
+	"github.com/boar-d-white-foundation/drone/tg"
+	"github.com/boar-d-white-foundation/drone/tg/fakeapi"
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+// TestDailyFlow publishes a neetcode daily, solves it and publishes the rating against the fake Bot API
+func TestDailyFlow(t *testing.T) {
+	t.Parallel()
+
+	// arrange
+	ctx := context.Background()
+	api := fakeapi.NewServer(tele.User{ID: 42, IsBot: true, Username: "drone_bot"})
+	defer api.Close()
+
+	cfg, err := config.Default()
+	require.NoError(t, err)
+	cfg.Tg.APIURL = api.URL()
+	cfg.Tg.Key = "token"
+	cfg.Tg.LongPollerTimeout = time.Second
+	chatID, threadID := cfg.Boardwhite.ChatID, cfg.Boardwhite.LeetCodeThreadID
+
+	tgService, err := tg.NewServiceFromConfig(cfg)
+	require.NoError(t, err)
+	alerts := alert.NewManager(tgService)
+
+	database := memdb.New()
+	require.NoError(t, database.Start(ctx))
+	defer database.Stop()
+	require.NoError(t, migrations.Migrate(ctx, database))
+
+	lcClient := leetcode.NewClientFromConfig(cfg)
+	bw, err := boardwhite.NewServiceFromConfig(cfg, cfg.Boardwhite, tgService, database, alerts, nil, lcClient)
+	require.NoError(t, err)
+	bw.RegisterHandlers(ctx, tgService)
+	tgService.Start()
+	defer tgService.Stop()
+
+	// act
+	require.NoError(t, bw.PublishNCDaily(ctx))
+	sent := api.Calls("sendMessage")
+	require.Len(t, sent, 1)
+	daily := sent[0].Result
+	require.Equal(t, chatID, daily.Chat.ID)
+	require.Equal(t, threadID, daily.ThreadID)
+	require.Contains(t, daily.Text, "NeetCode")
+	require.Len(t, api.Calls("sendSticker"), 1)
+	pinned := api.Calls("pinChatMessage")
+	require.Len(t, pinned, 1)
+	require.Equal(t, strconv.Itoa(daily.ID), pinned[0].Params["message_id"])
+
+	solution := api.AddMessage(tele.Message{
+		Sender:          &tele.User{ID: 7, Username: "boar"},
+		Chat:            &tele.Chat{ID: chatID, Type: tele.ChatSuperGroup},
+		ThreadID:        threadID,
+		ReplyTo:         daily,
+		Photo:           &tele.Photo{File: tele.File{FileID: "solution"}},
+		HasMediaSpoiler: true,
+	})
+
+	// assert
+	reactions, err := api.WaitCalls("setMessageReaction", 1, 5*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(solution.ID), reactions[0].Params["message_id"])
+	var reaction []tg.Reaction
+	require.NoError(t, json.Unmarshal([]byte(reactions[0].Params["reaction"]), &reaction))
+	require.Equal(t, []tg.Reaction{tg.ReactionOk}, reaction)
+
+	// the reaction is set before the solution is saved, an empty rating isn't published
+	for deadline := time.Now().Add(5 * time.Second); len(api.Calls("sendMessage")) < 2; {
+		require.True(t, time.Now().Before(deadline), "rating is not published")
+		require.NoError(t, bw.PublishNCRating(ctx))
+		time.Sleep(10 * time.Millisecond)
+	}
+	sent = api.Calls("sendMessage")
+	require.Len(t, sent, 2)
+	rating := sent[1]
+	require.Equal(t, tele.ModeMarkdownV2, rating.Params["parse_mode"])
+	require.Equal(t, strconv.Itoa(threadID), rating.Params["message_thread_id"])
+	require.Contains(t, rating.Params["text"], "@boar")
+
+	for _, call := range sent {
+		require.NotEqual(t, cfg.Tg.AdminChatID, call.Result.Chat.ID, "unexpected alert: %s", call.Params["text"])
+	}
+}
@@ -6,5 +6,5 @@ import (
 )
 
 func main() {
-	cli.Run("drone-start", startDrone)
+	cli.RunWithTelegram("drone-start", startDrone)
 }
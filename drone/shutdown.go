@@ -0,0 +1,23 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// shutdown stops the bot in order: tg updates first, so handlers don't schedule new tasks,
+// then waits for the queue to drain executing tasks, which store their results or return to pending,
+// and stops the database last
+func shutdown(stopUpdates func(), queueDone <-chan struct{}, stopDatabase func()) {
+	startedAt := time.Now()
+	slog.Info("shutting down")
+
+	stopUpdates()
+	slog.Info("stopped tg updates")
+
+	<-queueDone
+	slog.Info("stopped dbq")
+
+	stopDatabase()
+	slog.Info("stopped database", slog.Duration("elapsed", time.Since(startedAt)))
+}
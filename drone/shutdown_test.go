@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/alert"
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/boar-d-white-foundation/drone/dbq"
+	"github.com/boar-d-white-foundation/drone/tg/tgtest"
+	"github.com/stretchr/testify/require"
+)
+
+type events struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (e *events) add(event string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.list = append(e.list, event)
+}
+
+func (e *events) get() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]string(nil), e.list...)
+}
+
+func TestShutdown(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	database := memdb.New()
+	require.NoError(t, database.Start(ctx))
+
+	var evs events
+	registry := dbq.NewRegistry()
+	started := make(chan struct{})
+	task, err := dbq.RegisterHandler(registry, "test:task", func(ctx context.Context, i int) error {
+		close(started)
+		// the task is still executing when the bot is stopped
+		time.Sleep(50 * time.Millisecond)
+		evs.add("task finished")
+		return ctx.Err()
+	}, dbq.HandlerOpts{})
+	require.NoError(t, err)
+	queue, err := dbq.NewQueue(registry, database, alert.NewManager(tgtest.NewClient(42)), 1, time.Second)
+	require.NoError(t, err)
+
+	err = database.Do(ctx, func(tx db.Tx) error {
+		return task.Schedule(tx, 0, 42)
+	})
+	require.NoError(t, err)
+
+	queueDone := make(chan struct{})
+	go func() {
+		queue.StartHandlers(ctx, time.Hour)
+		close(queueDone)
+	}()
+	<-started
+
+	cancel()
+	shutdown(
+		func() {
+			evs.add("updates stopped")
+		},
+		queueDone,
+		func() {
+			// the task result is stored before the database is stopped
+			err := database.View(context.Background(), func(tx db.ReadTx) error {
+				tasks, err := dbq.Tasks(tx, "test:task", "")
+				require.NoError(t, err)
+				require.Empty(t, tasks)
+				return nil
+			})
+			require.NoError(t, err)
+
+			database.Stop()
+			evs.add("database stopped")
+		},
+	)
+
+	require.Equal(t, []string{"updates stopped", "task finished", "database stopped"}, evs.get())
+}
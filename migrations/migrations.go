@@ -0,0 +1,523 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/db"
+)
+
+// All returns drone migrations in the order they are applied,
+// 0002 and 0003 drop or overwrite data, so they can't be reverted
+func All() []db.Migration {
+	return []db.Migration{
+		{
+			ID:   "0001",
+			Name: "add_default_greeted_users",
+			Fn:   addDefaultGreetedUsers,
+			Down: removeDefaultGreetedUsers,
+		},
+		{ID: "0002", Name: "drop_poisoned_db_queue", Fn: dropPoisonedDBQueue},
+		{ID: "0003", Name: "add_initial_okr_values", Fn: addInitialOkrValues},
+		{
+			ID:   "0004",
+			Name: "split_stats_into_records",
+			Fn:   splitStatsIntoRecords,
+			Down: joinStatsRecords,
+		},
+		{
+			ID:   "0005",
+			Name: "split_dbq_queues_into_tasks",
+			Fn:   splitDBQQueues,
+			Down: joinDBQQueues,
+		},
+		{
+			ID:   "0006",
+			Name: "add_due_time_to_dbq_pending_keys",
+			Fn:   addDueTimeToDBQKeys,
+			Down: removeDueTimeFromDBQKeys,
+		},
+		{
+			ID:   "0007",
+			Name: "retry_dbq_dlx_tasks",
+			Fn:   retryDBQDLXTasks,
+			Down: keepDBQPendingTasks,
+		},
+	}
+}
+
+func Migrate(ctx context.Context, database db.DB) error {
+	return db.MigrateJson(ctx, database, db.AppliedMigrationsKey, All())
+}
+
+var defaultGreetedUsers = []int64{142944542}
+
+func addDefaultGreetedUsers(tx db.Tx) error {
+	key := "boardwhite:on_join_greeted_users"
+	greetedUsers, err := db.GetJsonDefault(tx, key, make(map[int64]struct{}))
+	if err != nil {
+		return fmt.Errorf("get %q: %w", key, err)
+	}
+
+	for _, uid := range defaultGreetedUsers {
+		greetedUsers[uid] = struct{}{}
+	}
+	if err := db.SetJson(tx, key, greetedUsers); err != nil {
+		return fmt.Errorf("set %q: %w", key, err)
+	}
+
+	return nil
+}
+
+func removeDefaultGreetedUsers(tx db.Tx) error {
+	key := "boardwhite:on_join_greeted_users"
+	greetedUsers, err := db.GetJsonDefault(tx, key, make(map[int64]struct{}))
+	if err != nil {
+		return fmt.Errorf("get %q: %w", key, err)
+	}
+
+	for _, uid := range defaultGreetedUsers {
+		delete(greetedUsers, uid)
+	}
+	if err := db.SetJson(tx, key, greetedUsers); err != nil {
+		return fmt.Errorf("set %q: %w", key, err)
+	}
+
+	return nil
+}
+
+func dropPoisonedDBQueue(tx db.Tx) error {
+	key := "dbq:queue:boardwhite:post_code_snippet"
+	if err := tx.Delete([]byte(key)); err != nil {
+		return fmt.Errorf("delete %q: %w", key, err)
+	}
+
+	return nil
+}
+
+func addInitialOkrValues(tx db.Tx) error {
+	key := "boardwhite:okr:values"
+
+	type okrs struct {
+		TotalCount map[string]int `json:"total_count"`
+	}
+
+	counts := map[string]int{
+		"#unfortunately2025": 39,
+		"#bigtech_offer2025": 1,
+		"#faang_offer2025":   1,
+		"#senior_promo2025":  0,
+		"#staff_promo2025":   0,
+		"#usa2025":           0,
+	}
+	if err := db.SetJson(tx, key, okrs{TotalCount: counts}); err != nil {
+		return fmt.Errorf("set %q: %w", key, err)
+	}
+
+	return nil
+}
+
+func splitStatsIntoRecords(tx db.Tx) error {
+	for _, ns := range []string{"boardwhite:leetcode", "boardwhite:leetcode_chickens", "boardwhite:neetcode"} {
+		if err := splitNamespaceStats(tx, ns); err != nil {
+			return fmt.Errorf("split %q: %w", ns, err)
+		}
+	}
+
+	return nil
+}
+
+// types of 0004 are copied to not depend on the current boardwhite types
+
+type splitStatsDayInfo struct {
+	DayIdx      int64     `json:"day_idx"`
+	MessageID   int       `json:"message_id,omitempty"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+type joinedStats struct {
+	Solutions map[string]json.RawMessage  `json:"solutions"`
+	DaysInfo  map[int64]splitStatsDayInfo `json:"days_info"`
+}
+
+func splitNamespaceStats(tx db.Tx, ns string) error {
+	pinnedKey, dayInfoKey, statsKey := ns+":pinned_messages", ns+":pinned_to_stats_day_info", ns+":stats"
+	msgToDayInfo, err := db.GetJsonDefault(tx, dayInfoKey, make(map[int]splitStatsDayInfo))
+	if err != nil {
+		return fmt.Errorf("get %q: %w", dayInfoKey, err)
+	}
+
+	// later messages win if a day was published twice
+	messageIDs := make([]int, 0, len(msgToDayInfo))
+	for messageID := range msgToDayInfo {
+		messageIDs = append(messageIDs, messageID)
+	}
+	sort.Ints(messageIDs)
+	for _, messageID := range messageIDs {
+		dayInfo := msgToDayInfo[messageID]
+		dayInfo.MessageID = messageID
+		key := fmt.Sprintf("%s:day_info:%010d", ns, dayInfo.DayIdx)
+		if err := db.SetJson(tx, key, dayInfo); err != nil {
+			return fmt.Errorf("set %q: %w", key, err)
+		}
+
+		key = fmt.Sprintf("%s:pinned_message:%d", ns, messageID)
+		if err := db.SetJson(tx, key, dayInfo.DayIdx); err != nil {
+			return fmt.Errorf("set %q: %w", key, err)
+		}
+	}
+
+	oldStats, err := db.GetJsonDefault(tx, statsKey, joinedStats{})
+	if err != nil {
+		return fmt.Errorf("get %q: %w", statsKey, err)
+	}
+
+	for solKey, sol := range oldStats.Solutions {
+		rawDayIdx, rawUserID, ok := strings.Cut(solKey, "|")
+		if !ok {
+			return fmt.Errorf("invalid solution key %q", solKey)
+		}
+		dayIdx, err := strconv.ParseInt(rawDayIdx, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse day idx %q: %w", solKey, err)
+		}
+		userID, err := strconv.ParseInt(rawUserID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse user id %q: %w", solKey, err)
+		}
+
+		key := fmt.Sprintf("%s:solution:%010d:%d", ns, dayIdx, userID)
+		if err := tx.Set([]byte(key), sol); err != nil {
+			return fmt.Errorf("set %q: %w", key, err)
+		}
+	}
+
+	for _, key := range []string{pinnedKey, dayInfoKey, statsKey} {
+		if err := tx.Delete([]byte(key)); err != nil {
+			return fmt.Errorf("delete %q: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func joinStatsRecords(tx db.Tx) error {
+	for _, ns := range []string{"boardwhite:leetcode", "boardwhite:leetcode_chickens", "boardwhite:neetcode"} {
+		if err := joinNamespaceStats(tx, ns); err != nil {
+			return fmt.Errorf("join %q: %w", ns, err)
+		}
+	}
+
+	return nil
+}
+
+func joinNamespaceStats(tx db.Tx, ns string) error {
+	// collect keys first, they are deleted after iteration
+	newKeys := make([]string, 0)
+	msgToDayInfo := make(map[int]splitStatsDayInfo)
+	oldStats := joinedStats{
+		Solutions: make(map[string]json.RawMessage),
+		DaysInfo:  make(map[int64]splitStatsDayInfo),
+	}
+
+	err := db.IterateJson(tx, db.PrefixOpts(ns+":day_info:"), func(key string, dayInfo splitStatsDayInfo) error {
+		newKeys = append(newKeys, key)
+		msgToDayInfo[dayInfo.MessageID] = splitStatsDayInfo{DayIdx: dayInfo.DayIdx, PublishedAt: dayInfo.PublishedAt}
+		oldStats.DaysInfo[dayInfo.DayIdx] = splitStatsDayInfo{DayIdx: dayInfo.DayIdx, PublishedAt: dayInfo.PublishedAt}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterate day info: %w", err)
+	}
+
+	pinnedMessages := make([]int, 0)
+	pinnedPrefix := ns + ":pinned_message:"
+	err = db.IterateJson(tx, db.PrefixOpts(pinnedPrefix), func(key string, dayIdx int64) error {
+		newKeys = append(newKeys, key)
+		messageID, err := strconv.Atoi(strings.TrimPrefix(key, pinnedPrefix))
+		if err != nil {
+			return fmt.Errorf("parse message id %q: %w", key, err)
+		}
+
+		pinnedMessages = append(pinnedMessages, messageID)
+		if _, ok := msgToDayInfo[messageID]; !ok {
+			// the day was published again, the older message has no day info of its own
+			msgToDayInfo[messageID] = oldStats.DaysInfo[dayIdx]
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterate pinned messages: %w", err)
+	}
+	sort.Ints(pinnedMessages)
+
+	solutionPrefix := ns + ":solution:"
+	err = tx.Iterate(db.PrefixOpts(solutionPrefix), func(key, val []byte) error {
+		newKeys = append(newKeys, string(key))
+		rawDayIdx, rawUserID, ok := strings.Cut(strings.TrimPrefix(string(key), solutionPrefix), ":")
+		if !ok {
+			return fmt.Errorf("invalid solution key %q", key)
+		}
+		dayIdx, err := strconv.ParseInt(rawDayIdx, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse day idx %q: %w", key, err)
+		}
+
+		oldStats.Solutions[fmt.Sprintf("%d|%s", dayIdx, rawUserID)] = bytes.Clone(val)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterate solutions: %w", err)
+	}
+
+	if len(newKeys) == 0 {
+		return nil
+	}
+
+	pinnedKey, dayInfoKey, statsKey := ns+":pinned_messages", ns+":pinned_to_stats_day_info", ns+":stats"
+	if err := db.SetJson(tx, pinnedKey, pinnedMessages); err != nil {
+		return fmt.Errorf("set %q: %w", pinnedKey, err)
+	}
+	if err := db.SetJson(tx, dayInfoKey, msgToDayInfo); err != nil {
+		return fmt.Errorf("set %q: %w", dayInfoKey, err)
+	}
+	if err := db.SetJson(tx, statsKey, oldStats); err != nil {
+		return fmt.Errorf("set %q: %w", statsKey, err)
+	}
+
+	for _, key := range newKeys {
+		if err := tx.Delete([]byte(key)); err != nil {
+			return fmt.Errorf("delete %q: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// keys of 0005 are copied to not depend on the current dbq layout
+
+const (
+	dbqQueuePrefix = "dbq:queue:"
+	dbqSeqPrefix   = "dbq:seq:"
+)
+
+// isDBQTaskKey reports whether the key is dbq:queue:<name>:<kind>:<id> with a zero-padded id
+func isDBQTaskKey(key string) bool {
+	idx := strings.LastIndexByte(key, ':')
+	if idx == -1 || len(key)-idx-1 != 20 {
+		return false
+	}
+	_, err := strconv.ParseUint(key[idx+1:], 10, 64)
+	return err == nil
+}
+
+func splitDBQQueues(tx db.Tx) error {
+	type queue struct {
+		key   string
+		name  string
+		kind  string
+		tasks []map[string]json.RawMessage
+	}
+
+	// collect queues first, they are deleted after iteration
+	queues := make([]queue, 0)
+	err := tx.Iterate(db.PrefixOpts(dbqQueuePrefix), func(key, val []byte) error {
+		if isDBQTaskKey(string(key)) {
+			return nil
+		}
+
+		q := queue{key: string(key), kind: "pending"}
+		q.name = strings.TrimPrefix(q.key, dbqQueuePrefix)
+		if name, ok := strings.CutSuffix(q.name, ":dlx"); ok {
+			q.name, q.kind = name, "dlx"
+		}
+		if err := json.Unmarshal(val, &q.tasks); err != nil {
+			return fmt.Errorf("unmarshall %q: %w", key, err)
+		}
+
+		queues = append(queues, q)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterate queues: %w", err)
+	}
+
+	seqs := make(map[string]uint64)
+	for _, q := range queues {
+		for _, task := range q.tasks {
+			seqs[q.name]++
+			id := seqs[q.name]
+			task["id"] = json.RawMessage(strconv.FormatUint(id, 10))
+
+			key := fmt.Sprintf("%s%s:%s:%020d", dbqQueuePrefix, q.name, q.kind, id)
+			if err := db.SetJson(tx, key, task); err != nil {
+				return fmt.Errorf("set %q: %w", key, err)
+			}
+		}
+
+		if err := tx.Delete([]byte(q.key)); err != nil {
+			return fmt.Errorf("delete %q: %w", q.key, err)
+		}
+	}
+
+	for name, seq := range seqs {
+		key := dbqSeqPrefix + name
+		if err := db.SetJson(tx, key, seq); err != nil {
+			return fmt.Errorf("set %q: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func joinDBQQueues(tx db.Tx) error {
+	taskKeys := make([]string, 0)
+	queues := make(map[string][]map[string]json.RawMessage)
+	err := tx.Iterate(db.PrefixOpts(dbqQueuePrefix), func(key, val []byte) error {
+		if !isDBQTaskKey(string(key)) {
+			return nil
+		}
+		taskKeys = append(taskKeys, string(key))
+
+		var task map[string]json.RawMessage
+		if err := json.Unmarshal(val, &task); err != nil {
+			return fmt.Errorf("unmarshall %q: %w", key, err)
+		}
+		delete(task, "id")
+
+		rest := strings.TrimPrefix(string(key), dbqQueuePrefix)
+		rest = rest[:strings.LastIndexByte(rest, ':')]
+		queueKey := dbqQueuePrefix + strings.TrimSuffix(rest, ":pending")
+		queues[queueKey] = append(queues[queueKey], task)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterate tasks: %w", err)
+	}
+
+	for _, key := range taskKeys {
+		if err := tx.Delete([]byte(key)); err != nil {
+			return fmt.Errorf("delete %q: %w", key, err)
+		}
+	}
+	for key, tasks := range queues {
+		if err := db.SetJson(tx, key, tasks); err != nil {
+			return fmt.Errorf("set %q: %w", key, err)
+		}
+	}
+
+	seqKeys := make([]string, 0)
+	err = tx.Iterate(db.PrefixOpts(dbqSeqPrefix), func(key, _ []byte) error {
+		seqKeys = append(seqKeys, string(key))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterate seqs: %w", err)
+	}
+	for _, key := range seqKeys {
+		if err := tx.Delete([]byte(key)); err != nil {
+			return fmt.Errorf("delete %q: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// addDueTimeToDBQKeys renames dbq:queue:<name>:pending:<id> to dbq:queue:<name>:pending:<due>:<id>,
+// existing tasks are due right away
+func addDueTimeToDBQKeys(tx db.Tx) error {
+	return renameDBQPendingKeys(tx, func(name, rest string) (string, bool) {
+		if strings.Contains(rest, ":") {
+			return "", false
+		}
+		return fmt.Sprintf("%s%s:pending:%020d:%s", dbqQueuePrefix, name, 0, rest), true
+	})
+}
+
+func removeDueTimeFromDBQKeys(tx db.Tx) error {
+	return renameDBQPendingKeys(tx, func(name, rest string) (string, bool) {
+		_, id, ok := strings.Cut(rest, ":")
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("%s%s:pending:%s", dbqQueuePrefix, name, id), true
+	})
+}
+
+// renameDBQPendingKeys renames pending task keys, rename gets a queue name and a key part after ":pending:"
+func renameDBQPendingKeys(tx db.Tx, rename func(name, rest string) (string, bool)) error {
+	renames := make(map[string]string)
+	vals := make(map[string][]byte)
+	err := tx.Iterate(db.PrefixOpts(dbqQueuePrefix), func(key, val []byte) error {
+		name, rest, ok := strings.Cut(strings.TrimPrefix(string(key), dbqQueuePrefix), ":pending:")
+		if !ok || !isDBQTaskKey(string(key)) {
+			return nil
+		}
+		newKey, ok := rename(name, rest)
+		if !ok {
+			return nil
+		}
+
+		renames[string(key)] = newKey
+		vals[string(key)] = bytes.Clone(val)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterate tasks: %w", err)
+	}
+
+	for key, newKey := range renames {
+		if err := tx.Delete([]byte(key)); err != nil {
+			return fmt.Errorf("delete %q: %w", key, err)
+		}
+		if err := tx.Set([]byte(newKey), vals[key]); err != nil {
+			return fmt.Errorf("set %q: %w", newKey, err)
+		}
+	}
+
+	return nil
+}
+
+// retryDBQDLXTasks moves dbq:queue:<name>:dlx:<id> tasks to pending, dlx used to hold tasks to retry
+// and now holds tasks which ran out of retries
+func retryDBQDLXTasks(tx db.Tx) error {
+	type task struct {
+		key string
+		val []byte
+	}
+
+	tasks := make([]task, 0)
+	err := tx.Iterate(db.PrefixOpts(dbqQueuePrefix), func(key, val []byte) error {
+		if isDBQTaskKey(string(key)) && strings.Contains(string(key), ":dlx:") {
+			tasks = append(tasks, task{key: string(key), val: bytes.Clone(val)})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterate tasks: %w", err)
+	}
+
+	for _, t := range tasks {
+		idx := strings.LastIndex(t.key, ":dlx:")
+		newKey := fmt.Sprintf("%s:pending:%020d:%s", t.key[:idx], 0, t.key[idx+len(":dlx:"):])
+		if err := tx.Delete([]byte(t.key)); err != nil {
+			return fmt.Errorf("delete %q: %w", t.key, err)
+		}
+		if err := tx.Set([]byte(newKey), t.val); err != nil {
+			return fmt.Errorf("set %q: %w", newKey, err)
+		}
+	}
+
+	return nil
+}
+
+// keepDBQPendingTasks reverts nothing, the old consumer executes pending tasks the same way
+func keepDBQPendingTasks(tx db.Tx) error {
+	return nil
+}
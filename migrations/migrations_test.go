@@ -0,0 +1,226 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/boar-d-white-foundation/drone/db/memdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bdb := memdb.New()
+	err := bdb.Start(ctx)
+	require.NoError(t, err)
+	defer bdb.Stop()
+
+	err = Migrate(ctx, bdb)
+	require.NoError(t, err)
+}
+
+func TestSplitStatsIntoRecords(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bdb := memdb.New()
+	err := bdb.Start(ctx)
+	require.NoError(t, err)
+	defer bdb.Stop()
+
+	publishedAt := time.Date(2024, 3, 18, 0, 5, 0, 0, time.UTC)
+	err = bdb.Do(ctx, func(tx db.Tx) error {
+		err := db.SetJson(tx, "boardwhite:neetcode:pinned_messages", []int{100, 200})
+		require.NoError(t, err)
+
+		err = db.SetJson(tx, "boardwhite:neetcode:pinned_to_stats_day_info", map[int]any{
+			100: map[string]any{"day_idx": 0, "published_at": publishedAt},
+			200: map[string]any{"day_idx": 1, "published_at": publishedAt},
+		})
+		require.NoError(t, err)
+
+		err = db.SetJson(tx, "boardwhite:neetcode:stats", map[string]any{
+			"solutions": map[string]any{
+				"0|42": map[string]any{"update": map[string]any{"update_id": 1}},
+				"1|42": map[string]any{"update": map[string]any{"update_id": 2}},
+				"1|43": map[string]any{"update": map[string]any{"update_id": 3}},
+			},
+			"days_info": map[string]any{},
+		})
+		require.NoError(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = Migrate(ctx, bdb)
+	require.NoError(t, err)
+
+	err = bdb.View(ctx, func(tx db.ReadTx) error {
+		for _, key := range []string{
+			"boardwhite:neetcode:pinned_messages",
+			"boardwhite:neetcode:pinned_to_stats_day_info",
+			"boardwhite:neetcode:stats",
+		} {
+			_, err := tx.Get([]byte(key))
+			require.ErrorIs(t, err, db.ErrKeyNotFound)
+		}
+
+		type dayInfo struct {
+			DayIdx      int64     `json:"day_idx"`
+			MessageID   int       `json:"message_id"`
+			PublishedAt time.Time `json:"published_at"`
+		}
+		info, err := db.GetJson[dayInfo](tx, "boardwhite:neetcode:day_info:0000000001")
+		require.NoError(t, err)
+		require.Equal(t, dayInfo{DayIdx: 1, MessageID: 200, PublishedAt: publishedAt}, info)
+
+		dayIdx, err := db.GetJson[int64](tx, "boardwhite:neetcode:pinned_message:100")
+		require.NoError(t, err)
+		require.Equal(t, int64(0), dayIdx)
+
+		var solutionKeys []string
+		err = tx.Iterate(db.PrefixOpts("boardwhite:neetcode:solution:"), func(key, val []byte) error {
+			solutionKeys = append(solutionKeys, string(key))
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"boardwhite:neetcode:solution:0000000000:42",
+			"boardwhite:neetcode:solution:0000000001:42",
+			"boardwhite:neetcode:solution:0000000001:43",
+		}, solutionKeys)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestJoinStatsRecords(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bdb := memdb.New()
+	err := bdb.Start(ctx)
+	require.NoError(t, err)
+	defer bdb.Stop()
+
+	publishedAt := time.Date(2024, 3, 18, 0, 5, 0, 0, time.UTC)
+	err = bdb.Do(ctx, func(tx db.Tx) error {
+		ns := "boardwhite:leetcode"
+		require.NoError(t, db.SetJson(tx, ns+":day_info:0000000000", map[string]any{
+			"day_idx": 0, "message_id": 100, "published_at": publishedAt,
+		}))
+		require.NoError(t, db.SetJson(tx, ns+":day_info:0000000001", map[string]any{
+			"day_idx": 1, "message_id": 300, "published_at": publishedAt,
+		}))
+		require.NoError(t, db.SetJson(tx, ns+":pinned_message:100", 0))
+		require.NoError(t, db.SetJson(tx, ns+":pinned_message:200", 1))
+		require.NoError(t, db.SetJson(tx, ns+":pinned_message:300", 1))
+		require.NoError(t, db.SetJson(tx, ns+":solution:0000000000:42", map[string]any{"update": map[string]any{}}))
+		require.NoError(t, db.SetJson(tx, ns+":solution:0000000001:43", map[string]any{"update": map[string]any{}}))
+		return nil
+	})
+	require.NoError(t, err)
+
+	snapshot := func() map[string]string {
+		result := make(map[string]string)
+		err := bdb.View(ctx, func(tx db.ReadTx) error {
+			return tx.Iterate(db.IterOpts{}, func(key, val []byte) error {
+				if string(key) != db.AppliedMigrationsKey {
+					result[string(key)] = string(val)
+				}
+				return nil
+			})
+		})
+		require.NoError(t, err)
+		return result
+	}
+
+	require.NoError(t, Migrate(ctx, bdb))
+	migrated := snapshot()
+
+	require.NoError(t, db.MigrateDown(ctx, bdb, db.AppliedMigrationsKey, All(), "0003"))
+	err = bdb.View(ctx, func(tx db.ReadTx) error {
+		pinned, err := db.GetJson[[]int](tx, "boardwhite:leetcode:pinned_messages")
+		require.NoError(t, err)
+		require.Equal(t, []int{100, 200, 300}, pinned)
+
+		var keys []string
+		err = tx.Iterate(db.PrefixOpts("boardwhite:leetcode:"), func(key, _ []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"boardwhite:leetcode:pinned_messages",
+			"boardwhite:leetcode:pinned_to_stats_day_info",
+			"boardwhite:leetcode:stats",
+		}, keys)
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, Migrate(ctx, bdb))
+	require.Equal(t, migrated, snapshot())
+}
+
+func TestSplitDBQQueues(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bdb := memdb.New()
+	err := bdb.Start(ctx)
+	require.NoError(t, err)
+	defer bdb.Stop()
+
+	// 0002 drops the post_code_snippet queue, so it's not used here
+	name := "boardwhite:test"
+	err = bdb.Do(ctx, func(tx db.Tx) error {
+		require.NoError(t, db.SetJson(tx, "dbq:queue:"+name, []map[string]any{
+			{"name": name, "ttl": 2, "args": 1},
+			{"name": name, "ttl": 2, "args": 2},
+		}))
+		require.NoError(t, db.SetJson(tx, "dbq:queue:"+name+":dlx", []map[string]any{
+			{"name": name, "ttl": 1, "args": 3},
+		}))
+		return nil
+	})
+	require.NoError(t, err)
+
+	snapshot := func() map[string]string {
+		result := make(map[string]string)
+		err := bdb.View(ctx, func(tx db.ReadTx) error {
+			return tx.Iterate(db.PrefixOpts("dbq:"), func(key, val []byte) error {
+				result[string(key)] = string(val)
+				return nil
+			})
+		})
+		require.NoError(t, err)
+		return result
+	}
+	before := snapshot()
+
+	require.NoError(t, db.MigrateUp(ctx, bdb, db.AppliedMigrationsKey, All(), "0006"))
+	pending := "dbq:queue:" + name + ":pending:00000000000000000000:"
+	require.Equal(t, map[string]string{
+		pending + "00000000000000000001":                  `{"args":1,"id":1,"name":"` + name + `","ttl":2}`,
+		pending + "00000000000000000002":                  `{"args":2,"id":2,"name":"` + name + `","ttl":2}`,
+		"dbq:queue:" + name + ":dlx:00000000000000000003": `{"args":3,"id":3,"name":"` + name + `","ttl":1}`,
+		"dbq:seq:" + name:                                 "3",
+	}, snapshot())
+
+	require.NoError(t, db.MigrateDown(ctx, bdb, db.AppliedMigrationsKey, All(), "0004"))
+	require.Equal(t, before, snapshot())
+
+	// dlx tasks used to be retried, so 0007 moves them to pending
+	require.NoError(t, Migrate(ctx, bdb))
+	require.Equal(t, map[string]string{
+		pending + "00000000000000000001": `{"args":1,"id":1,"name":"` + name + `","ttl":2}`,
+		pending + "00000000000000000002": `{"args":2,"id":2,"name":"` + name + `","ttl":2}`,
+		pending + "00000000000000000003": `{"args":3,"id":3,"name":"` + name + `","ttl":1}`,
+		"dbq:seq:" + name:                "3",
+	}, snapshot())
+}
@@ -31,6 +31,28 @@ func (b LinearBackoff) GetDelay(attempt int) (time.Duration, bool) {
 	return b.Delay, true
 }
 
+// ExponentialBackoff doubles the delay every attempt starting from Initial and capping at Max
+type ExponentialBackoff struct {
+	Initial     time.Duration
+	Max         time.Duration
+	MaxAttempts int
+}
+
+func (b ExponentialBackoff) GetDelay(attempt int) (time.Duration, bool) {
+	if attempt >= b.MaxAttempts {
+		return 0, false
+	}
+
+	delay := b.Initial
+	for range attempt {
+		if delay >= b.Max/2 {
+			return b.Max, true
+		}
+		delay *= 2
+	}
+	return min(delay, b.Max), true
+}
+
 func Do[T any](ctx context.Context, name string, backoff Backoff, f func() (T, error)) (T, error) {
 	slog.Info("started retry", slog.String("name", name))
 	for attempt := 0; ; attempt++ {
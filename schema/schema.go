@@ -0,0 +1,18 @@
+package schema
+
+import (
+	"github.com/boar-d-white-foundation/drone/boardwhite"
+	"github.com/boar-d-white-foundation/drone/config"
+	"github.com/boar-d-white-foundation/drone/db"
+)
+
+// New returns a schema of all keys stored by drone for the configured communities,
+// importing the package declares all other drone keys in db.DefaultKeyRegistry
+func New(cfg config.Config) *db.Schema {
+	s := db.NewSchema()
+	for _, community := range cfg.AllCommunities() {
+		boardwhite.RegisterSchema(s, community.Name)
+	}
+	s.AddKeys(db.DefaultKeyRegistry)
+	return s
+}
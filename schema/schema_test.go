@@ -0,0 +1,16 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/boar-d-white-foundation/drone/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyCollisions(t *testing.T) {
+	t.Parallel()
+
+	keys := db.DefaultKeyRegistry.Keys()
+	require.NotEmpty(t, keys)
+	require.Empty(t, db.DefaultKeyRegistry.Collisions())
+}
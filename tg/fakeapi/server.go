@@ -0,0 +1,309 @@
+// Package fakeapi is a local Bot API server for tests, point tg.Service to it with cfg.Tg.APIURL
+package fakeapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// maxPollTimeout bounds getUpdates long polling, so a stopped bot doesn't wait for its last poll
+const maxPollTimeout = time.Second
+
+// Call is a recorded Bot API request
+type Call struct {
+	Method string
+	// Params are request fields, non-string JSON values are kept encoded
+	Params map[string]string
+	// Files are names of uploaded files by field
+	Files map[string]string
+	// Result is the message returned to the bot, nil for methods not returning messages
+	Result *tele.Message
+}
+
+// Server records calls of the bot and serves updates added by the test.
+// It implements methods used by tg.Service, others fail with 404.
+type Server struct {
+	srv *httptest.Server
+	bot tele.User
+
+	mu            sync.Mutex
+	calls         []Call
+	updates       []tele.Update
+	nextMessageID int
+	// changed is closed and replaced on every call and update
+	changed chan struct{}
+}
+
+// NewServer starts a server serving getMe as bot
+func NewServer(bot tele.User) *Server {
+	s := &Server{
+		bot:           bot,
+		nextMessageID: 1,
+		changed:       make(chan struct{}),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// AddMessage adds an update with the message, its ID and date are set if empty
+func (s *Server) AddMessage(msg tele.Message) tele.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg.ID == 0 {
+		msg.ID = s.newMessageID()
+	}
+	if msg.Unixtime == 0 {
+		msg.Unixtime = time.Now().Unix()
+	}
+	s.addUpdate(tele.Update{Message: &msg})
+	return msg
+}
+
+// AddUpdate adds an update for getUpdates, its ID is set by the server
+func (s *Server) AddUpdate(update tele.Update) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addUpdate(update)
+}
+
+func (s *Server) addUpdate(update tele.Update) {
+	update.ID = len(s.updates) + 1
+	s.updates = append(s.updates, update)
+	s.notify()
+}
+
+// Calls returns recorded calls of the methods, all calls if none are given
+func (s *Server) Calls(methods ...string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.filterCalls(methods)
+}
+
+// WaitCalls waits until there are at least n calls of the method and returns all of them
+func (s *Server) WaitCalls(method string, n int, timeout time.Duration) ([]Call, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		calls, changed := s.filterCalls([]string{method}), s.changed
+		s.mu.Unlock()
+		if len(calls) >= n {
+			return calls, nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return calls, fmt.Errorf("got %d calls of %s in %s, want %d", len(calls), method, timeout, n)
+		}
+	}
+}
+
+func (s *Server) filterCalls(methods []string) []Call {
+	result := make([]Call, 0)
+	for _, call := range s.calls {
+		if len(methods) == 0 || slices.Contains(methods, call.Method) {
+			result = append(result, call)
+		}
+	}
+	return result
+}
+
+func (s *Server) newMessageID() int {
+	id := s.nextMessageID
+	s.nextMessageID++
+	return id
+}
+
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+type response struct {
+	OK          bool   `json:"ok"`
+	Result      any    `json:"result,omitempty"`
+	ErrorCode   int    `json:"error_code,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// paths are /bot<token>/<method>
+	_, method, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if !ok {
+		writeResponse(w, http.StatusNotFound, response{ErrorCode: http.StatusNotFound, Description: "Not Found"})
+		return
+	}
+	params, files, err := parseRequest(r)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, response{ErrorCode: http.StatusBadRequest, Description: err.Error()})
+		return
+	}
+
+	if method == "getUpdates" {
+		writeResponse(w, http.StatusOK, response{OK: true, Result: s.getUpdates(r, params)})
+		return
+	}
+
+	result, err := s.handle(method, params, files)
+	if err != nil {
+		writeResponse(w, http.StatusNotFound, response{ErrorCode: http.StatusNotFound, Description: err.Error()})
+		return
+	}
+	writeResponse(w, http.StatusOK, response{OK: true, Result: result})
+}
+
+func (s *Server) handle(method string, params, files map[string]string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	call := Call{
+		Method: method,
+		Params: params,
+		Files:  files,
+	}
+	var result any
+	switch method {
+	case "getMe":
+		return s.bot, nil
+	case "sendMessage", "sendSticker", "sendDocument", "sendPhoto":
+		msg, err := s.newMessage(params, files)
+		if err != nil {
+			return nil, err
+		}
+		call.Result, result = msg, msg
+	case "editMessageText":
+		id, err := strconv.Atoi(params["message_id"])
+		if err != nil {
+			return nil, fmt.Errorf("parse message_id: %w", err)
+		}
+		msg, err := s.newMessage(params, files)
+		if err != nil {
+			return nil, err
+		}
+		msg.ID = id
+		call.Result, result = msg, msg
+	case "pinChatMessage", "unpinChatMessage", "setMessageReaction", "deleteMessage", "setWebhook", "deleteWebhook":
+		result = true
+	default:
+		return nil, fmt.Errorf("method %s is not supported", method)
+	}
+
+	s.calls = append(s.calls, call)
+	s.notify()
+	return result, nil
+}
+
+func (s *Server) newMessage(params, files map[string]string) (*tele.Message, error) {
+	chatID, err := strconv.ParseInt(params["chat_id"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse chat_id: %w", err)
+	}
+	msg := tele.Message{
+		ID:       s.newMessageID(),
+		Sender:   &s.bot,
+		Unixtime: time.Now().Unix(),
+		Chat:     &tele.Chat{ID: chatID},
+		Text:     params["text"],
+		Caption:  params["caption"],
+	}
+	if threadID, ok := params["message_thread_id"]; ok {
+		if msg.ThreadID, err = strconv.Atoi(threadID); err != nil {
+			return nil, fmt.Errorf("parse message_thread_id: %w", err)
+		}
+	}
+	if stickerID, ok := params["sticker"]; ok {
+		msg.Sticker = &tele.Sticker{File: tele.File{FileID: stickerID}}
+	}
+	if name, ok := files["document"]; ok {
+		msg.Document = &tele.Document{FileName: name}
+	}
+	if _, ok := files["photo"]; ok {
+		msg.Photo = &tele.Photo{}
+	}
+	return &msg, nil
+}
+
+// getUpdates returns updates starting from the offset, it waits for them like the real Bot API does
+func (s *Server) getUpdates(r *http.Request, params map[string]string) []tele.Update {
+	offset, _ := strconv.Atoi(params["offset"])
+	timeoutSeconds, _ := strconv.Atoi(params["timeout"])
+	deadline := time.After(min(time.Duration(timeoutSeconds)*time.Second, maxPollTimeout))
+	for {
+		s.mu.Lock()
+		updates, changed := slices.Clone(s.updates[min(max(offset-1, 0), len(s.updates)):]), s.changed
+		s.mu.Unlock()
+		if len(updates) > 0 {
+			return updates
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return updates
+		case <-r.Context().Done():
+			return updates
+		}
+	}
+}
+
+// parseRequest reads JSON and multipart requests sent by telebot
+func parseRequest(r *http.Request) (map[string]string, map[string]string, error) {
+	params, files := make(map[string]string), make(map[string]string)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return nil, nil, fmt.Errorf("parse multipart form: %w", err)
+		}
+		for field, values := range r.MultipartForm.Value {
+			params[field] = values[0]
+		}
+		for field, headers := range r.MultipartForm.File {
+			files[field] = headers[0].Filename
+		}
+		return params, files, nil
+	}
+
+	var fields map[string]json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&fields)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, fmt.Errorf("decode json: %w", err)
+	}
+	for field, value := range fields {
+		var str string
+		if err := json.Unmarshal(value, &str); err == nil {
+			params[field] = str
+		} else {
+			params[field] = string(value)
+		}
+	}
+	return params, files, nil
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
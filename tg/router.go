@@ -0,0 +1,246 @@
+package tg
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// ErrBadArgs is returned by Args accessors, the router replies to it with the command usage
+var ErrBadArgs = errors.New("bad command args")
+
+// Args are whitespace separated arguments of a command
+type Args struct {
+	raw    string
+	fields []string
+}
+
+func NewArgs(raw string) Args {
+	raw = strings.TrimSpace(raw)
+	return Args{
+		raw:    raw,
+		fields: strings.Fields(raw),
+	}
+}
+
+// Raw returns arguments as they were sent
+func (a Args) Raw() string {
+	return a.raw
+}
+
+func (a Args) Len() int {
+	return len(a.fields)
+}
+
+// Strings returns all arguments
+func (a Args) Strings() []string {
+	return slices.Clone(a.fields)
+}
+
+func (a Args) String(i int) (string, error) {
+	if i >= len(a.fields) {
+		return "", fmt.Errorf("%w: missing argument %d", ErrBadArgs, i+1)
+	}
+	return a.fields[i], nil
+}
+
+// StringOr returns the argument or def if there are fewer arguments
+func (a Args) StringOr(i int, def string) string {
+	if i >= len(a.fields) {
+		return def
+	}
+	return a.fields[i]
+}
+
+func (a Args) Int(i int) (int, error) {
+	s, err := a.String(i)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: argument %d is not a number: %q", ErrBadArgs, i+1, s)
+	}
+	return n, nil
+}
+
+// Max checks there are at most n arguments
+func (a Args) Max(n int) error {
+	if len(a.fields) > n {
+		return fmt.Errorf("%w: expected at most %d arguments, got %d", ErrBadArgs, n, len(a.fields))
+	}
+	return nil
+}
+
+type CommandHandler func(c tele.Context, args Args) error
+
+// Command is a bot command handled by a Router
+type Command struct {
+	Name    string
+	Aliases []string
+	// Args describes arguments in /help, e.g. "<queue> [kind]"
+	Args        string
+	Description string
+	// ChatID limits the command to a chat, any chat if 0
+	ChatID int64
+	// ThreadID limits the command to a thread of the chat, any thread if 0
+	ThreadID int
+	Handler  CommandHandler
+}
+
+func (cmd Command) usage() string {
+	return strings.TrimSpace(fmt.Sprintf("/%s %s", cmd.Name, cmd.Args))
+}
+
+func (cmd Command) allowed(msg *tele.Message) bool {
+	if cmd.ChatID != 0 && (msg.Chat == nil || msg.Chat.ID != cmd.ChatID) {
+		return false
+	}
+	return cmd.ThreadID == 0 || msg.ThreadID == cmd.ThreadID
+}
+
+// overlaps reports whether both commands are allowed in some thread
+func (cmd Command) overlaps(other Command) bool {
+	if cmd.ChatID != 0 && other.ChatID != 0 && cmd.ChatID != other.ChatID {
+		return false
+	}
+	return cmd.ThreadID == 0 || other.ThreadID == 0 || cmd.ThreadID == other.ThreadID
+}
+
+// Router dispatches /cmd@botname args messages to commands, so text handlers don't parse commands themselves.
+// It handles /help listing commands allowed in the chat, chats without commands get no reply.
+// A name may be shared by commands of different chats, e.g. of every community.
+type Router struct {
+	botName  string
+	commands []Command
+	byName   map[string][]int
+}
+
+// NewRouter returns a router, commands addressed to bots other than botName are ignored
+func NewRouter(botName string) *Router {
+	r := &Router{
+		botName: botName,
+		byName:  make(map[string][]int),
+	}
+	r.commands = append(r.commands, Command{
+		Name:        "help",
+		Description: "list commands",
+		Handler: func(c tele.Context, _ Args) error {
+			help := r.help(c.Message())
+			if help == "" {
+				return nil
+			}
+			return reply(c, help)
+		},
+	})
+	r.byName["help"] = []int{0}
+	return r
+}
+
+func (r *Router) Handle(cmd Command) error {
+	if cmd.Handler == nil {
+		return fmt.Errorf("command %q has no handler", cmd.Name)
+	}
+	names := append([]string{cmd.Name}, cmd.Aliases...)
+	for _, name := range names {
+		for _, idx := range r.byName[name] {
+			if r.commands[idx].overlaps(cmd) {
+				return fmt.Errorf("command %q is already registered", name)
+			}
+		}
+	}
+
+	for _, name := range names {
+		r.byName[name] = append(r.byName[name], len(r.commands))
+	}
+	r.commands = append(r.commands, cmd)
+	return nil
+}
+
+// Register registers the router as a text handler
+func (r *Router) Register(registry HandlerRegistry) {
+	registry.RegisterHandler(tele.OnText, "CommandRouter", r.OnText)
+}
+
+// ParseCommand splits /cmd@botname args into the command name, the bot name and args,
+// ok is false if the text is not a command
+func ParseCommand(text string) (name, botName string, args Args, ok bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", "", Args{}, false
+	}
+
+	head, rest := text[1:], ""
+	if idx := strings.IndexFunc(head, unicode.IsSpace); idx != -1 {
+		head, rest = head[:idx], head[idx:]
+	}
+	name, botName, _ = strings.Cut(head, "@")
+	if name == "" {
+		return "", "", Args{}, false
+	}
+
+	return name, botName, NewArgs(rest), true
+}
+
+func (r *Router) OnText(c tele.Context) error {
+	msg := c.Message()
+	if msg == nil {
+		return nil
+	}
+	name, botName, args, ok := ParseCommand(msg.Text)
+	if !ok || (botName != "" && !strings.EqualFold(botName, r.botName)) {
+		return nil
+	}
+	idx := slices.IndexFunc(r.byName[name], func(idx int) bool {
+		return r.commands[idx].allowed(msg)
+	})
+	if idx == -1 {
+		return nil
+	}
+
+	cmd := r.commands[r.byName[name][idx]]
+	err := cmd.Handler(c, args)
+	if errors.Is(err, ErrBadArgs) {
+		return reply(c, fmt.Sprintf("%s\nusage: %s", err, cmd.usage()))
+	}
+	if err != nil {
+		return fmt.Errorf("command %s: %w", cmd.Name, err)
+	}
+	return nil
+}
+
+// help lists commands allowed in the chat of the message, it's empty if /help is the only one
+func (r *Router) help(msg *tele.Message) string {
+	allowed := make([]Command, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		if cmd.allowed(msg) {
+			allowed = append(allowed, cmd)
+		}
+	}
+	if len(allowed) == 1 {
+		return ""
+	}
+
+	var result strings.Builder
+	for _, cmd := range allowed {
+
+		fmt.Fprintf(&result, "%s - %s", cmd.usage(), cmd.Description)
+		if len(cmd.Aliases) > 0 {
+			fmt.Fprintf(&result, " (also /%s)", strings.Join(cmd.Aliases, ", /"))
+		}
+		result.WriteString("\n")
+	}
+	return result.String()
+}
+
+func reply(c tele.Context, text string) error {
+	if err := c.Reply(text); err != nil {
+		return fmt.Errorf("reply %q: %w", text, err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package tg_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/tg"
+	"github.com/boar-d-white-foundation/drone/tg/fakeapi"
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestParseCommand(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		text    string
+		name    string
+		botName string
+		args    []string
+		ok      bool
+	}{
+		{text: "/pdf", name: "pdf", args: []string{}, ok: true},
+		{
+			text: "/pdf@drone_bot https://vc.ru/a", name: "pdf", botName: "drone_bot",
+			args: []string{"https://vc.ru/a"}, ok: true,
+		},
+		{text: "/dbq  tasks\tq1 dlx ", name: "dbq", args: []string{"tasks", "q1", "dlx"}, ok: true},
+		{text: "pdf", ok: false},
+		{text: "/", ok: false},
+		{text: "/@drone_bot", ok: false},
+	} {
+		name, botName, args, ok := tg.ParseCommand(tc.text)
+		require.Equal(t, tc.ok, ok, tc.text)
+		if !ok {
+			continue
+		}
+		require.Equal(t, tc.name, name, tc.text)
+		require.Equal(t, tc.botName, botName, tc.text)
+		require.Equal(t, tc.args, args.Strings(), tc.text)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	t.Parallel()
+
+	args := tg.NewArgs(" 42 #tag  x ")
+	require.Equal(t, "42 #tag  x", args.Raw())
+	require.Equal(t, 3, args.Len())
+
+	n, err := args.Int(0)
+	require.NoError(t, err)
+	require.Equal(t, 42, n)
+	_, err = args.Int(1)
+	require.ErrorIs(t, err, tg.ErrBadArgs)
+	_, err = args.String(3)
+	require.ErrorIs(t, err, tg.ErrBadArgs)
+	require.Equal(t, "def", args.StringOr(3, "def"))
+	require.NoError(t, args.Max(3))
+	require.ErrorIs(t, args.Max(2), tg.ErrBadArgs)
+}
+
+func TestRouter(t *testing.T) {
+	t.Parallel()
+
+	var called []string
+	handler := func(name string) tg.CommandHandler {
+		return func(_ tele.Context, args tg.Args) error {
+			called = append(called, name+":"+args.Raw())
+			return nil
+		}
+	}
+	router := tg.NewRouter("drone_bot")
+	require.NoError(t, router.Handle(tg.Command{Name: "pdf", Aliases: []string{"p"}, Handler: handler("pdf")}))
+	require.NoError(t, router.Handle(tg.Command{Name: "okr", ChatID: 1, ThreadID: 2, Handler: handler("okr")}))
+	require.Error(t, router.Handle(tg.Command{Name: "x", Aliases: []string{"pdf"}, Handler: handler("x")}))
+	require.Error(t, router.Handle(tg.Command{Name: "help", Handler: handler("help")}))
+	require.Error(t, router.Handle(tg.Command{Name: "nil"}))
+	require.Error(t, router.Handle(tg.Command{Name: "okr", ChatID: 1, Handler: handler("okr")}))
+	require.NoError(t, router.Handle(tg.Command{Name: "okr", ChatID: 1, ThreadID: 3, Handler: handler("okr3")}))
+	require.NoError(t, router.Handle(tg.Command{Name: "okr", ChatID: 4, Handler: handler("okr4")}))
+
+	bot := &tele.Bot{}
+	send := func(chatID int64, threadID int, text string) {
+		msg := &tele.Message{Text: text, Chat: &tele.Chat{ID: chatID}, ThreadID: threadID}
+		require.NoError(t, router.OnText(bot.NewContext(tele.Update{Message: msg})))
+	}
+	send(1, 0, "/pdf a")
+	send(1, 0, "/p@Drone_Bot b")
+	send(1, 0, "/pdf@other_bot c")
+	send(1, 0, "/x d")
+	send(1, 0, "/okr e")
+	send(3, 2, "/okr f")
+	send(1, 2, "/okr@drone_bot g")
+	send(1, 2, "pdf h")
+	send(1, 3, "/okr i")
+	send(4, 2, "/okr j")
+	require.Equal(t, []string{"pdf:a", "pdf:b", "okr:g", "okr3:i", "okr4:j"}, called)
+}
+
+func TestRouterHelp(t *testing.T) {
+	t.Parallel()
+
+	api := fakeapi.NewServer(tele.User{ID: 42, IsBot: true, Username: "drone_bot"})
+	t.Cleanup(api.Close)
+	bot, err := tele.NewBot(tele.Settings{URL: api.URL(), Token: "token", Offline: true})
+	require.NoError(t, err)
+
+	router := tg.NewRouter("drone_bot")
+	handler := func(tele.Context, tg.Args) error { return nil }
+	require.NoError(t, router.Handle(tg.Command{Name: "okr", Description: "okr", ChatID: 1, Handler: handler}))
+	send := func(chatID int64, text string) {
+		msg := &tele.Message{ID: 1, Text: text, Chat: &tele.Chat{ID: chatID}}
+		require.NoError(t, router.OnText(bot.NewContext(tele.Update{Message: msg})))
+	}
+
+	// help isn't answered in a chat without commands
+	send(2, "/help")
+	send(1, "/help")
+	calls, err := api.WaitCalls("sendMessage", 1, 5*time.Second)
+	require.NoError(t, err)
+	require.Len(t, calls, 1)
+	require.Equal(t, "1", calls[0].Params["chat_id"])
+	require.Equal(t, "/help - list commands\n/okr - okr\n", calls[0].Params["text"])
+}
@@ -12,26 +12,32 @@ import (
 	"github.com/boar-d-white-foundation/drone/alert"
 	"github.com/boar-d-white-foundation/drone/config"
 	"github.com/boar-d-white-foundation/drone/iterx"
-	"gopkg.in/telebot.v3"
 	tele "gopkg.in/telebot.v3"
 )
 
+// Target is a chat messages are sent to, ThreadID is 0 for chats without threads and the general thread
+type Target struct {
+	ChatID   int64
+	ThreadID int
+}
+
+// Client sends messages to any chat of the bot, existing messages are addressed by their chat and ID
 type Client interface {
 	BotID() int64
-	SendMonospace(threadID int, text string) (int, error)
-	SendMarkdownV2(threadID int, text string) (int, error)
-	SendText(threadID int, text string) (int, error)
-	SendSpoilerLink(threadID int, header, link string) (int, error)
-	SendSticker(threadID int, stickerID string) (int, error)
-	ReplyWithSticker(messageID int, stickerID string) (int, error)
-	ReplyWithSpoilerPhoto(messageID int, caption, name, mime string, reader io.ReadSeeker) (int, error)
-	ReplyWithDocument(messageID int, name, mime string, reader io.ReadSeeker) (int, error)
-	ReplyWithText(messageID int, text string) (int, error)
-	EditMessageText(messageID int, text string) error
-	Pin(id int) error
-	Unpin(id int) error
-	SetReaction(messageID int, reaction Reaction, isBig bool) error
-	Delete(id int) error
+	SendMonospace(to Target, text string) (int, error)
+	SendMarkdownV2(to Target, text string) (int, error)
+	SendText(to Target, text string) (int, error)
+	SendSpoilerLink(to Target, header, link string) (int, error)
+	SendSticker(to Target, stickerID string) (int, error)
+	ReplyWithSticker(chatID int64, messageID int, stickerID string) (int, error)
+	ReplyWithSpoilerPhoto(chatID int64, messageID int, caption, name, mime string, reader io.ReadSeeker) (int, error)
+	ReplyWithDocument(chatID int64, messageID int, name, mime string, reader io.ReadSeeker) (int, error)
+	ReplyWithText(chatID int64, messageID int, text string) (int, error)
+	EditMessageText(chatID int64, messageID int, text string) error
+	Pin(chatID int64, id int) error
+	Unpin(chatID int64, id int) error
+	SetReaction(chatID int64, messageID int, reaction Reaction, isBig bool) error
+	Delete(chatID int64, id int) error
 }
 
 type AdminClient interface {
@@ -44,54 +50,65 @@ type HandlerRegistry interface {
 	RegisterHandler(endpoint string, name string, f tele.HandlerFunc)
 }
 
+// Service is the only bot instance serving all chats, it sends alerts to the admin chat
 type Service struct {
-	alerts   *alert.Manager
-	bot      *tele.Bot
-	chatID   tele.ChatID
-	chat     *tele.Chat
-	handlers map[string][]handler
+	alerts      *alert.Manager
+	bot         *tele.Bot
+	adminChatID int64
+	handlers    map[string][]handler
 }
 
-var _ Client = (*Service)(nil)
+var _ AdminClient = (*Service)(nil)
 var _ HandlerRegistry = (*Service)(nil)
 
-func NewService(alerts *alert.Manager, token string, chatID int64, longPollerTimeout time.Duration) (*Service, error) {
-	poller := tele.LongPoller{
-		Timeout: longPollerTimeout,
+// NewService returns a service receiving updates with the poller, see NewLongPoller and Webhook
+func NewService(apiURL, token string, adminChatID int64, poller tele.Poller) (*Service, error) {
+	s := &Service{
+		adminChatID: adminChatID,
+		handlers:    make(map[string][]handler),
 	}
 	bot, err := tele.NewBot(tele.Settings{
+		URL:         apiURL,
 		Token:       token,
-		Poller:      &poller,
+		Poller:      poller,
 		Synchronous: true, // to ease of debug and avoid race conditions on data dependent updates
+		OnError: func(err error, _ tele.Context) {
+			s.alerts.Errorxf(err, "err in tg bot")
+		},
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	chat := tele.Chat{
-		ID: chatID,
-	}
-	return &Service{
-		alerts:   alerts,
-		bot:      bot,
-		chatID:   telebot.ChatID(chatID),
-		chat:     &chat,
-		handlers: make(map[string][]handler),
-	}, nil
+	s.bot = bot
+	// errors of handlers and pollers are sent to the admin chat by the same bot
+	s.alerts = alert.NewManager(s)
+	return s, nil
 }
 
-func NewBoardwhiteServiceFromConfig(cfg config.Config, alerts *alert.Manager) (*Service, error) {
-	tgService, err := NewService(alerts, cfg.Tg.Key, cfg.Boardwhite.ChatID, cfg.Tg.LongPollerTimeout)
-	if err != nil {
-		return nil, fmt.Errorf("new tg client: %w", err)
+// NewLongPoller returns a poller removing the webhook before polling, so the bot can be switched from webhook mode
+func NewLongPoller(timeout time.Duration) tele.Poller {
+	return &longPoller{
+		LongPoller: tele.LongPoller{
+			Timeout: timeout,
+		},
 	}
-
-	return tgService, nil
 }
 
-func NewAdminClientFromConfig(cfg config.Config) (AdminClient, error) {
-	// client doesn't need alerts
-	tgService, err := NewService(nil, cfg.Tg.Key, cfg.Tg.AdminChatID, cfg.Tg.LongPollerTimeout)
+func NewServiceFromConfig(cfg config.Config) (*Service, error) {
+	var poller tele.Poller
+	switch cfg.Tg.Updates {
+	case config.TgUpdatesWebhook:
+		poller = &Webhook{
+			PublicURL:   cfg.Tg.Webhook.PublicURL,
+			Listen:      cfg.Tg.Webhook.Listen,
+			SecretToken: cfg.Tg.Webhook.SecretToken,
+		}
+	default:
+		poller = NewLongPoller(cfg.Tg.LongPollerTimeout)
+	}
+
+	tgService, err := NewService(cfg.Tg.APIURL, cfg.Tg.Key, cfg.Tg.AdminChatID, poller)
 	if err != nil {
 		return nil, fmt.Errorf("new tg client: %w", err)
 	}
@@ -99,9 +116,9 @@ func NewAdminClientFromConfig(cfg config.Config) (AdminClient, error) {
 	return tgService, nil
 }
 
-func SetReactionFor(c Client, messageID int) func(Reaction) error {
+func SetReactionFor(c Client, chatID int64, messageID int) func(Reaction) error {
 	return func(reaction Reaction) error {
-		if err := c.SetReaction(messageID, reaction, false); err != nil {
+		if err := c.SetReaction(chatID, messageID, reaction, false); err != nil {
 			return fmt.Errorf("set reaction %v: %w", reaction, err)
 		}
 
@@ -176,9 +193,13 @@ func (s *Service) BotID() int64 {
 	return s.bot.Me.ID
 }
 
-func (s *Service) SendMonospace(threadID int, text string) (int, error) {
-	message, err := s.bot.Send(s.chatID, text, &tele.SendOptions{
-		ThreadID: threadID,
+func (s *Service) BotName() string {
+	return s.bot.Me.Username
+}
+
+func (s *Service) SendMonospace(to Target, text string) (int, error) {
+	message, err := s.bot.Send(tele.ChatID(to.ChatID), text, &tele.SendOptions{
+		ThreadID: to.ThreadID,
 		Entities: []tele.MessageEntity{
 			{
 				Type:   tele.EntityCode,
@@ -218,9 +239,9 @@ func (s *Service) SendMonospace(threadID int, text string) (int, error) {
 // >The last line of the block quotation**
 // >The second block quotation started right after the previous\r
 // >The third block quotation started right after the previous
-func (s *Service) SendMarkdownV2(threadID int, text string) (int, error) {
-	message, err := s.bot.Send(s.chatID, text, &tele.SendOptions{
-		ThreadID:  threadID,
+func (s *Service) SendMarkdownV2(to Target, text string) (int, error) {
+	message, err := s.bot.Send(tele.ChatID(to.ChatID), text, &tele.SendOptions{
+		ThreadID:  to.ThreadID,
 		ParseMode: tele.ModeMarkdownV2,
 	})
 	if err != nil {
@@ -230,9 +251,9 @@ func (s *Service) SendMarkdownV2(threadID int, text string) (int, error) {
 	return message.ID, nil
 }
 
-func (s *Service) SendText(threadID int, text string) (int, error) {
-	message, err := s.bot.Send(s.chatID, text, &tele.SendOptions{
-		ThreadID: threadID,
+func (s *Service) SendText(to Target, text string) (int, error) {
+	message, err := s.bot.Send(tele.ChatID(to.ChatID), text, &tele.SendOptions{
+		ThreadID: to.ThreadID,
 	})
 	if err != nil {
 		return 0, fmt.Errorf("send text %q: %w", text, err)
@@ -241,10 +262,10 @@ func (s *Service) SendText(threadID int, text string) (int, error) {
 	return message.ID, nil
 }
 
-func (s *Service) SendSpoilerLink(threadID int, header, link string) (int, error) {
+func (s *Service) SendSpoilerLink(to Target, header, link string) (int, error) {
 	payload := fmt.Sprintf("%s\n%s", header, link)
-	message, err := s.bot.Send(s.chatID, payload, &tele.SendOptions{
-		ThreadID:              threadID,
+	message, err := s.bot.Send(tele.ChatID(to.ChatID), payload, &tele.SendOptions{
+		ThreadID:              to.ThreadID,
 		DisableWebPagePreview: true,
 		Entities: []tele.MessageEntity{
 			{
@@ -261,14 +282,14 @@ func (s *Service) SendSpoilerLink(threadID int, header, link string) (int, error
 	return message.ID, nil
 }
 
-func (s *Service) SendSticker(threadID int, stickerID string) (int, error) {
+func (s *Service) SendSticker(to Target, stickerID string) (int, error) {
 	sticker := tele.Sticker{
 		File: tele.File{
 			FileID: stickerID,
 		},
 	}
-	message, err := s.bot.Send(s.chatID, &sticker, &tele.SendOptions{
-		ThreadID: threadID,
+	message, err := s.bot.Send(tele.ChatID(to.ChatID), &sticker, &tele.SendOptions{
+		ThreadID: to.ThreadID,
 	})
 	if err != nil {
 		return 0, fmt.Errorf("send sticker %q: %w", stickerID, err)
@@ -277,13 +298,13 @@ func (s *Service) SendSticker(threadID int, stickerID string) (int, error) {
 	return message.ID, nil
 }
 
-func (s *Service) ReplyWithSticker(messageID int, stickerID string) (int, error) {
+func (s *Service) ReplyWithSticker(chatID int64, messageID int, stickerID string) (int, error) {
 	sticker := tele.Sticker{
 		File: tele.File{
 			FileID: stickerID,
 		},
 	}
-	message, err := s.bot.Send(s.chatID, &sticker, &tele.SendOptions{
+	message, err := s.bot.Send(tele.ChatID(chatID), &sticker, &tele.SendOptions{
 		ReplyTo: &tele.Message{
 			ID: messageID,
 		},
@@ -295,7 +316,12 @@ func (s *Service) ReplyWithSticker(messageID int, stickerID string) (int, error)
 	return message.ID, nil
 }
 
-func (s *Service) ReplyWithSpoilerPhoto(messageID int, caption, name, mime string, reader io.ReadSeeker) (int, error) {
+func (s *Service) ReplyWithSpoilerPhoto(
+	chatID int64,
+	messageID int,
+	caption, name, mime string,
+	reader io.ReadSeeker,
+) (int, error) {
 	var message *tele.Message
 	var err error
 
@@ -309,7 +335,7 @@ func (s *Service) ReplyWithSpoilerPhoto(messageID int, caption, name, mime strin
 		},
 		HasSpoiler: true,
 	}
-	message, err = s.bot.Send(s.chatID, &photo, &opts)
+	message, err = s.bot.Send(tele.ChatID(chatID), &photo, &opts)
 	if err != nil && strings.Contains(err.Error(), "PHOTO_INVALID_DIMENSIONS") {
 		if _, err := reader.Seek(0, io.SeekStart); err != nil {
 			return 0, fmt.Errorf("err seek at start: %w", err)
@@ -319,7 +345,7 @@ func (s *Service) ReplyWithSpoilerPhoto(messageID int, caption, name, mime strin
 			FileName: name,
 			MIME:     mime,
 		}
-		message, err = s.bot.Send(s.chatID, &doc, &opts)
+		message, err = s.bot.Send(tele.ChatID(chatID), &doc, &opts)
 	}
 	if err != nil {
 		return 0, fmt.Errorf("reply with spoiler photo: %w", err)
@@ -328,7 +354,7 @@ func (s *Service) ReplyWithSpoilerPhoto(messageID int, caption, name, mime strin
 	return message.ID, nil
 }
 
-func (s *Service) ReplyWithDocument(messageID int, name, mime string, reader io.ReadSeeker) (int, error) {
+func (s *Service) ReplyWithDocument(chatID int64, messageID int, name, mime string, reader io.ReadSeeker) (int, error) {
 	opts := tele.SendOptions{
 		ReplyTo: &tele.Message{
 			ID: messageID,
@@ -339,7 +365,7 @@ func (s *Service) ReplyWithDocument(messageID int, name, mime string, reader io.
 		FileName: name,
 		MIME:     mime,
 	}
-	message, err := s.bot.Send(s.chatID, &doc, &opts)
+	message, err := s.bot.Send(tele.ChatID(chatID), &doc, &opts)
 	if err != nil {
 		return 0, fmt.Errorf("reply with document: %w", err)
 	}
@@ -347,13 +373,13 @@ func (s *Service) ReplyWithDocument(messageID int, name, mime string, reader io.
 	return message.ID, nil
 }
 
-func (s *Service) ReplyWithText(messageID int, text string) (int, error) {
+func (s *Service) ReplyWithText(chatID int64, messageID int, text string) (int, error) {
 	opts := tele.SendOptions{
 		ReplyTo: &tele.Message{
 			ID: messageID,
 		},
 	}
-	message, err := s.bot.Send(s.chatID, text, &opts)
+	message, err := s.bot.Send(tele.ChatID(chatID), text, &opts)
 	if err != nil {
 		return 0, fmt.Errorf("reply with text: %w", err)
 	}
@@ -361,10 +387,10 @@ func (s *Service) ReplyWithText(messageID int, text string) (int, error) {
 	return message.ID, nil
 }
 
-func (s *Service) EditMessageText(messageID int, newText string) error {
+func (s *Service) EditMessageText(chatID int64, messageID int, newText string) error {
 	msg := tele.StoredMessage{
 		MessageID: strconv.Itoa(messageID),
-		ChatID:    s.chat.ID,
+		ChatID:    chatID,
 	}
 	if _, err := s.bot.Edit(msg, newText); err != nil {
 		return fmt.Errorf("edit message text: %w", err)
@@ -373,10 +399,10 @@ func (s *Service) EditMessageText(messageID int, newText string) error {
 	return nil
 }
 
-func (s *Service) Pin(id int) error {
+func (s *Service) Pin(chatID int64, id int) error {
 	msg := tele.StoredMessage{
 		MessageID: strconv.Itoa(id),
-		ChatID:    s.chat.ID,
+		ChatID:    chatID,
 	}
 	if err := s.bot.Pin(msg, tele.Silent); err != nil {
 		return fmt.Errorf("pin msg %v: %w", id, err)
@@ -385,8 +411,8 @@ func (s *Service) Pin(id int) error {
 	return nil
 }
 
-func (s *Service) Unpin(id int) error {
-	if err := s.bot.Unpin(s.chat, id); err != nil {
+func (s *Service) Unpin(chatID int64, id int) error {
+	if err := s.bot.Unpin(&tele.Chat{ID: chatID}, id); err != nil {
 		return fmt.Errorf("unpin msg %v: %w", id, err)
 	}
 
@@ -400,9 +426,9 @@ type setMessageReactionReq struct {
 	IsBig     bool        `json:"is_big,omitempty"`
 }
 
-func (s *Service) SetReaction(messageID int, reaction Reaction, isBig bool) error {
+func (s *Service) SetReaction(chatID int64, messageID int, reaction Reaction, isBig bool) error {
 	req := setMessageReactionReq{
-		ChatID:    s.chatID,
+		ChatID:    tele.ChatID(chatID),
 		MessageID: messageID,
 		// currently, as non-premium users, bots can set up to one reaction per message
 		Reactions: []Reaction{reaction},
@@ -416,10 +442,10 @@ func (s *Service) SetReaction(messageID int, reaction Reaction, isBig bool) erro
 	return nil
 }
 
-func (s *Service) Delete(id int) error {
+func (s *Service) Delete(chatID int64, id int) error {
 	msg := tele.StoredMessage{
 		MessageID: strconv.Itoa(id),
-		ChatID:    s.chat.ID,
+		ChatID:    chatID,
 	}
 	err := s.bot.Delete(msg)
 	if err != nil {
@@ -433,7 +459,7 @@ func (s *Service) SendAlert(msg string) error {
 	chunkLen := 4096
 	for i := 0; i < len(msg); i += chunkLen {
 		chunk := msg[i:min(i+chunkLen, len(msg))]
-		if _, err := s.SendMonospace(0, chunk); err != nil {
+		if _, err := s.SendMonospace(Target{ChatID: s.adminChatID}, chunk); err != nil {
 			return fmt.Errorf("send alert chunk %q: %w", chunk, err)
 		}
 	}
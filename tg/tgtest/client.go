@@ -0,0 +1,338 @@
+// Package tgtest provides an in-memory tg.Client for handler unit tests
+package tgtest
+
+import (
+	"io"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/boar-d-white-foundation/drone/tg"
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+// MessageKind is the tg.Client method a message was sent with
+type MessageKind string
+
+const (
+	KindMonospace   MessageKind = "monospace"
+	KindMarkdownV2  MessageKind = "markdown_v2"
+	KindText        MessageKind = "text"
+	KindSpoilerLink MessageKind = "spoiler_link"
+	KindSticker     MessageKind = "sticker"
+	KindPhoto       MessageKind = "photo"
+	KindDocument    MessageKind = "document"
+)
+
+// Message is a message sent through the Client
+type Message struct {
+	ID       int
+	Kind     MessageKind
+	ChatID   int64
+	ThreadID int
+	// ReplyTo is the ID of the replied message, 0 if it's not a reply
+	ReplyTo int
+	// Text is the text of the message, the caption of a photo or the sticker ID
+	Text string
+	// Name of a sent file
+	Name string
+	Data []byte
+}
+
+// MessageRef addresses a message of a chat
+type MessageRef struct {
+	ChatID    int64
+	MessageID int
+}
+
+type Reaction struct {
+	ChatID    int64
+	MessageID int
+	Reaction  tg.Reaction
+	IsBig     bool
+}
+
+type Edit struct {
+	ChatID    int64
+	MessageID int
+	Text      string
+}
+
+// Client records calls of tg.AdminClient, message IDs are assigned sequentially starting from 1 in all chats
+type Client struct {
+	botID int64
+
+	mu            sync.Mutex
+	nextMessageID int
+	messages      []Message
+	pinned        []MessageRef
+	reactions     []Reaction
+	edits         []Edit
+	deleted       []MessageRef
+	alerts        []string
+}
+
+var _ tg.AdminClient = (*Client)(nil)
+
+func NewClient(botID int64) *Client {
+	return &Client{
+		botID:         botID,
+		nextMessageID: 1,
+	}
+}
+
+// Bot returns the user of the bot, e.g. as a sender of a replied message
+func (c *Client) Bot() *tele.User {
+	return &tele.User{ID: c.botID, IsBot: true, Username: "drone_bot"}
+}
+
+// NewContext builds a context of the update for handlers, c.Bot().Me of the context is the client bot.
+// Handlers must send messages through the Client, the context is not connected to telegram.
+func (c *Client) NewContext(update tele.Update) tele.Context {
+	bot, err := tele.NewBot(tele.Settings{Offline: true, Synchronous: true})
+	if err != nil {
+		panic(err) // offline bot doesn't make requests
+	}
+	bot.Me = c.Bot()
+	return bot.NewContext(update)
+}
+
+// NewMessageContext builds a context of a message update
+func (c *Client) NewMessageContext(msg tele.Message) tele.Context {
+	return c.NewContext(tele.Update{Message: &msg})
+}
+
+func (c *Client) BotID() int64 {
+	return c.botID
+}
+
+func (c *Client) send(msg Message) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg.ID = c.nextMessageID
+	c.nextMessageID++
+	c.messages = append(c.messages, msg)
+	return msg.ID
+}
+
+func (c *Client) SendMonospace(to tg.Target, text string) (int, error) {
+	return c.send(Message{Kind: KindMonospace, ChatID: to.ChatID, ThreadID: to.ThreadID, Text: text}), nil
+}
+
+func (c *Client) SendMarkdownV2(to tg.Target, text string) (int, error) {
+	return c.send(Message{Kind: KindMarkdownV2, ChatID: to.ChatID, ThreadID: to.ThreadID, Text: text}), nil
+}
+
+func (c *Client) SendText(to tg.Target, text string) (int, error) {
+	return c.send(Message{Kind: KindText, ChatID: to.ChatID, ThreadID: to.ThreadID, Text: text}), nil
+}
+
+func (c *Client) SendSpoilerLink(to tg.Target, header, link string) (int, error) {
+	text := header + "\n" + link
+	return c.send(Message{Kind: KindSpoilerLink, ChatID: to.ChatID, ThreadID: to.ThreadID, Text: text}), nil
+}
+
+func (c *Client) SendSticker(to tg.Target, stickerID string) (int, error) {
+	return c.send(Message{Kind: KindSticker, ChatID: to.ChatID, ThreadID: to.ThreadID, Text: stickerID}), nil
+}
+
+func (c *Client) ReplyWithSticker(chatID int64, messageID int, stickerID string) (int, error) {
+	return c.send(Message{Kind: KindSticker, ChatID: chatID, ReplyTo: messageID, Text: stickerID}), nil
+}
+
+func (c *Client) ReplyWithSpoilerPhoto(
+	chatID int64,
+	messageID int,
+	caption, name, _ string,
+	reader io.ReadSeeker,
+) (int, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+	msg := Message{Kind: KindPhoto, ChatID: chatID, ReplyTo: messageID, Text: caption, Name: name, Data: data}
+	return c.send(msg), nil
+}
+
+func (c *Client) ReplyWithDocument(chatID int64, messageID int, name, _ string, reader io.ReadSeeker) (int, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+	return c.send(Message{Kind: KindDocument, ChatID: chatID, ReplyTo: messageID, Name: name, Data: data}), nil
+}
+
+func (c *Client) ReplyWithText(chatID int64, messageID int, text string) (int, error) {
+	return c.send(Message{Kind: KindText, ChatID: chatID, ReplyTo: messageID, Text: text}), nil
+}
+
+func (c *Client) EditMessageText(chatID int64, messageID int, text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.edits = append(c.edits, Edit{ChatID: chatID, MessageID: messageID, Text: text})
+	return nil
+}
+
+func (c *Client) Pin(chatID int64, id int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ref := MessageRef{ChatID: chatID, MessageID: id}
+	if !slices.Contains(c.pinned, ref) {
+		c.pinned = append(c.pinned, ref)
+	}
+	return nil
+}
+
+func (c *Client) Unpin(chatID int64, id int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pinned = slices.DeleteFunc(c.pinned, func(ref MessageRef) bool {
+		return ref == MessageRef{ChatID: chatID, MessageID: id}
+	})
+	return nil
+}
+
+func (c *Client) SetReaction(chatID int64, messageID int, reaction tg.Reaction, isBig bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reactions = append(c.reactions, Reaction{ChatID: chatID, MessageID: messageID, Reaction: reaction, IsBig: isBig})
+	return nil
+}
+
+func (c *Client) Delete(chatID int64, id int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deleted = append(c.deleted, MessageRef{ChatID: chatID, MessageID: id})
+	return nil
+}
+
+func (c *Client) SendAlert(msg string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.alerts = append(c.alerts, msg)
+	return nil
+}
+
+// Messages returns sent messages in the order they were sent
+func (c *Client) Messages() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.messages)
+}
+
+// Pinned returns IDs of currently pinned messages of the chat in the order they were pinned
+func (c *Client) Pinned(chatID int64) []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]int, 0)
+	for _, ref := range c.pinned {
+		if ref.ChatID == chatID {
+			result = append(result, ref.MessageID)
+		}
+	}
+	return result
+}
+
+func (c *Client) Reactions() []Reaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.reactions)
+}
+
+func (c *Client) Edits() []Edit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.edits)
+}
+
+func (c *Client) Deleted() []MessageRef {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.deleted)
+}
+
+func (c *Client) Alerts() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.alerts)
+}
+
+// RequireLastMessage checks the kind of the last sent message and returns it
+func (c *Client) RequireLastMessage(t testing.TB, kind MessageKind) Message {
+	t.Helper()
+
+	messages := c.Messages()
+	require.NotEmpty(t, messages, "no messages sent")
+	last := messages[len(messages)-1]
+	require.Equal(t, kind, last.Kind, "last message: %+v", last)
+	return last
+}
+
+func (c *Client) RequireMessagesCount(t testing.TB, n int) {
+	t.Helper()
+
+	require.Len(t, c.Messages(), n)
+}
+
+// RequireReaction checks the last reaction set on the message
+func (c *Client) RequireReaction(t testing.TB, chatID int64, messageID int, reaction tg.Reaction) {
+	t.Helper()
+
+	reactions := c.Reactions()
+	for i := len(reactions) - 1; i >= 0; i-- {
+		if reactions[i].ChatID == chatID && reactions[i].MessageID == messageID {
+			require.Equal(t, reaction, reactions[i].Reaction, "reaction on %d", messageID)
+			return
+		}
+	}
+	require.Failf(t, "no reaction", "no reaction on %d, reactions: %+v", messageID, reactions)
+}
+
+func (c *Client) RequireNoReaction(t testing.TB, chatID int64, messageID int) {
+	t.Helper()
+
+	for _, reaction := range c.Reactions() {
+		ref := MessageRef{ChatID: reaction.ChatID, MessageID: reaction.MessageID}
+		require.NotEqual(t, MessageRef{ChatID: chatID, MessageID: messageID}, ref, "unexpected reaction %+v", reaction)
+	}
+}
+
+func (c *Client) RequirePinned(t testing.TB, chatID int64, ids ...int) {
+	t.Helper()
+
+	require.Equal(t, append(make([]int, 0), ids...), c.Pinned(chatID))
+}
+
+// RequireLastEdit checks the last edit of the message and returns its text
+func (c *Client) RequireLastEdit(t testing.TB, chatID int64, messageID int) string {
+	t.Helper()
+
+	edits := c.Edits()
+	for i := len(edits) - 1; i >= 0; i-- {
+		if edits[i].ChatID == chatID && edits[i].MessageID == messageID {
+			return edits[i].Text
+		}
+	}
+	require.Failf(t, "no edit", "message %d is not edited, edits: %+v", messageID, edits)
+	return ""
+}
+
+func (c *Client) RequireNoAlerts(t testing.TB) {
+	t.Helper()
+
+	require.Empty(t, c.Alerts())
+}
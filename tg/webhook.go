@@ -0,0 +1,116 @@
+package tg
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+	// webhookShutdownTimeout bounds waiting for in-flight requests on stop, they are answered as soon as stop is closed
+	webhookShutdownTimeout = 5 * time.Second
+)
+
+// Webhook receives updates sent by telegram to its HTTP listener, the webhook is set on start and kept on stop,
+// so telegram keeps updates while the bot is down. Unlike tele.Webhook it rejects requests with a wrong
+// secret token and reports errors of the listener.
+type Webhook struct {
+	// PublicURL is the URL telegram sends updates to, it must lead to Listen
+	PublicURL string
+	// Listen is the address of the HTTP listener
+	Listen string
+	// SecretToken is sent by telegram in every request
+	SecretToken string
+}
+
+var _ tele.Poller = (*Webhook)(nil)
+
+func (w *Webhook) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	// listen before setting the webhook, so telegram doesn't send updates to a port nobody listens
+	listener, err := net.Listen("tcp", w.Listen)
+	if err != nil {
+		b.OnError(fmt.Errorf("webhook listen %s: %w", w.Listen, err), nil)
+		return
+	}
+
+	err = b.SetWebhook(&tele.Webhook{
+		SecretToken: w.SecretToken,
+		Endpoint:    &tele.WebhookEndpoint{PublicURL: w.PublicURL},
+	})
+	if err != nil {
+		_ = listener.Close()
+		b.OnError(fmt.Errorf("set webhook %s: %w", w.PublicURL, err), nil)
+		return
+	}
+	slog.Info("set webhook", slog.String("url", w.PublicURL), slog.String("listen", listener.Addr().String()))
+
+	server := &http.Server{
+		Handler:           w.handler(dest, stop),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		b.OnError(fmt.Errorf("webhook serve: %w", err), nil)
+	case <-stop:
+		ctx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			b.OnError(fmt.Errorf("webhook shutdown: %w", err), nil)
+		}
+	}
+}
+
+// handler passes updates to the bot, an update is acknowledged once the bot takes it
+func (w *Webhook) handler(dest chan<- tele.Update, stop <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token := r.Header.Get(secretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(w.SecretToken)) != 1 {
+			slog.Warn("webhook request with invalid secret token", slog.String("remote_addr", r.RemoteAddr))
+			http.Error(rw, "invalid secret token", http.StatusUnauthorized)
+			return
+		}
+
+		var update tele.Update
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(rw, fmt.Sprintf("decode update: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case dest <- update:
+		case <-stop:
+			// telegram retries updates until the bot is back
+			http.Error(rw, "bot is stopping", http.StatusServiceUnavailable)
+		case <-r.Context().Done():
+		}
+	})
+}
+
+// longPoller removes the webhook before polling, telegram doesn't return updates while it's set
+type longPoller struct {
+	tele.LongPoller
+}
+
+func (p *longPoller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	if err := b.RemoveWebhook(); err != nil {
+		b.OnError(fmt.Errorf("remove webhook: %w", err), nil)
+	}
+	p.LongPoller.Poll(b, dest, stop)
+}
@@ -0,0 +1,120 @@
+package tg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/boar-d-white-foundation/drone/tg"
+	"github.com/boar-d-white-foundation/drone/tg/fakeapi"
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+const testAdminChatID = 1
+
+// startTestService starts a service registering texts of received messages
+func startTestService(t *testing.T, api *fakeapi.Server, poller tele.Poller) <-chan string {
+	t.Helper()
+
+	service, err := tg.NewService(api.URL(), "token", testAdminChatID, poller)
+	require.NoError(t, err)
+	texts := make(chan string, 10)
+	service.RegisterHandler(tele.OnText, "test", func(c tele.Context) error {
+		texts <- c.Text()
+		return nil
+	})
+	service.Start()
+	t.Cleanup(service.Stop)
+	return texts
+}
+
+func requireText(t *testing.T, texts <-chan string, text string) {
+	t.Helper()
+
+	select {
+	case got := <-texts:
+		require.Equal(t, text, got)
+	case <-time.After(5 * time.Second):
+		require.Failf(t, "no update", "update %q is not handled", text)
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestWebhook(t *testing.T) {
+	t.Parallel()
+
+	api := fakeapi.NewServer(tele.User{ID: 42, IsBot: true, Username: "drone_bot"})
+	t.Cleanup(api.Close)
+	addr := freeAddr(t)
+	texts := startTestService(t, api, &tg.Webhook{
+		PublicURL:   "https://drone.example.com/tg",
+		Listen:      addr,
+		SecretToken: "secret",
+	})
+
+	calls, err := api.WaitCalls("setWebhook", 1, 5*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "https://drone.example.com/tg", calls[0].Params["url"])
+	require.Equal(t, "secret", calls[0].Params["secret_token"])
+
+	post := func(method, token string, body []byte) int {
+		req, err := http.NewRequest(method, "http://"+addr+"/tg", bytes.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("X-Telegram-Bot-Api-Secret-Token", token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	update := func(id int, text string) []byte {
+		body, err := json.Marshal(tele.Update{ID: id, Message: &tele.Message{
+			ID:     id,
+			Text:   text,
+			Chat:   &tele.Chat{ID: -100, Type: tele.ChatSuperGroup},
+			Sender: &tele.User{ID: 7},
+		}})
+		require.NoError(t, err)
+		return body
+	}
+
+	require.Equal(t, http.StatusUnauthorized, post(http.MethodPost, "", update(1, "no token")))
+	require.Equal(t, http.StatusUnauthorized, post(http.MethodPost, "wrong", update(2, "wrong token")))
+	require.Equal(t, http.StatusMethodNotAllowed, post(http.MethodGet, "secret", nil))
+	require.Equal(t, http.StatusBadRequest, post(http.MethodPost, "secret", []byte("{")))
+	require.Equal(t, http.StatusOK, post(http.MethodPost, "secret", update(3, "hello")))
+	requireText(t, texts, "hello")
+	require.Empty(t, texts)
+	require.Empty(t, api.Calls("sendMessage"), "unexpected alerts")
+}
+
+func TestLongPollerRemovesWebhook(t *testing.T) {
+	t.Parallel()
+
+	api := fakeapi.NewServer(tele.User{ID: 42, IsBot: true, Username: "drone_bot"})
+	t.Cleanup(api.Close)
+	texts := startTestService(t, api, tg.NewLongPoller(time.Second))
+
+	_, err := api.WaitCalls("deleteWebhook", 1, 5*time.Second)
+	require.NoError(t, err)
+	api.AddMessage(tele.Message{
+		Text:   "hello",
+		Chat:   &tele.Chat{ID: -100, Type: tele.ChatSuperGroup},
+		Sender: &tele.User{ID: 7},
+	})
+	requireText(t, texts, "hello")
+	require.Empty(t, api.Calls("sendMessage"), "unexpected alerts")
+}